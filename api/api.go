@@ -1,25 +1,59 @@
 package api
 
 import (
+	"context"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pranjalpokharel7/yudhishthira/blockchain"
-	"github.com/pranjalpokharel7/yudhishthira/p2p"
+	"github.com/pranjalpokharel7/yudhishthira/object"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
 	"github.com/pranjalpokharel7/yudhishthira/wallet"
 )
 
-const PORT = ":8080"
+// requests still running when the node shuts down get this long to finish
+const SHUTDOWN_TIMEOUT = 10 * time.Second
 
-func StartServer(wlt *wallet.Wallet, chain *blockchain.BlockChain) {
-	// uncomment below line for release mode API
-	// gin.SetMode(gin.ReleaseMode)
+// prefixes signed tokens, so the wallet signature over a token can never be one over a transaction id or block hash
+const TOKEN_SIGNATURE_TAG = "yudhishthira-token:"
 
-	go p2p.StartServer("3000", chain, wlt)
+// serves the API on the host:port until the context is cancelled, then waits for running requests
+// an empty auth token leaves POST requests and token signing open to anyone who can reach the node
+func StartServer(ctx context.Context, wlt *wallet.Wallet, chain *blockchain.BlockChain, schemas object.SchemaRegistry, listenAddress string, authToken string) error {
+	server := &http.Server{Addr: listenAddress, Handler: NewRouter(wlt, chain, schemas, authToken)}
 
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	utility.Infof("API listening on %s", listenAddress)
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	utility.Infof("API stopped")
+	return nil
+}
+
+// schemas are the category schemas objects are validated against before hashing
+func NewRouter(wlt *wallet.Wallet, chain *blockchain.BlockChain, schemas object.SchemaRegistry, authToken string) *gin.Engine {
+	// GIN_MODE still wins over the log level of the node
 	gin_mode := os.Getenv("GIN_MODE")
 	if gin_mode == "" {
-		gin_mode = "debug"
+		gin_mode = gin.ReleaseMode
+		if utility.DebugEnabled() {
+			gin_mode = gin.DebugMode
+		}
 	}
 	gin.SetMode(gin_mode)
 
@@ -27,21 +61,37 @@ func StartServer(wlt *wallet.Wallet, chain *blockchain.BlockChain) {
 
 	// middlewares
 	router.Use(CORSMiddleware())
+	router.Use(AuthMiddleware(authToken))
 
 	// block endpoint
 	router.GET("/block/last", GetLastBlockResponse(chain))
 	router.GET("/block/last/:n", GetLastNBlocksResponse(chain))
 	router.POST("/block/mine", PostMineBlock(chain, wlt))
+	router.GET("/block/:id", GetBlockResponse(chain))
 
 	// item endpoint
 	router.GET("/item/history/:itemhash", GetItemTransactionHistoryResponse(chain))
 	router.GET("/item/last-block/:itemhash", GetLastBlockWithItemResponse(chain))
 	router.GET("/item/calculate-hash/:itemid", CalculateItemHash())
+	router.POST("/item/calculate-hash", CalculateObjectHash(schemas))
+	router.POST("/item/verify-hash", VerifyObjectHash(schemas))
+	router.GET("/item/schemas", GetCategorySchemas(schemas))
 	router.GET("/item/owner/:itemhash", GetItemOwner(chain))
+	router.GET("/item/state/:itemhash", GetItemStateResponse(chain))
+	router.POST("/item/retire", PostLifecycleTransaction(wlt, chain, blockchain.TX_RETIRE))
+	router.POST("/item/recall", PostLifecycleTransaction(wlt, chain, blockchain.TX_RECALL))
+	router.POST("/item/report-lost", PostLifecycleTransaction(wlt, chain, blockchain.TX_REPORT_LOST))
+	router.POST("/item/clear-report", PostLifecycleTransaction(wlt, chain, blockchain.TX_CLEAR_REPORT))
+	router.POST("/item/assemble", PostCompositeTransaction(wlt, chain, blockchain.TX_ASSEMBLE))
+	router.POST("/item/disassemble", PostCompositeTransaction(wlt, chain, blockchain.TX_DISASSEMBLE))
+	router.GET("/item/:hash", GetItemResponse(chain))
+	router.GET("/item/:hash/price-history", GetItemPriceHistory(chain))
+	router.POST("/item/import", PostImportItems(wlt, chain, schemas))
 
 	// general wallet endpoint
 	router.GET("/wallet/info/:address", GetWalletInfoResponse(chain))
 	router.GET("/wallet/items/:address", GetWalletOwnedItemsResponse(chain))
+	router.GET("/wallet/:address/credits", GetWalletCredits(chain))
 
 	// personal wallet endpoint, TODO: combine with generalized wallet above
 	router.GET("/my-wallet/address", GetMyWalletAddressResponse(wlt))
@@ -51,12 +101,44 @@ func StartServer(wlt *wallet.Wallet, chain *blockchain.BlockChain) {
 	// transaction endpoint
 	router.GET("/transaction/last/:n", GetLastNTxsResponse(chain))
 	router.GET("/transaction/pool", GetTxPool())
+	router.GET("/transaction/locked", GetLockedTxs())
+	router.GET("/transaction/:txid", GetTransactionResponse(chain))
 	router.POST("/transaction/new", PostNewTransaction(wlt, chain))
-	router.POST("/transaction/coinbase", PostCoinbaseTransaction(wlt, chain))
+	router.POST("/transaction/coinbase", PostCoinbaseTransaction(wlt, chain, schemas))
+	router.POST("/transaction/unsigned", PostUnsignedTransaction(chain))
+	router.POST("/transaction/submit", PostSubmitTransaction(chain))
+	router.POST("/transaction/credits", PostCreditTransfer(wlt, chain))
+
+	// transfer offer endpoint, transfers here need the buyer's countersignature
+	router.POST("/transaction/offer", PostTransferOffer(wlt, chain))
+	router.GET("/transaction/offers/:address", GetTransferOffers())
+	router.POST("/transaction/offer/accept", PostAcceptOffer(wlt, chain))
+	router.POST("/transaction/offer/decline", PostDeclineOffer(wlt))
+
+	// swap endpoint, several items change hands at once once every seller has signed
+	router.POST("/transaction/swap", PostSwapTransaction(wlt, chain))
+	router.POST("/transaction/swap/sign", PostSignSwap(wlt, chain))
+
+	// escrow endpoint, the arbiter's node settles escrows it was named in
+	router.POST("/transaction/escrow", PostEscrowTransaction(wlt, chain))
+	router.POST("/transaction/escrow/release", PostEscrowSettlement(wlt, chain, true))
+	router.POST("/transaction/escrow/refund", PostEscrowSettlement(wlt, chain, false))
+
+	// validator endpoint, proof of authority networks only, the node wallet votes as validator
+	router.GET("/validators", GetValidators(chain))
+	router.POST("/validators/vote", PostValidatorVote(wlt, chain))
+
+	// peer endpoint, peers are only kept in memory
+	router.GET("/peers", GetPeers())
+	router.POST("/peers/add", PostAddPeer(chain))
+	router.POST("/peers/ban", PostBanPeer())
+
+	// market analytics endpoint, backed by the price index
+	router.GET("/stats/market", GetMarketStats(chain))
 
-	// token verification endpoint
-	router.GET("/token/sign/:token", SignToken(wlt))
+	// token verification endpoint, signing uses the node wallet key so it needs the token like POST requests
+	router.GET("/token/sign/:token", RequireToken(authToken), SignToken(wlt))
 	router.POST("/token/verify", VerifyToken())
 
-	router.Run(PORT)
+	return router
 }
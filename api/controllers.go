@@ -1,16 +1,20 @@
 package api
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/importer"
+	"github.com/pranjalpokharel7/yudhishthira/object"
 	"github.com/pranjalpokharel7/yudhishthira/p2p"
 	"github.com/pranjalpokharel7/yudhishthira/wallet"
 )
@@ -86,6 +90,16 @@ func GetItemTransactionHistoryResponse(chain *blockchain.BlockChain) gin.Handler
 			c.JSON(400, ErrorJSON{ErrorMsg: "provided hash can not be decoded"})
 			return
 		}
+		// ?provenance=true follows assemblies and disassemblies into the history of the parts
+		if c.Query("provenance") == "true" {
+			provenanceTxs, err := chain.ItemProvenance(itemHash)
+			if err != nil {
+				c.JSON(404, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+				return
+			}
+			c.JSON(200, provenanceTxs)
+			return
+		}
 		itemTxHistory := chain.TxsIncludingItem(itemHash)
 		c.JSON(200, itemTxHistory)
 	}
@@ -114,6 +128,56 @@ func GetWalletInfoResponse(chain *blockchain.BlockChain) gin.HandlerFunc {
 	return fn
 }
 
+// introduction credits of the wallet, mining blocks adds to them and every coinbase spends them
+func GetWalletCredits(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		walletAddress := c.Param("address")
+		credits, err := chain.WalletCredits(walletAddress)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad address: could not derive public key hash from address"})
+			return
+		}
+		allowance, err := blockchain.CoinbaseAllowance(walletAddress, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		creditInfo := map[string]interface{}{
+			"address":           walletAddress,
+			"credits":           credits,
+			"introduction_cost": chain.Params.IntroductionCost,
+			"allowance":         allowance,
+		}
+		c.JSON(200, creditInfo)
+	}
+	return fn
+}
+
+// moves introduction credits from the node wallet to another wallet
+func PostCreditTransfer(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		transferData := CreditTransferInput{}
+		if err := c.BindJSON(&transferData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		creditTx, err := blockchain.NewCreditTransfer(wlt, transferData.Destination, transferData.Amount, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		if rejection := p2p.AcceptToMemoryPool(*creditTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(creditTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*creditTx)
+
+		c.JSON(200, creditTx)
+	}
+	return fn
+}
+
 func GetWalletOwnedItemsResponse(chain *blockchain.BlockChain) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		walletAddress := c.Param("address")
@@ -205,43 +269,517 @@ func PostNewTransaction(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.Ha
 			c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string"})
 			return
 		}
-		newTx, err := blockchain.NewTransaction(wlt, newTxData.Destination, itemHash, newTxData.Amount, chain)
+		var newTx *blockchain.Tx
+		if newTxData.LockHeight != 0 || newTxData.LockTime != 0 {
+			newTx, err = blockchain.NewLockedTransaction(wlt, newTxData.Destination, itemHash, newTxData.Amount, newTxData.LockHeight, newTxData.LockTime, chain)
+		} else {
+			newTx, err = blockchain.NewTransaction(wlt, newTxData.Destination, itemHash, newTxData.Amount, chain)
+		}
 		if err != nil {
 			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
 			return
 		}
-		for _, nodeAddress := range p2p.KnownNodes {
-			p2p.SendTx(nodeAddress, *newTx)
+		// time locked transfers are held by the pool until they can be mined
+		if rejection := p2p.AcceptToMemoryPool(*newTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(newTx, rejection))
+			return
 		}
+		p2p.AnnounceTx(*newTx)
 
 		c.JSON(200, newTx)
 	}
 	return fn
 }
 
-func PostCoinbaseTransaction(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+func PostCoinbaseTransaction(wlt *wallet.Wallet, chain *blockchain.BlockChain, schemas object.SchemaRegistry) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		coinBaseTxData := CoinBaseTxFormInput{}
 		if err := c.BindJSON(&coinBaseTxData); err != nil {
 			c.AbortWithError(400, err)
 			return
 		}
-		itemHash := sha256.Sum256([]byte(coinBaseTxData.ItemHash))
-		coinBaseTx, err := blockchain.CoinBaseTransaction(wlt, itemHash[:], coinBaseTxData.Amount, chain)
+		var itemHash, metadataBlob, metadataHash []byte
+		if coinBaseTxData.Object != nil {
+			if err := schemas.Validate(coinBaseTxData.Object); err != nil {
+				c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("object does not match its category schema: %v", err)})
+				return
+			}
+			// the item hash is derived from the metadata so the two can always be checked against each other
+			itemHash = coinBaseTxData.Object.HashObject()
+			var err error
+			metadataBlob, err = coinBaseTxData.Object.MetadataBlob()
+			if err != nil {
+				c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+				return
+			}
+			// the blob is only stored once the coinbase is accepted, a rejected introduction leaves nothing behind
+			metadataHash = blockchain.MetadataHash(metadataBlob)
+		} else if coinBaseTxData.ItemHash != "" {
+			itemIDHash := sha256.Sum256([]byte(coinBaseTxData.ItemHash))
+			itemHash = itemIDHash[:]
+		} else {
+			c.JSON(400, ErrorJSON{ErrorMsg: "either item_hash or object is required"})
+			return
+		}
+
+		hashVersion := uint8(object.HASH_VERSION_LEGACY) // unused without metadata, the item hash is not an object hash then
+		if coinBaseTxData.Object != nil {
+			hashVersion = object.CURRENT_HASH_VERSION
+		}
+		coinBaseTx, err := blockchain.CoinBaseTransactionWithMetadata(wlt, itemHash, metadataHash, hashVersion, coinBaseTxData.Amount, chain)
 		if err != nil {
 			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
 			return
 		}
 
-		for _, nodeAddress := range p2p.KnownNodes {
-			p2p.SendTx(nodeAddress, *coinBaseTx)
+		if rejection := p2p.AcceptToMemoryPool(*coinBaseTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(coinBaseTx, rejection))
+			return
 		}
+		if metadataBlob != nil {
+			if _, err := chain.StoreMetadata(metadataBlob); err != nil {
+				p2p.RemoveFromMemoryPool([]blockchain.Tx{*coinBaseTx})
+				c.JSON(500, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+				return
+			}
+		}
+		p2p.AnnounceTx(*coinBaseTx)
 
 		c.JSON(200, coinBaseTx)
 	}
 	return fn
 }
 
+// builds a transfer for offline signing, the node does not need to hold the seller's keys
+func PostUnsignedTransaction(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		unsignedTxData := UnsignedTxFormInput{}
+		if err := c.BindJSON(&unsignedTxData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		itemHash, err := hex.DecodeString(unsignedTxData.ItemHash)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string"})
+			return
+		}
+		unsignedTx, err := blockchain.NewUnsignedTransaction(unsignedTxData.Source, unsignedTxData.Destination, itemHash, unsignedTxData.Amount, unsignedTxData.RequiresAcceptance, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		c.JSON(200, blockchain.NewUnsignedTxEnvelope(unsignedTx))
+	}
+	return fn
+}
+
+// accepts a fully signed transaction, either as json or as the gob bytes used on the wire
+// runs full validation, adds it to the memory pool and relays it to the known nodes
+func PostSubmitTransaction(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var tx blockchain.Tx
+		if c.ContentType() == "application/octet-stream" {
+			rawTx, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				c.JSON(400, RejectionToJSON(nil, blockchain.Reject(blockchain.REJECT_MALFORMED, err.Error())))
+				return
+			}
+			decodedTx, err := blockchain.DeserializeTxFromGOB(rawTx)
+			if err != nil {
+				c.JSON(400, RejectionToJSON(nil, blockchain.Reject(blockchain.REJECT_MALFORMED, "could not decode transaction bytes: "+err.Error())))
+				return
+			}
+			tx = *decodedTx
+		} else if err := c.ShouldBindJSON(&tx); err != nil {
+			c.JSON(400, RejectionToJSON(nil, blockchain.Reject(blockchain.REJECT_MALFORMED, "could not decode transaction json: "+err.Error())))
+			return
+		}
+
+		if rejection := p2p.AcceptToMemoryPool(tx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(&tx, rejection))
+			return
+		}
+
+		// a signed offer is announced as well, it waits in the offer pools for the buyer
+		p2p.AnnounceTx(tx)
+
+		c.JSON(200, tx)
+	}
+	return fn
+}
+
+func PostTransferOffer(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		newTxData := NewTxFormInput{}
+		if err := c.BindJSON(&newTxData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		itemHash, err := hex.DecodeString(newTxData.ItemHash)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string"})
+			return
+		}
+		offerTx, err := blockchain.NewTransferOffer(wlt, newTxData.Destination, itemHash, newTxData.Amount, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		if rejection := p2p.AcceptToMemoryPool(*offerTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(offerTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*offerTx)
+
+		c.JSON(200, offerTx)
+	}
+	return fn
+}
+
+// creates a lifecycle transaction of the given kind signed by the node wallet and relays it
+func PostLifecycleTransaction(wlt *wallet.Wallet, chain *blockchain.BlockChain, txType uint8) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		lifecycleTxData := LifecycleTxFormInput{}
+		if err := c.BindJSON(&lifecycleTxData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		itemHash, err := hex.DecodeString(lifecycleTxData.ItemHash)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string"})
+			return
+		}
+		lifecycleTx, err := blockchain.NewLifecycleTransaction(wlt, itemHash, txType, lifecycleTxData.Note, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		if rejection := p2p.AcceptToMemoryPool(*lifecycleTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(lifecycleTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*lifecycleTx)
+
+		c.JSON(200, lifecycleTx)
+	}
+	return fn
+}
+
+// creates an assembly or disassembly signed by the node wallet and relays it
+func PostCompositeTransaction(wlt *wallet.Wallet, chain *blockchain.BlockChain, txType uint8) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		compositeTxData := CompositeTxFormInput{}
+		if err := c.BindJSON(&compositeTxData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		compositeHash, err := hex.DecodeString(compositeTxData.ItemHash)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string"})
+			return
+		}
+		var partHashes [][]byte
+		for _, part := range compositeTxData.Parts {
+			partHash, err := hex.DecodeString(part)
+			if err != nil {
+				c.JSON(400, ErrorJSON{ErrorMsg: "bad part hash: could not decode part hex string " + part})
+				return
+			}
+			partHashes = append(partHashes, partHash)
+		}
+
+		var compositeTx *blockchain.Tx
+		if txType == blockchain.TX_ASSEMBLE {
+			compositeTx, err = blockchain.NewAssemblyTransaction(wlt, compositeHash, partHashes, compositeTxData.Note, chain)
+		} else {
+			compositeTx, err = blockchain.NewDisassemblyTransaction(wlt, compositeHash, partHashes, compositeTxData.Note, chain)
+		}
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		if rejection := p2p.AcceptToMemoryPool(*compositeTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(compositeTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*compositeTx)
+
+		c.JSON(200, compositeTx)
+	}
+	return fn
+}
+
+func GetItemStateResponse(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		itemHash, err := hex.DecodeString(c.Param("itemhash"))
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "provided hash can not be decoded"})
+			return
+		}
+		itemState, err := chain.ItemState(itemHash)
+		if err != nil {
+			c.JSON(404, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		c.JSON(200, itemState)
+	}
+	return fn
+}
+
+// lists pending offers where the address is either the seller or the buyer
+func GetTransferOffers() gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		pubKeyHash, err := wallet.PubKeyHashFromAddress(c.Param("address"))
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad address: could not derive public key hash from address"})
+			return
+		}
+
+		incoming := []blockchain.Tx{}
+		outgoing := []blockchain.Tx{}
+		swaps := []blockchain.Tx{}
+		for _, offer := range p2p.PendingOfferTxs() {
+			if offer.Type == blockchain.TX_SWAP {
+				for _, leg := range offer.Legs {
+					if bytes.Equal(leg.SellerHash, pubKeyHash) || bytes.Equal(leg.BuyerHash, pubKeyHash) {
+						swaps = append(swaps, offer)
+						break
+					}
+				}
+			} else if bytes.Equal(offer.BuyerHash, pubKeyHash) {
+				incoming = append(incoming, offer)
+			} else if bytes.Equal(offer.SellerHash, pubKeyHash) {
+				outgoing = append(outgoing, offer)
+			}
+		}
+		offersJSON := map[string]interface{}{
+			"incoming": incoming,
+			"outgoing": outgoing,
+			"swaps":    swaps,
+		}
+		c.JSON(200, offersJSON)
+	}
+	return fn
+}
+
+// creates a swap from the given legs, signed right away if the node wallet sells one of the items
+func PostSwapTransaction(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		swapData := SwapTxFormInput{}
+		if err := c.BindJSON(&swapData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		var legs []blockchain.TxLeg
+		for _, legData := range swapData.Legs {
+			itemHash, err := hex.DecodeString(legData.ItemHash)
+			if err != nil {
+				c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string " + legData.ItemHash})
+				return
+			}
+			buyerHash, err := wallet.PubKeyHashFromAddress(legData.Destination)
+			if err != nil {
+				c.JSON(400, ErrorJSON{ErrorMsg: "bad destination: could not derive public key hash from address " + legData.Destination})
+				return
+			}
+			legs = append(legs, blockchain.TxLeg{ItemHash: itemHash, BuyerHash: buyerHash, Amount: legData.Amount})
+		}
+		swapTx, err := blockchain.NewUnsignedSwapTransaction(legs, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		// the node wallet not being a seller is fine, the sellers sign through their own nodes
+		swapTx.SignSwap(wlt)
+
+		if rejection := p2p.AcceptToMemoryPool(*swapTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(swapTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*swapTx)
+
+		c.JSON(200, swapTx)
+	}
+	return fn
+}
+
+// adds the node wallet's signature to a pending swap
+func PostSignSwap(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		responseData := OfferResponseInput{}
+		if err := c.BindJSON(&responseData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		swapTx, ok := p2p.PendingOffer(responseData.TxID)
+		if !ok || swapTx.Type != blockchain.TX_SWAP {
+			c.JSON(404, ErrorJSON{ErrorMsg: "no pending swap with the given transaction id"})
+			return
+		}
+		// the pooled copy is shared, sign a copy with its own signer list
+		swapTx.Signers = append([]blockchain.TxSigner{}, swapTx.Signers...)
+		if err := swapTx.SignSwap(wlt); err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		if rejection := p2p.AcceptToMemoryPool(swapTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(&swapTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(swapTx)
+
+		c.JSON(200, swapTx)
+	}
+	return fn
+}
+
+// time locked transactions this node holds until a block may include them
+func GetLockedTxs() gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		lockedTxs := p2p.LockedPoolTxs()
+		if lockedTxs == nil {
+			lockedTxs = []blockchain.Tx{}
+		}
+		c.JSON(200, lockedTxs)
+	}
+	return fn
+}
+
+// hands an item of the node wallet to an arbiter on behalf of the buyer
+func PostEscrowTransaction(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		escrowData := EscrowTxFormInput{}
+		if err := c.BindJSON(&escrowData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		itemHash, err := hex.DecodeString(escrowData.ItemHash)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string"})
+			return
+		}
+		escrowTx, err := blockchain.NewEscrowTransaction(wlt, escrowData.Destination, escrowData.Arbiter, itemHash, escrowData.Amount, escrowData.LockHeight, escrowData.LockTime, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		if rejection := p2p.AcceptToMemoryPool(*escrowTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(escrowTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*escrowTx)
+
+		c.JSON(200, escrowTx)
+	}
+	return fn
+}
+
+// settles an escrow with the node wallet as the arbiter, release hands the item to the buyer and refund returns it
+func PostEscrowSettlement(wlt *wallet.Wallet, chain *blockchain.BlockChain, release bool) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		settlementData := EscrowSettlementInput{}
+		if err := c.BindJSON(&settlementData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		itemHash, err := hex.DecodeString(settlementData.ItemHash)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "bad item hash: could not decode item hex string"})
+			return
+		}
+		settlementTx, err := blockchain.NewEscrowSettlement(wlt, itemHash, release, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		if rejection := p2p.AcceptToMemoryPool(*settlementTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(settlementTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*settlementTx)
+
+		c.JSON(200, settlementTx)
+	}
+	return fn
+}
+
+func PostAcceptOffer(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		responseData := OfferResponseInput{}
+		if err := c.BindJSON(&responseData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		offerTx, ok := p2p.PendingOffer(responseData.TxID)
+		if !ok {
+			c.JSON(404, ErrorJSON{ErrorMsg: "no pending offer with the given transaction id"})
+			return
+		}
+		if err := offerTx.AcceptTransaction(wlt); err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		// the accepted transfer is an ordinary transaction from here on
+		if rejection := p2p.AcceptToMemoryPool(offerTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(&offerTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(offerTx)
+
+		c.JSON(200, offerTx)
+	}
+	return fn
+}
+
+func PostDeclineOffer(wlt *wallet.Wallet) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		responseData := OfferResponseInput{}
+		if err := c.BindJSON(&responseData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		offerTx, ok := p2p.PendingOffer(responseData.TxID)
+		if !ok {
+			c.JSON(404, ErrorJSON{ErrorMsg: "no pending offer with the given transaction id"})
+			return
+		}
+		buyerPublicKey, signature, err := blockchain.DeclineTransaction(&offerTx, wlt)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+
+		p2p.RemovePendingOffer(responseData.TxID)
+		for _, nodeAddress := range p2p.Peers() {
+			p2p.SendDecline(nodeAddress, offerTx.TxID, buyerPublicKey, signature)
+		}
+
+		declinedJSON := map[string]interface{}{
+			"declined": true,
+		}
+		c.JSON(200, declinedJSON)
+	}
+	return fn
+}
+
 func VerifyToken() gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		signedTokenData := TokenVerifyModel{}
@@ -250,7 +788,7 @@ func VerifyToken() gin.HandlerFunc {
 			return
 		}
 
-		hashedOriginalToken := sha256.Sum256([]byte(signedTokenData.OriginalToken))
+		hashedOriginalToken := sha256.Sum256([]byte(TOKEN_SIGNATURE_TAG + signedTokenData.OriginalToken))
 		signedToken, err := hex.DecodeString(signedTokenData.SignedToken)
 		if err != nil {
 			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
@@ -285,7 +823,7 @@ func VerifyToken() gin.HandlerFunc {
 func SignToken(wlt *wallet.Wallet) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		tokenData := c.Param("token")
-		hashedToken := sha256.Sum256([]byte(tokenData))
+		hashedToken := sha256.Sum256([]byte(TOKEN_SIGNATURE_TAG + tokenData))
 		signedToken, err := rsa.SignPSS(rand.Reader, &wlt.PrivateKey, crypto.SHA256, hashedToken[:], nil)
 		if err != nil {
 			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
@@ -311,6 +849,120 @@ func CalculateItemHash() gin.HandlerFunc {
 	return fn
 }
 
+// validates the object against its category schema and returns the canonical hash over its attributes
+func CalculateObjectHash(schemas object.SchemaRegistry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var objectInstance object.Object
+		if err := c.BindJSON(&objectInstance); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+		if err := schemas.Validate(&objectInstance); err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("object does not match its category schema: %v", err)})
+			return
+		}
+		legacyItemHash, _ := objectInstance.HashObjectVersion(object.HASH_VERSION_LEGACY)
+		itemHashJSON := map[string]interface{}{
+			"item_hash":        hex.EncodeToString(objectInstance.HashObject()),
+			"hash_version":     object.CURRENT_HASH_VERSION,
+			"legacy_item_hash": hex.EncodeToString(legacyItemHash),
+			"object":           objectInstance,
+		}
+		c.JSON(200, itemHashJSON)
+	}
+	return fn
+}
+
+// checks a provided item hash against the object under every known hash scheme
+func VerifyObjectHash(schemas object.SchemaRegistry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		verifyData := ObjectHashVerifyInput{}
+		if err := c.BindJSON(&verifyData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+		itemHash, err := hex.DecodeString(verifyData.ItemHash)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "provided hash can not be decoded"})
+			return
+		}
+		// values are normalized by the schema before hashing, so the schema has to run first here as well
+		if err := schemas.Validate(&verifyData.Object); err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("object does not match its category schema: %v", err)})
+			return
+		}
+
+		hashVersion, verified := verifyData.Object.MatchHashVersion(itemHash)
+		verifiedJSON := map[string]interface{}{
+			"verified": verified,
+		}
+		if verified {
+			verifiedJSON["hash_version"] = hashVersion
+		}
+		c.JSON(200, verifiedJSON)
+	}
+	return fn
+}
+
+// bulk introduction of items, the request body is the csv, json-lines or manifest file itself
+func PostImportItems(wlt *wallet.Wallet, chain *blockchain.BlockChain, schemas object.SchemaRegistry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		format := c.DefaultQuery("format", importer.FORMAT_CSV)
+		batchSize, err := strconv.Atoi(c.DefaultQuery("batch", strconv.Itoa(importer.DEFAULT_BATCH_SIZE)))
+		if err != nil || batchSize <= 0 {
+			c.JSON(400, ErrorJSON{ErrorMsg: "invalid batch size provided: must be a positive integer"})
+			return
+		}
+
+		rows, err := importer.Parse(format, c.Request.Body)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("could not parse import file: %v", err)})
+			return
+		}
+
+		walletPubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+		if err != nil {
+			c.JSON(500, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		pendingCoinbases := 0
+		for _, tx := range p2p.MemoryPoolTxs() {
+			if tx.IsCoinbase() && bytes.Equal(tx.BuyerHash, walletPubKeyHash) {
+				pendingCoinbases++
+			}
+		}
+
+		report, err := importer.Import(rows, schemas, wlt, chain, importer.Options{
+			BatchSize:        batchSize,
+			PendingCoinbases: pendingCoinbases,
+			SubmitBatch: func(txs []blockchain.Tx, chainItems map[string]bool) []error {
+				submitErrors := make([]error, len(txs))
+				for i, tx := range txs {
+					if rejection := p2p.AcceptIntroductionToMemoryPool(tx, chainItems, chain); rejection != nil {
+						submitErrors[i] = rejection
+						continue
+					}
+					p2p.AnnounceTx(tx)
+				}
+				return submitErrors
+			},
+		})
+		if err != nil {
+			c.JSON(500, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		c.JSON(200, report)
+	}
+	return fn
+}
+
+func GetCategorySchemas(schemas object.SchemaRegistry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		c.JSON(200, schemas)
+	}
+	return fn
+}
+
 func GetItemOwner(chain *blockchain.BlockChain) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		itemHashString := c.Param("itemhash")
@@ -319,19 +971,115 @@ func GetItemOwner(chain *blockchain.BlockChain) gin.HandlerFunc {
 			c.JSON(400, ErrorJSON{ErrorMsg: "provided hash can not be decoded"})
 			return
 		}
-		lastTxWithItem, err := blockchain.LastTxWithItem(chain, itemHash)
+		// swaps keep the buyer per leg, so the owner comes from the item state rather than the last transaction
+		itemState, err := chain.ItemState(itemHash)
 		if err != nil {
 			c.JSON(400, ErrorJSON{ErrorMsg: "provided hash can not be decoded"})
 			return
 		}
 		txOwnerInfo := map[string]interface{}{
-			"item_owner": lastTxWithItem.BuyerHash,
+			"item_owner": itemState.Owner,
 		}
 		c.JSON(200, txOwnerInfo)
 	}
 	return fn
 }
 
+// sales of the item read from the price index, with the appreciation of the last sale over its base price
+func GetItemPriceHistory(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		itemHash, err := hex.DecodeString(c.Param("hash"))
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "provided hash can not be decoded"})
+			return
+		}
+		priceHistory, err := chain.ItemPriceHistory(itemHash)
+		if err != nil {
+			c.JSON(500, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		// an item that was never sold has an empty history, only look it up in the chain then
+		if len(priceHistory.Sales) == 0 {
+			if itemExists, err := chain.FindItemExists(itemHash); err != nil || !itemExists {
+				c.JSON(404, ErrorJSON{ErrorMsg: "item with hash not found"})
+				return
+			}
+		}
+		c.JSON(200, priceHistory)
+	}
+	return fn
+}
+
+var marketPeriods = map[string]uint64{
+	"day":   blockchain.PERIOD_DAY,
+	"week":  blockchain.PERIOD_WEEK,
+	"month": blockchain.PERIOD_MONTH,
+}
+
+// market volume per period and per brand and category, period is day, week, month or a number of seconds
+func GetMarketStats(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		periodParam := c.DefaultQuery("period", "day")
+		period, isNamed := marketPeriods[periodParam]
+		if !isNamed {
+			var err error
+			period, err = strconv.ParseUint(periodParam, 10, 64)
+			if err != nil || period == 0 {
+				c.JSON(400, ErrorJSON{ErrorMsg: "period must be day, week, month or a positive number of seconds"})
+				return
+			}
+		}
+		marketStats, err := chain.MarketStats(period)
+		if err != nil {
+			c.JSON(500, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		c.JSON(200, marketStats)
+	}
+	return fn
+}
+
+// descriptive data of the item, only returned once verified against the on-chain commitment
+func GetItemResponse(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		itemHash, err := hex.DecodeString(c.Param("hash"))
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "provided hash can not be decoded"})
+			return
+		}
+		itemState, err := chain.ItemState(itemHash)
+		if err != nil {
+			c.JSON(404, ErrorJSON{ErrorMsg: "item with hash not found"})
+			return
+		}
+
+		itemInfo := map[string]interface{}{
+			"item_hash":     hex.EncodeToString(itemHash),
+			"introduced_by": itemState.Introducer,
+			"introduced_at": itemState.IntroducedAt,
+			"item_owner":    itemState.Owner,
+			"state":         itemState,
+			"metadata_hash": nil,
+			"metadata":      nil,
+			"verified":      false,
+		}
+		// composite items and parts released by a disassembly have no coinbase, hence no metadata commitment
+		coinbaseTx, err := chain.CoinbaseTxOfItem(itemHash)
+		if err == nil && len(coinbaseTx.MetadataHash) != 0 {
+			itemInfo["metadata_hash"] = coinbaseTx.MetadataHash
+			metadata, err := chain.VerifiedItemMetadata(itemHash)
+			if err != nil {
+				itemInfo["metadata_error"] = fmt.Sprintf("%v", err)
+			} else {
+				itemInfo["metadata"] = metadata
+				itemInfo["verified"] = true
+			}
+		}
+		c.JSON(200, itemInfo)
+	}
+	return fn
+}
+
 // TODO: 1. check validity of received transactions, 2. check if transactions exist previously in blockchain
 func PostMineBlock(chain *blockchain.BlockChain, wlt *wallet.Wallet) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
@@ -348,28 +1096,191 @@ func PostMineBlock(chain *blockchain.BlockChain, wlt *wallet.Wallet) gin.Handler
 				c.AbortWithError(400, err)
 				return
 			}
+			if tx.IsPending() {
+				c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("transaction %x is pending buyer acceptance", tx.TxID)})
+				return
+			}
+			// the block is announced to peers, so it may only hold transactions they would accept
+			if rejection := blockchain.ValidateTransaction(tx, chain); rejection != nil {
+				c.JSON(RejectionStatus(rejection), RejectionToJSON(tx, rejection))
+				return
+			}
 			txPool = append(txPool, *tx)
 		}
 		newBlock := blockchain.CreateBlock()
 		newBlock.AddTransactionsToBlock(txPool)
-		newBlock.MineBlock(chain, wlt)
-		chain.AddBlock(newBlock)
+		if err := newBlock.MineBlock(chain, wlt); err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		if err := chain.AddBlock(newBlock); err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
 
-		// TODO: we clear the memory pool here but edit in later commit to remove only selected transactions
-		p2p.MemoryPool = map[string]blockchain.Tx{}
+		// transactions of the pool not in this block stay for the next one
+		p2p.RemoveFromMemoryPool(txPool)
+		p2p.AnnounceBlock(newBlock)
 
 		c.JSON(200, newBlock)
 	}
 	return fn
 }
 
+// time locked transactions show up once the miner or a new block promotes them, see GetLockedTxs
 func GetTxPool() gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		var txsInPools []blockchain.Tx
-		for _, tx := range p2p.MemoryPool {
-			txsInPools = append(txsInPools, tx)
+		c.JSON(200, p2p.MemoryPoolTxs())
+	}
+	return fn
+}
+
+// the id is either the hash of the block or its height
+func GetBlockResponse(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		block, err := chain.BlockByID(c.Param("id"))
+		if err != nil {
+			c.JSON(404, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		c.JSON(200, block)
+	}
+	return fn
+}
+
+// looks the transaction up in the chain first and then in the pools of this node
+func GetTransactionResponse(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		txID, err := hex.DecodeString(c.Param("txid"))
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: "provided transaction id can not be decoded"})
+			return
+		}
+		if tx, block, err := chain.FindTx(txID); err == nil {
+			txInfo := map[string]interface{}{
+				"tx":         tx,
+				"status":     "confirmed",
+				"block_hash": block.BlockHash,
+				"height":     block.Height,
+			}
+			c.JSON(200, txInfo)
+			return
+		}
+		tx, status, found := p2p.PooledTx(hex.EncodeToString(txID))
+		if !found {
+			c.JSON(404, ErrorJSON{ErrorMsg: "transaction with id not found"})
+			return
+		}
+		txInfo := map[string]interface{}{
+			"tx":     tx,
+			"status": status,
+		}
+		c.JSON(200, txInfo)
+	}
+	return fn
+}
+
+// validators signing the next blocks and the votes on changing them
+func GetValidators(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		if chain.Params.Consensus != blockchain.CONSENSUS_POA {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("the %s network is not run by validators", chain.Params.Name)})
+			return
+		}
+		validators, err := chain.Validators()
+		if err != nil {
+			c.JSON(500, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		proposals, err := chain.ValidatorProposals()
+		if err != nil {
+			c.JSON(500, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		addresses := []string{}
+		for _, validator := range validators {
+			addresses = append(addresses, wallet.AddressFromPubKeyHash(validator))
+		}
+		nextHeight := chain.LastBlock().Height + 1
+		validatorInfo := map[string]interface{}{
+			"validators":   addresses,
+			"next_height":  nextHeight,
+			"in_turn":      wallet.AddressFromPubKeyHash(blockchain.InTurnValidator(validators, nextHeight)),
+			"block_period": chain.Params.BlockPeriod,
+			"proposals":    proposals,
+		}
+		c.JSON(200, validatorInfo)
+	}
+	return fn
+}
+
+// vote of the node wallet for adding or removing a validator, the change is made once more than half of the validators voted for it
+func PostValidatorVote(wlt *wallet.Wallet, chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		voteData := ValidatorVoteInput{}
+		if err := c.BindJSON(&voteData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		voteTx, err := blockchain.NewValidatorVote(wlt, voteData.Validator, voteData.Remove, chain)
+		if err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		if rejection := p2p.AcceptToMemoryPool(*voteTx, chain); rejection != nil {
+			c.JSON(RejectionStatus(rejection), RejectionToJSON(voteTx, rejection))
+			return
+		}
+		p2p.AnnounceTx(*voteTx)
+
+		c.JSON(200, voteTx)
+	}
+	return fn
+}
+
+func GetPeers() gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		peerInfo := map[string]interface{}{
+			"advertised":   p2p.NodeAddress(),
+			"node_id":      p2p.LocalNodeID(),
+			"encrypted":    p2p.Encrypted(),
+			"peers":        p2p.Peers(),
+			"banned":       p2p.Banned(),
+			"address_book": p2p.AddressBook(),
+			"versions":     p2p.PeerVersions(),
+		}
+		c.JSON(200, peerInfo)
+	}
+	return fn
+}
+
+func PostAddPeer(chain *blockchain.BlockChain) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		peerData := PeerInput{}
+		if err := c.BindJSON(&peerData); err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+		if err := p2p.AddPeer(peerData.Address, chain); err != nil {
+			c.JSON(400, ErrorJSON{ErrorMsg: fmt.Sprintf("%v", err)})
+			return
+		}
+		c.JSON(200, map[string]interface{}{"peers": p2p.Peers()})
+	}
+	return fn
+}
+
+// bans the host of the peer, its messages are dropped until the node restarts
+func PostBanPeer() gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		peerData := PeerInput{}
+		if err := c.BindJSON(&peerData); err != nil {
+			c.AbortWithError(400, err)
+			return
 		}
-		c.JSON(200, txsInPools)
+		removedPeers := p2p.BanPeer(peerData.Address)
+		c.JSON(200, map[string]interface{}{"removed": removedPeers, "banned": p2p.Banned()})
 	}
 	return fn
 }
@@ -1,6 +1,10 @@
 package api
 
-import "github.com/gin-gonic/gin"
+import (
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+)
 
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -16,3 +20,32 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// POST requests sign with the node wallet or change its state, so they need the configured bearer token
+// GET routes signing with the node wallet add RequireToken themselves
+func AuthMiddleware(authToken string) gin.HandlerFunc {
+	requireToken := RequireToken(authToken)
+	return func(c *gin.Context) {
+		if c.Request.Method != "POST" {
+			c.Next()
+			return
+		}
+		requireToken(c)
+	}
+}
+
+// the configured bearer token whatever the method, an empty token leaves the route open
+func RequireToken(authToken string) gin.HandlerFunc {
+	expected := []byte("Bearer " + authToken)
+	return func(c *gin.Context) {
+		if authToken == "" {
+			c.Next()
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.AbortWithStatusJSON(401, ErrorJSON{ErrorMsg: "missing or wrong API token"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -4,22 +4,121 @@ import (
 	"encoding/hex"
 
 	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/object"
 )
 
 type ErrorJSON struct {
 	ErrorMsg string `json:"error"`
 }
 
+// error json with the machine readable reason a transaction was refused
+type TxRejectionJSON struct {
+	ErrorMsg string `json:"error"`
+	Code     string `json:"code"`
+	TxID     string `json:"tx_id,omitempty"`
+}
+
+// status code of a rejection, a node that could not check the transaction is at fault rather than the client
+func RejectionStatus(rejection *blockchain.TxRejection) int {
+	if rejection.Code == blockchain.REJECT_INTERNAL {
+		return 500
+	}
+	return 400
+}
+
+func RejectionToJSON(tx *blockchain.Tx, rejection *blockchain.TxRejection) TxRejectionJSON {
+	rejectionJSON := TxRejectionJSON{ErrorMsg: rejection.Reason, Code: rejection.Code}
+	if tx != nil {
+		rejectionJSON.TxID = hex.EncodeToString(tx.TxID)
+	}
+	return rejectionJSON
+}
+
 // TODO: add binding validation
 type NewTxFormInput struct {
 	Destination string `json:"destination" binding:"required"`
 	ItemHash    string `json:"item_hash" binding:"required"`
 	Amount      uint64 `json:"amount" binding:"required"`
+	LockHeight  uint64 `json:"lock_height"` // optional, earliest block height the transfer can be mined at
+	LockTime    uint64 `json:"lock_time"`   // optional, earliest unix time the transfer can be mined at
+}
+
+type CreditTransferInput struct {
+	Destination string `json:"destination" binding:"required"`
+	Amount      uint64 `json:"amount" binding:"required"`
 }
 
+type EscrowTxFormInput struct {
+	Destination string `json:"destination" binding:"required"`
+	Arbiter     string `json:"arbiter" binding:"required"`
+	ItemHash    string `json:"item_hash" binding:"required"`
+	Amount      uint64 `json:"amount" binding:"required"`
+	LockHeight  uint64 `json:"lock_height"`
+	LockTime    uint64 `json:"lock_time"`
+}
+
+type EscrowSettlementInput struct {
+	ItemHash string `json:"item_hash" binding:"required"`
+}
+
+// transfer built by a watch-only node, the seller signs it offline
+type UnsignedTxFormInput struct {
+	Source             string `json:"source" binding:"required"`
+	Destination        string `json:"destination" binding:"required"`
+	ItemHash           string `json:"item_hash" binding:"required"`
+	Amount             uint64 `json:"amount" binding:"required"`
+	RequiresAcceptance bool   `json:"requires_acceptance"`
+}
+
+// either item_hash (an identifier that gets hashed) or object (metadata committed on-chain) is required
 type CoinBaseTxFormInput struct {
+	ItemHash string         `json:"item_hash"`
+	Object   *object.Object `json:"object"`
+	Amount   uint64         `json:"amount" binding:"required"`
+}
+
+// input for retire, recall and lost report transactions, the note is recorded on the chain
+type LifecycleTxFormInput struct {
 	ItemHash string `json:"item_hash" binding:"required"`
-	Amount   uint64 `json:"amount" binding:"required"`
+	Note     string `json:"note"`
+}
+
+// parts are hex encoded item hashes, a disassembly without parts releases the parts the composite was assembled from
+type CompositeTxFormInput struct {
+	ItemHash string   `json:"item_hash" binding:"required"`
+	Parts    []string `json:"parts"`
+	Note     string   `json:"note"`
+}
+
+type SwapLegInput struct {
+	ItemHash    string `json:"item_hash" binding:"required"`
+	Destination string `json:"destination" binding:"required"`
+	Amount      uint64 `json:"amount"`
+}
+
+// every leg moves one item from its current owner to the destination, all of them or none
+type SwapTxFormInput struct {
+	Legs []SwapLegInput `json:"legs" binding:"required,dive"`
+}
+
+// host:port of a peer, bans apply to every port of the host
+type ValidatorVoteInput struct {
+	Validator string `json:"validator" binding:"required"` // wallet address of the validator to add or remove
+	Remove    bool   `json:"remove"`
+}
+
+type PeerInput struct {
+	Address string `json:"address" binding:"required"`
+}
+
+// buyer's response to a pending transfer offer
+type OfferResponseInput struct {
+	TxID string `json:"tx_id" binding:"required"`
+}
+
+type ObjectHashVerifyInput struct {
+	Object   object.Object `json:"object" binding:"required"`
+	ItemHash string        `json:"item_hash" binding:"required"`
 }
 
 type TokenSignModel struct {
@@ -41,6 +140,21 @@ type TransactionsModel struct {
 	BuyerHash  string `json:"buyerHash"`
 	Amount     uint64 `json:"amount"`
 	Timestamp  uint64 `json:"timestamp"`
+
+	PublicKey          string                `json:"publicKey"`
+	RequiresAcceptance bool                  `json:"requiresAcceptance"`
+	BuyerPublicKey     string                `json:"buyerPublicKey"`
+	BuyerSignature     string                `json:"buyerSignature"`
+	MetadataHash       string                `json:"metadataHash"`
+	ItemHashVersion    uint8                 `json:"itemHashVersion"`
+	Type               uint8                 `json:"type"`
+	Note               string                `json:"note"`
+	Parts              []blockchain.TxPart   `json:"parts"`
+	Legs               []blockchain.TxLeg    `json:"legs"`
+	Signers            []blockchain.TxSigner `json:"signers"`
+	LockHeight         uint64                `json:"lockHeight"`
+	LockTime           uint64                `json:"lockTime"`
+	ArbiterHash        string                `json:"arbiterHash"`
 }
 
 func ModelToTx(txModel TransactionsModel) (*blockchain.Tx, error) {
@@ -78,8 +192,42 @@ func ModelToTx(txModel TransactionsModel) (*blockchain.Tx, error) {
 		return nil, err
 	}
 
+	tx.PublicKey, err = hex.DecodeString(txModel.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.BuyerPublicKey, err = hex.DecodeString(txModel.BuyerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.BuyerSignature, err = hex.DecodeString(txModel.BuyerSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.MetadataHash, err = hex.DecodeString(txModel.MetadataHash)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.ArbiterHash, err = hex.DecodeString(txModel.ArbiterHash)
+	if err != nil {
+		return nil, err
+	}
+
 	tx.Amount = txModel.Amount
 	tx.Timestamp = txModel.Timestamp
+	tx.RequiresAcceptance = txModel.RequiresAcceptance
+	tx.ItemHashVersion = txModel.ItemHashVersion
+	tx.Type = txModel.Type
+	tx.Note = txModel.Note
+	tx.Parts = txModel.Parts
+	tx.Legs = txModel.Legs
+	tx.Signers = txModel.Signers
+	tx.LockHeight = txModel.LockHeight
+	tx.LockTime = txModel.LockTime
 
 	return &tx, nil
 }
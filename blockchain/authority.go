@@ -0,0 +1,442 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// seconds the timestamp of a signed block may be ahead of the clock of the node checking it
+const MAX_BLOCK_TIME_DRIFT = 15
+
+var (
+	ErrNotValidator = errors.New("wallet is not a validator of the network")
+	ErrTipChanged   = errors.New("a block for this height arrived while waiting for the turn")
+)
+
+// blocks are signed by validators instead of mined, the validator of height h is validators[h % len(validators)]
+// any other validator may step in when the one in turn is away, waiting BlockPeriod seconds for every turn it is behind
+type proofOfAuthority struct{}
+
+func (proofOfAuthority) Name() string {
+	return CONSENSUS_POA
+}
+
+// sorted pubkey hashes of the validators in the chain params
+func (params *ChainParams) initialValidators() ([][]byte, error) {
+	var validators [][]byte
+	for _, address := range params.Validators {
+		pubKeyHash, err := wallet.PubKeyHashFromAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("validator %s: %v", address, err)
+		}
+		if indexOfHash(validators, pubKeyHash) >= 0 {
+			return nil, fmt.Errorf("validator %s is listed twice", address)
+		}
+		validators = append(validators, pubKeyHash)
+	}
+	sort.Slice(validators, func(i, j int) bool {
+		return bytes.Compare(validators[i], validators[j]) < 0
+	})
+	return validators, nil
+}
+
+func indexOfHash(hashes [][]byte, hash []byte) int {
+	for i := range hashes {
+		if bytes.Equal(hashes[i], hash) {
+			return i
+		}
+	}
+	return -1
+}
+
+// validators after the last block, the ones in the chain params until votes changed them
+func validatorSet(txn *badger.Txn, params *ChainParams) ([][]byte, error) {
+	var validators [][]byte
+	err := getGob(txn, []byte(VALIDATORS_KEY), &validators)
+	if err == badger.ErrKeyNotFound {
+		return params.initialValidators()
+	}
+	return validators, err
+}
+
+// sorted pubkey hashes of the validators that sign the next block
+func (blockchain *BlockChain) Validators() ([][]byte, error) {
+	var validators [][]byte
+	err := blockchain.view(func(txn *badger.Txn) error {
+		var err error
+		validators, err = validatorSet(txn, blockchain.Params)
+		return err
+	})
+	return validators, err
+}
+
+// turns the signer is behind the validator of the height, false if the signer is no validator
+func turnDistance(validators [][]byte, height uint64, signer []byte) (uint64, bool) {
+	index := indexOfHash(validators, signer)
+	if index < 0 {
+		return 0, false
+	}
+	count := uint64(len(validators))
+	return (uint64(index) + count - height%count) % count, true
+}
+
+// pubkey hash of the validator whose turn it is at the height
+func InTurnValidator(validators [][]byte, height uint64) []byte {
+	if len(validators) == 0 {
+		return nil
+	}
+	return validators[height%uint64(len(validators))]
+}
+
+// the miner field names the validator, so it has to be set before sealing
+func (proofOfAuthority) Seal(ctx context.Context, blk *Block, chain *BlockChain, wlt *wallet.Wallet, threads int) error {
+	validators, err := chain.Validators()
+	if err != nil {
+		return err
+	}
+	distance, ok := turnDistance(validators, blk.Height, blk.Miner)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotValidator, wlt.Address)
+	}
+
+	parent := chain.LastBlock()
+	if !bytes.Equal(parent.BlockHash, blk.PreviousHash) {
+		return ErrTipChanged
+	}
+	// VerifySeal only keeps a validator out of turn from signing right after the parent,
+	// waiting from the creation of the block as well leaves the block to the validator in turn while that one is around
+	earliest := blk.Timestamp
+	if parent.Timestamp > earliest {
+		earliest = parent.Timestamp
+	}
+	earliest += distance * chain.Params.BlockPeriod
+	if wait := time.Until(time.Unix(int64(earliest), 0)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if !bytes.Equal(chain.LastHash, blk.PreviousHash) {
+		return ErrTipChanged
+	}
+
+	blk.Timestamp = earliest
+	blk.Difficulty = 0
+	blk.Nonce = 0
+	if blk.IsEmpty() {
+		blk.BlockHash = CalculateHashEmptyBlock(blk, blk.Nonce)
+	} else {
+		blk.BlockHash = CalculateHashNonEmptyBlock(blk, blk.Nonce)
+	}
+	publicKey, err := wallet.PublicKeyToBytes(&wlt.PublicKey)
+	if err != nil {
+		return err
+	}
+	signature, err := sign(&wlt.PrivateKey, blk.BlockHash)
+	if err != nil {
+		return err
+	}
+	blk.PublicKey = publicKey
+	blk.Signature = signature
+	return nil
+}
+
+func (engine proofOfAuthority) VerifySeal(blk *Block, chain *BlockChain) error {
+	if err := engine.CheckSeal(blk, chain.Params); err != nil {
+		return err
+	}
+
+	parent := chain.LastBlock()
+	if !bytes.Equal(parent.BlockHash, blk.PreviousHash) {
+		return fmt.Errorf("block %x does not extend the tip %x", blk.BlockHash, parent.BlockHash)
+	}
+	// the height decides whose turn it is, so it can not be left to the signer
+	if blk.Height != parent.Height+1 {
+		return fmt.Errorf("block height %d does not follow the height %d of its parent", blk.Height, parent.Height)
+	}
+	validators, err := chain.Validators()
+	if err != nil {
+		return err
+	}
+	distance, ok := turnDistance(validators, blk.Height, blk.Miner)
+	if !ok {
+		return fmt.Errorf("block is signed by %s, who is not a validator", wallet.AddressFromPubKeyHash(blk.Miner))
+	}
+	if earliest := parent.Timestamp + distance*chain.Params.BlockPeriod; blk.Timestamp < earliest {
+		return fmt.Errorf("validator %s is %d turns behind at height %d and may not sign before %d", wallet.AddressFromPubKeyHash(blk.Miner), distance, blk.Height, earliest)
+	}
+	if blk.Timestamp > uint64(time.Now().Unix())+MAX_BLOCK_TIME_DRIFT {
+		return fmt.Errorf("block timestamp %d is ahead of the clock", blk.Timestamp)
+	}
+	return nil
+}
+
+// the block is signed by the wallet it names as miner, whether that one was a validator at the time depends on the chain
+func (proofOfAuthority) CheckSeal(blk *Block, params *ChainParams) error {
+	if blk.Nonce != 0 || blk.Difficulty != 0 {
+		return errors.New("signed blocks have neither nonce nor difficulty")
+	}
+	signerPubKey, err := publicKeyMatchingHash(blk.PublicKey, blk.Miner)
+	if err != nil {
+		return fmt.Errorf("block signer: %v", err)
+	}
+	if err := rsa.VerifyPSS(signerPubKey, crypto.SHA256, blk.BlockHash, blk.Signature, nil); err != nil {
+		return errors.New("block signature is not valid")
+	}
+	return nil
+}
+
+// open proposal to add or remove a validator, stored under VALIDATOR_VOTE_PREFIX until more than half of the validators voted for it
+type validatorProposal struct {
+	Remove  bool
+	Subject []byte
+	Voters  [][]byte
+}
+
+// proposal as shown to API clients, with wallet addresses
+type ValidatorProposal struct {
+	Validator string   `json:"validator"`
+	Remove    bool     `json:"remove"`
+	Voters    []string `json:"voters"`
+}
+
+func validatorVoteKey(remove bool, subject []byte) []byte {
+	kind := "add-"
+	if remove {
+		kind = "remove-"
+	}
+	return []byte(VALIDATOR_VOTE_PREFIX + kind + hex.EncodeToString(subject))
+}
+
+// marks a vote as applied, so a vote can not be replayed once the proposal it was for is decided
+func validatorVoteTxKey(txID []byte) []byte {
+	return []byte(VALIDATOR_VOTE_TX_PREFIX + hex.EncodeToString(txID))
+}
+
+func (tx *Tx) IsValidatorVote() bool {
+	return tx.Type == TX_ADD_VALIDATOR || tx.Type == TX_REMOVE_VALIDATOR
+}
+
+// AddBlock checks no signatures of plain transactions, the votes are checked here since a forged one would change the validators
+func applyBlockGovernance(txn *badger.Txn, block *Block, params *ChainParams) error {
+	if block.IsEmpty() {
+		return nil
+	}
+	for _, txNode := range block.TxMerkleTree.LeafNodes {
+		tx := &txNode.Transaction
+		if !tx.IsValidatorVote() {
+			continue
+		}
+		if params.Consensus != CONSENSUS_POA {
+			return fmt.Errorf("vote %x: the %s network is not run by validators", tx.TxID, params.Name)
+		}
+		if rejection := validateTxIntegrity(tx); rejection != nil {
+			return fmt.Errorf("vote %x: %v", tx.TxID, rejection)
+		}
+		if _, err := txn.Get(validatorVoteTxKey(tx.TxID)); err == nil {
+			return fmt.Errorf("vote %x is already part of the chain", tx.TxID)
+		}
+		if err := txn.Set(validatorVoteTxKey(tx.TxID), []byte{1}); err != nil {
+			return err
+		}
+		if err := applyValidatorVote(txn, tx, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// counts the vote, a vote that came to nothing is left without effect instead of refusing the block,
+// eg. a second vote on a change an earlier vote of the same block already made
+func applyValidatorVote(txn *badger.Txn, tx *Tx, params *ChainParams) error {
+	validators, err := validatorSet(txn, params)
+	if err != nil {
+		return err
+	}
+	remove := tx.Type == TX_REMOVE_VALIDATOR
+	if indexOfHash(validators, tx.SellerHash) < 0 || (indexOfHash(validators, tx.BuyerHash) >= 0) != remove {
+		return nil
+	}
+	if remove && len(validators) == 1 {
+		return nil
+	}
+
+	proposal := validatorProposal{Remove: remove, Subject: tx.BuyerHash}
+	key := validatorVoteKey(remove, tx.BuyerHash)
+	if err := getGob(txn, key, &proposal); err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if indexOfHash(proposal.Voters, tx.SellerHash) >= 0 {
+		return nil
+	}
+	proposal.Voters = append(proposal.Voters, tx.SellerHash)
+
+	// votes of validators removed since they voted no longer count
+	votes := 0
+	for _, voter := range proposal.Voters {
+		if indexOfHash(validators, voter) >= 0 {
+			votes++
+		}
+	}
+	if votes*2 <= len(validators) {
+		return setGob(txn, key, proposal)
+	}
+
+	if remove {
+		index := indexOfHash(validators, tx.BuyerHash)
+		validators = append(validators[:index:index], validators[index+1:]...)
+	} else {
+		validators = append(validators, tx.BuyerHash)
+		sort.Slice(validators, func(i, j int) bool {
+			return bytes.Compare(validators[i], validators[j]) < 0
+		})
+	}
+	if err := setGob(txn, []byte(VALIDATORS_KEY), validators); err != nil {
+		return err
+	}
+	return txn.Delete(key)
+}
+
+// proposals with votes that are not decided yet
+func (blockchain *BlockChain) ValidatorProposals() ([]ValidatorProposal, error) {
+	proposals := []ValidatorProposal{}
+	err := blockchain.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(VALIDATOR_VOTE_PREFIX)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var proposal validatorProposal
+			if err := getGob(txn, it.Item().KeyCopy(nil), &proposal); err != nil {
+				return err
+			}
+			voters := []string{}
+			for _, voter := range proposal.Voters {
+				voters = append(voters, wallet.AddressFromPubKeyHash(voter))
+			}
+			proposals = append(proposals, ValidatorProposal{
+				Validator: wallet.AddressFromPubKeyHash(proposal.Subject),
+				Remove:    proposal.Remove,
+				Voters:    voters,
+			})
+		}
+		return nil
+	})
+	return proposals, err
+}
+
+func NewUnsignedValidatorVote(voterAddr string, validatorAddr string, remove bool, chain *BlockChain) (*Tx, error) {
+	voterPubKeyHash, err := wallet.PubKeyHashFromAddress(voterAddr)
+	if err != nil {
+		return nil, err
+	}
+	validatorPubKeyHash, err := wallet.PubKeyHashFromAddress(validatorAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	voteTx := Tx{
+		SellerHash: voterPubKeyHash,
+		BuyerHash:  validatorPubKeyHash,
+		Timestamp:  uint64(time.Now().Unix()),
+		Type:       TX_ADD_VALIDATOR,
+	}
+	if remove {
+		voteTx.Type = TX_REMOVE_VALIDATOR
+	}
+	if rejection := checkValidatorVote(&voteTx, chain); rejection != nil {
+		return nil, rejection
+	}
+	txID, err := voteTx.CalculateTxHash()
+	if err != nil {
+		return nil, err
+	}
+	voteTx.TxID = txID
+	return &voteTx, nil
+}
+
+// vote of the wallet, which has to be a validator, to add a validator or remove one
+func NewValidatorVote(voterWallet *wallet.Wallet, validatorAddr string, remove bool, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedValidatorVote(string(voterWallet.Address), validatorAddr, remove, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(voterWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+// called by ValidateTransaction once hash and signatures are checked
+func validateValidatorVote(tx *Tx, chain *BlockChain) *TxRejection {
+	if chain.Params.Consensus != CONSENSUS_POA {
+		return Reject(REJECT_MALFORMED, fmt.Sprintf("the %s network is not run by validators", chain.Params.Name))
+	}
+	if len(tx.ItemHash) != 0 || len(tx.UTXOID) != 0 || len(tx.MetadataHash) != 0 || tx.Amount != 0 {
+		return Reject(REJECT_MALFORMED, "validator votes do not involve items or amounts")
+	}
+	if tx.RequiresAcceptance || len(tx.Parts) != 0 || len(tx.Legs) != 0 || len(tx.Signers) != 0 || len(tx.ArbiterHash) != 0 {
+		return Reject(REJECT_MALFORMED, "validator votes are signed by the voting validator alone")
+	}
+	err := chain.view(func(txn *badger.Txn) error {
+		_, err := txn.Get(validatorVoteTxKey(tx.TxID))
+		return err
+	})
+	if err == nil {
+		return Reject(REJECT_ALREADY_MINED, "transaction is already part of the chain")
+	}
+	return checkValidatorVote(tx, chain)
+}
+
+func checkValidatorVote(tx *Tx, chain *BlockChain) *TxRejection {
+	if len(tx.SellerHash) == 0 || len(tx.BuyerHash) == 0 {
+		return Reject(REJECT_MALFORMED, "validator votes need a voter and a validator")
+	}
+	remove := tx.Type == TX_REMOVE_VALIDATOR
+	var validators [][]byte
+	var proposal validatorProposal
+	err := chain.view(func(txn *badger.Txn) error {
+		var err error
+		if validators, err = validatorSet(txn, chain.Params); err != nil {
+			return err
+		}
+		if err := getGob(txn, validatorVoteKey(remove, tx.BuyerHash), &proposal); err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return Reject(REJECT_INTERNAL, err.Error())
+	}
+
+	if indexOfHash(validators, tx.SellerHash) < 0 {
+		return Reject(REJECT_NOT_VALIDATOR, "only validators vote on the validator set")
+	}
+	isValidator := indexOfHash(validators, tx.BuyerHash) >= 0
+	if !remove && isValidator {
+		return Reject(REJECT_INVALID_STATE, fmt.Sprintf("%s is already a validator", wallet.AddressFromPubKeyHash(tx.BuyerHash)))
+	}
+	if remove && !isValidator {
+		return Reject(REJECT_INVALID_STATE, fmt.Sprintf("%s is not a validator", wallet.AddressFromPubKeyHash(tx.BuyerHash)))
+	}
+	if remove && len(validators) == 1 {
+		return Reject(REJECT_INVALID_STATE, "the last validator can not be removed")
+	}
+	if indexOfHash(proposal.Voters, tx.SellerHash) >= 0 {
+		return Reject(REJECT_DUPLICATE, "validator already voted for this change")
+	}
+	return nil
+}
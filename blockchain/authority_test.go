@@ -0,0 +1,180 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// hashes the block as it is and signs it like proofOfAuthority.Seal, without waiting for the turn of the signer
+func testSignBlock(t *testing.T, blk *Block, signer *wallet.Wallet) {
+	t.Helper()
+	if blk.IsEmpty() {
+		blk.BlockHash = CalculateHashEmptyBlock(blk, blk.Nonce)
+	} else {
+		blk.BlockHash = CalculateHashNonEmptyBlock(blk, blk.Nonce)
+	}
+	publicKey, err := wallet.PublicKeyToBytes(&signer.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := sign(&signer.PrivateKey, blk.BlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk.PublicKey = publicKey
+	blk.Signature = signature
+}
+
+func TestProofOfAuthoritySeal(t *testing.T) {
+	first, second, outsider := testWallet(t), testWallet(t), testWallet(t)
+	params, err := ParamsForNetwork("consortium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.Validators = []string{string(first.Address), string(second.Address)}
+	chain := testChainWithParams(t, params)
+	genesis := chain.LastBlock()
+
+	validators, err := chain.Validators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inTurn, outOfTurn := first, second
+	if string(InTurnValidator(validators, 1)) != string(testPubKeyHash(t, first)) {
+		inTurn, outOfTurn = second, first
+	}
+	now := uint64(time.Now().Unix())
+
+	// the block is signed by the signer after the change, and changed again after signing if the test says so
+	// checkErr is what CheckSeal says without the chain, wantErr what VerifySeal says on top of the genesis block
+	tests := []struct {
+		name        string
+		signer      *wallet.Wallet
+		change      func(blk *Block)
+		afterSigned func(blk *Block)
+		checkErr    bool
+		wantErr     bool
+	}{
+		{
+			name:   "validator in turn",
+			signer: inTurn,
+		},
+		{
+			name:   "validator out of turn after waiting for its turn",
+			signer: outOfTurn,
+		},
+		{
+			name:   "validator out of turn before its turn",
+			signer: outOfTurn,
+			change: func(blk *Block) {
+				blk.Timestamp = genesis.Timestamp + 1
+			},
+			wantErr: true,
+		},
+		{
+			name:    "wallet that is not a validator",
+			signer:  outsider,
+			wantErr: true,
+		},
+		{
+			name:   "signed by another validator than the miner",
+			signer: outOfTurn,
+			change: func(blk *Block) {
+				blk.Miner = testPubKeyHash(t, inTurn)
+			},
+			checkErr: true,
+			wantErr:  true,
+		},
+		{
+			name:   "signature of another block",
+			signer: inTurn,
+			afterSigned: func(blk *Block) {
+				other := *blk
+				other.Timestamp++
+				testSignBlock(t, &other, inTurn)
+				blk.Signature = other.Signature
+			},
+			checkErr: true,
+			wantErr:  true,
+		},
+		{
+			name:   "public key of another wallet",
+			signer: inTurn,
+			afterSigned: func(blk *Block) {
+				blk.PublicKey, _ = wallet.PublicKeyToBytes(&outOfTurn.PublicKey)
+			},
+			checkErr: true,
+			wantErr:  true,
+		},
+		{
+			name:   "nonce set",
+			signer: inTurn,
+			change: func(blk *Block) {
+				blk.Nonce = 1
+			},
+			checkErr: true,
+			wantErr:  true,
+		},
+		{
+			name:   "difficulty set",
+			signer: inTurn,
+			change: func(blk *Block) {
+				blk.Difficulty = 1
+			},
+			checkErr: true,
+			wantErr:  true,
+		},
+		{
+			name:   "height skipped",
+			signer: inTurn,
+			change: func(blk *Block) {
+				blk.Height = 3
+			},
+			wantErr: true,
+		},
+		{
+			name:   "another parent",
+			signer: inTurn,
+			change: func(blk *Block) {
+				blk.PreviousHash = []byte("another previous block hash")
+			},
+			wantErr: true,
+		},
+		{
+			name:   "timestamp ahead of the clock",
+			signer: inTurn,
+			change: func(blk *Block) {
+				blk.Timestamp = now + 10*MAX_BLOCK_TIME_DRIFT
+			},
+			wantErr: true,
+		},
+	}
+
+	engine := proofOfAuthority{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blk := &Block{
+				Height:       genesis.Height + 1,
+				Timestamp:    now,
+				PreviousHash: genesis.BlockHash,
+				Miner:        testPubKeyHash(t, test.signer),
+			}
+			if test.change != nil {
+				test.change(blk)
+			}
+			testSignBlock(t, blk, test.signer)
+			if test.afterSigned != nil {
+				test.afterSigned(blk)
+			}
+
+			if err := engine.CheckSeal(blk, chain.Params); (err != nil) != test.checkErr {
+				t.Errorf("CheckSeal() error = %v, wantErr %v", err, test.checkErr)
+			}
+			if err := engine.VerifySeal(blk, chain); (err != nil) != test.wantErr {
+				t.Errorf("VerifySeal() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
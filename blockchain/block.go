@@ -2,6 +2,7 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
@@ -19,14 +20,16 @@ import (
 // might need a few more fields
 // including the consensus number in the blockheader
 type Block struct {
-	Nonce        uint64          `json:"nonce"`         // unsigned representation for now, might allocate 64 bits later, upgrade to 64 bits if version field is removed
-	Height       uint64          `json:"height"`        // current block height
-	Timestamp    uint64          `json:"timestamp"`     // unix date time, string representation now, might convert to uint64 if time zones are not taken into consideration
-	Difficulty   uint64          `json:"difficulty"`    // difficulty based on tx sum
-	BlockHash    utility.HexByte `json:"block_hash"`    // hash of the current block
-	PreviousHash utility.HexByte `json:"previous_hash"` // hash of previous block
-	Miner        utility.HexByte `json:"miner"`         // address of block miner
-	TxMerkleTree *MerkleTree     `json:"merkle_tree"`   // merkel tree for transactions
+	Nonce        uint64          `json:"nonce"`                // unsigned representation for now, might allocate 64 bits later, upgrade to 64 bits if version field is removed
+	Height       uint64          `json:"height"`               // current block height
+	Timestamp    uint64          `json:"timestamp"`            // unix date time, string representation now, might convert to uint64 if time zones are not taken into consideration
+	Difficulty   uint64          `json:"difficulty"`           // difficulty based on tx sum
+	BlockHash    utility.HexByte `json:"block_hash"`           // hash of the current block
+	PreviousHash utility.HexByte `json:"previous_hash"`        // hash of previous block
+	Miner        utility.HexByte `json:"miner"`                // address of block miner
+	Signature    utility.HexByte `json:"signature,omitempty"`  // proof of authority only, signature of the validator over the block hash
+	PublicKey    utility.HexByte `json:"public_key,omitempty"` // proof of authority only, public key of the validator named by miner
+	TxMerkleTree *MerkleTree     `json:"merkle_tree"`          // merkel tree for transactions
 }
 
 func (blk *Block) String() string {
@@ -91,11 +94,11 @@ func CreateBlock() *Block {
 	return &blk
 }
 
-func CreateGenesisBlock() *Block {
-	blk_hash := sha256.Sum256([]byte(GENESIS_STRING))
+func CreateGenesisBlock(params *ChainParams) *Block {
+	blk_hash := sha256.Sum256([]byte(params.GenesisString))
 	// leave most fields empty for now
 	blk := Block{
-		Timestamp: GENESIS_TIMESTAMP,
+		Timestamp: params.GenesisTimestamp,
 		Height:    0,
 		BlockHash: blk_hash[:],
 	}
@@ -110,12 +113,27 @@ func CalculateHashEmptyBlock(blk *Block, nonce uint64) []byte {
 	binary.LittleEndian.PutUint64(blockBytes, blockData) // write XORed  uint64 data to buffer
 	buf.Write(blockBytes)
 	buf.Write(blk.PreviousHash[:]) // write blockhash to buffer
+	writeHeaderFields(&buf, blk)
 
 	calculatedHash := sha256.Sum256(buf.Bytes()) // calculate hash
 
 	return calculatedHash[:]
 }
 
+// height, difficulty and miner are sealed along with the rest, the miner is paid the block credits
+// and the difficulty and height decide the work a block counts for, so none of them can be changed by whoever relays the block
+func writeHeaderFields(buf *bytes.Buffer, blk *Block) {
+	fieldBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(fieldBytes, blk.Height)
+	buf.Write(fieldBytes)
+	binary.LittleEndian.PutUint64(fieldBytes, blk.Difficulty)
+	buf.Write(fieldBytes)
+	// length prefixed, the merkle root follows it in blocks with transactions
+	binary.LittleEndian.PutUint64(fieldBytes, uint64(len(blk.Miner)))
+	buf.Write(fieldBytes)
+	buf.Write(blk.Miner)
+}
+
 // since this function does not modify the actual block properties, we remove the interface from it
 // TODO: gob encode and hash using only required fields, as done for transaction
 func CalculateHashNonEmptyBlock(blk *Block, nonce uint64) []byte {
@@ -125,7 +143,8 @@ func CalculateHashNonEmptyBlock(blk *Block, nonce uint64) []byte {
 	blockData := nonce ^ blk.Timestamp                   // XOR timestamp and nonce
 	binary.LittleEndian.PutUint64(blockBytes, blockData) // write XORed  uint64 data to buffer
 	buf.Write(blockBytes)
-	buf.Write(blk.PreviousHash[:])             // write blockhash to buffer
+	buf.Write(blk.PreviousHash[:]) // write blockhash to buffer
+	writeHeaderFields(&buf, blk)
 	buf.Write(blk.TxMerkleTree.Root.HashValue) // write merkel root hash to buffer
 
 	calculatedHash := sha256.Sum256(buf.Bytes()) // calculate hash
@@ -134,10 +153,16 @@ func CalculateHashNonEmptyBlock(blk *Block, nonce uint64) []byte {
 }
 
 func (blk *Block) MineBlock(chain *BlockChain, wlt *wallet.Wallet) error {
+	return blk.MineBlockWithThreads(context.Background(), chain, wlt, 1)
+}
+
+// seals the block with the consensus engine of the network, until done or cancelled
+// the proof of work search is spread over the given number of goroutines
+func (blk *Block) MineBlockWithThreads(ctx context.Context, chain *BlockChain, wlt *wallet.Wallet, threads int) error {
 	var lastHash []byte
 	var lastBlock *Block
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
+	err := chain.view(func(txn *badger.Txn) error {
 		lastHashQuery, err := txn.Get([]byte(LAST_HASH))
 		if err != nil {
 			return err
@@ -167,18 +192,18 @@ func (blk *Block) MineBlock(chain *BlockChain, wlt *wallet.Wallet) error {
 	blk.PreviousHash = lastHash
 	blk.Height = lastBlock.Height + 1
 
-	// create function to calculate difficulty later based on txsum?
-	blk.Difficulty = uint64(blk.Height/2016 + 1) // block difficulty changes every 2016 blocks, just like bitcoin
-	ProofOfWork(blk)
-
-	// add miner address after proof of work is done
+	// the miner is part of the block hash, it is set before the engine seals the block
 	minerAddress, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
 	if err != nil {
 		return err
 	}
 	blk.Miner = minerAddress
 
-	return nil
+	engine, err := chain.Params.ConsensusEngine()
+	if err != nil {
+		return err
+	}
+	return engine.Seal(ctx, blk, chain, wlt, threads)
 }
 
 // add transactions from pool to block as merkle tree
@@ -210,6 +235,25 @@ func (blk *Block) VerifyBlockHash() bool {
 	return bytes.Equal(calculatedHash, blk.BlockHash)
 }
 
+// the block hash only covers the merkle root, the root is rebuilt here from the transactions the block carries
+func (blk *Block) VerifyMerkleRoot() bool {
+	if blk.IsEmpty() {
+		return true
+	}
+	if blk.TxMerkleTree.Root == nil || len(blk.TxMerkleTree.LeafNodes) == 0 {
+		return false
+	}
+	var txs []Tx
+	for _, txNode := range blk.TxMerkleTree.LeafNodes {
+		txs = append(txs, txNode.Transaction)
+	}
+	tree, err := CreateMerkleTree(txs, nil)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(tree.Root.HashValue, blk.TxMerkleTree.Root.HashValue)
+}
+
 func (blk *Block) IsEmpty() bool {
 	return blk.TxMerkleTree == nil
 }
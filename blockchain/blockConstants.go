@@ -2,10 +2,19 @@ package blockchain
 
 // some constants
 const (
-	MAX_ITERATIONS_POW   = 100000 // will migrate to unlimited iterations once optimized to use goroutines for mining
-	DB_PATH              = "./db"
-	LAST_HASH            = "lh"
-	GENESIS_STRING       = "BBC News (Thursday, March 10, 2022 1:33:39 PM) - Ukraine war: No progress on ceasefire after Kyiv-Moscow talks"
-	GENESIS_TIMESTAMP    = 1646919219
-	MINED_TO_SPEND_RATIO = 1 // mine 'n' blocks to add 1 coinbase transaction
+	LAST_HASH           = "lh"
+	NETWORK_KEY         = "net" // name of the network the database was created for
+	METADATA_PREFIX     = "md-" // item metadata blobs are stored under prefix + blob hash
+	PRICE_POINT_PREFIX  = "pp-" // price index, sales are stored under prefix + item hash + height + tx index
+	PRICE_INFO_PREFIX   = "pi-" // price index, brand, category and base price of items with known metadata
+	PRICE_INDEX_KEY     = "pidx"
+	PRICE_INDEX_VERSION = 1
+	CREDIT_PREFIX       = "cr-" // credit ledger, balances are stored under prefix + pubkey hash
+	CREDIT_TX_PREFIX    = "ct-" // credit ledger, applied credit transfers are stored under prefix + tx id
+	CREDIT_INDEX_KEY    = "cidx"
+	BLOCK_UNDO_PREFIX   = "ud-" // derived state as it was before a block, stored under prefix + block hash so Reorganize can take the block off
+
+	VALIDATORS_KEY           = "vset" // proof of authority, validators after the last block once votes changed them
+	VALIDATOR_VOTE_PREFIX    = "vv-"  // proof of authority, open proposals are stored under prefix + kind + validator pubkey hash
+	VALIDATOR_VOTE_TX_PREFIX = "vx-"  // proof of authority, applied votes are stored under prefix + tx id
 )
@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// value of a key of the derived state before a block was added, a key the block created did not exist
+type undoEntry struct {
+	Key    []byte
+	Value  []byte
+	Exists bool
+}
+
+// stored under BLOCK_UNDO_PREFIX for every block added since undo data was recorded
+type blockUndo struct {
+	Entries []undoEntry
+}
+
+func blockUndoKey(blockHash []byte) []byte {
+	return append([]byte(BLOCK_UNDO_PREFIX), []byte(hex.EncodeToString(blockHash))...)
+}
+
+// every key applyBlockCredits, applyBlockGovernance and indexBlockPrices may write for the block, keep it in line with them
+func blockStateKeys(block *Block, params *ChainParams) [][]byte {
+	var keys [][]byte
+	if len(block.Miner) != 0 {
+		keys = append(keys, creditKey(block.Miner))
+	}
+	if block.IsEmpty() {
+		return keys
+	}
+	for txIndex, txNode := range block.TxMerkleTree.LeafNodes {
+		tx := &txNode.Transaction
+		if payer, debit := tx.CreditDebit(params); debit != 0 {
+			keys = append(keys, creditKey(payer))
+		}
+		if tx.Type == TX_CREDIT_TRANSFER {
+			keys = append(keys, creditTxKey(tx.TxID), creditKey(tx.BuyerHash))
+		}
+		if tx.IsValidatorVote() {
+			keys = append(keys, validatorVoteTxKey(tx.TxID), validatorVoteKey(tx.Type == TX_REMOVE_VALIDATOR, tx.BuyerHash), []byte(VALIDATORS_KEY))
+		}
+		if tx.IsCoinbase() && len(tx.MetadataHash) != 0 {
+			keys = append(keys, priceInfoKey(tx.ItemHash))
+		}
+		for _, sale := range tx.sales() {
+			keys = append(keys, pricePointKey(sale.ItemHash, block.Height, txIndex))
+		}
+	}
+	return keys
+}
+
+// stores what the keys of the block hold before it is applied, called by writeBlock in the transaction that adds the block
+func recordBlockUndo(txn *badger.Txn, block *Block, params *ChainParams) error {
+	var undo blockUndo
+	recorded := make(map[string]bool)
+	for _, key := range blockStateKeys(block, params) {
+		if recorded[string(key)] {
+			continue
+		}
+		recorded[string(key)] = true
+
+		entry := undoEntry{Key: key}
+		item, err := txn.Get(key)
+		if err == nil {
+			entry.Value, err = item.ValueCopy(nil)
+			entry.Exists = true
+		}
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		undo.Entries = append(undo.Entries, entry)
+	}
+	return setGob(txn, blockUndoKey(block.BlockHash), &undo)
+}
+
+// takes the tip off the chain, the derived state is put back as it was before the block and its parent becomes the tip
+// blocks taken off are not kept, every stored block is one of the main chain (see HasBlock)
+func disconnectBlock(txn *badger.Txn, block *Block) error {
+	var undo blockUndo
+	if err := getGob(txn, blockUndoKey(block.BlockHash), &undo); err != nil {
+		return fmt.Errorf("block %x can not be taken off the chain, its undo data: %v", block.BlockHash, err)
+	}
+	for _, entry := range undo.Entries {
+		var err error
+		if entry.Exists {
+			err = txn.Set(entry.Key, entry.Value)
+		} else {
+			err = txn.Delete(entry.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := txn.Delete(blockUndoKey(block.BlockHash)); err != nil {
+		return err
+	}
+	if err := txn.Delete(block.BlockHash); err != nil {
+		return err
+	}
+	return txn.Set([]byte(LAST_HASH), block.PreviousHash)
+}
@@ -0,0 +1,226 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// chain of the network in a fresh database, closed when the test ends
+func testChain(t *testing.T, network string) *BlockChain {
+	t.Helper()
+	params, err := ParamsForNetwork(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testChainWithParams(t, params)
+}
+
+// same as testChain for params changed by the test, eg. the validators of a consortium
+func testChainWithParams(t *testing.T, params *ChainParams) *BlockChain {
+	t.Helper()
+	params.DBPath = t.TempDir()
+	chain, err := OpenBlockChain(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+	return chain
+}
+
+func testWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+	wlt, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wlt
+}
+
+func testPubKeyHash(t *testing.T, wlt *wallet.Wallet) []byte {
+	t.Helper()
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKeyHash
+}
+
+func testTx(itemHash string, amount uint64) Tx {
+	tx := Tx{
+		ItemHash:  []byte(itemHash),
+		BuyerHash: []byte("buyer"),
+		Amount:    amount,
+		Timestamp: 1,
+	}
+	tx.TxID, _ = tx.CalculateTxHash()
+	return tx
+}
+
+// sealed with the lowest difficulty, the tests check the hash and the merkle root and not the work
+func testBlock(t *testing.T, txs ...Tx) *Block {
+	t.Helper()
+	blk := &Block{
+		Height:       1,
+		Timestamp:    1,
+		Difficulty:   1,
+		PreviousHash: []byte("previous block hash"),
+		Miner:        []byte("miner"),
+	}
+	if len(txs) != 0 {
+		if err := blk.AddTransactionsToBlock(txs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ProofOfWork(blk, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	return blk
+}
+
+func TestVerifyBlock(t *testing.T) {
+	tests := []struct {
+		name     string
+		txs      []Tx
+		tamper   func(blk *Block)
+		hashOK   bool
+		merkleOK bool
+	}{
+		{
+			name:     "empty block",
+			tamper:   func(blk *Block) {},
+			hashOK:   true,
+			merkleOK: true,
+		},
+		{
+			name:     "single transaction",
+			txs:      []Tx{testTx("a", 1)},
+			tamper:   func(blk *Block) {},
+			hashOK:   true,
+			merkleOK: true,
+		},
+		{
+			name:     "odd number of transactions",
+			txs:      []Tx{testTx("a", 1), testTx("b", 2), testTx("c", 3)},
+			tamper:   func(blk *Block) {},
+			hashOK:   true,
+			merkleOK: true,
+		},
+		{
+			name: "transaction changed",
+			txs:  []Tx{testTx("a", 1), testTx("b", 2)},
+			tamper: func(blk *Block) {
+				blk.TxMerkleTree.LeafNodes[1].Transaction.Amount = 200
+			},
+			hashOK:   true,
+			merkleOK: false,
+		},
+		{
+			name: "transaction replaced along with its leaf hash",
+			txs:  []Tx{testTx("a", 1), testTx("b", 2)},
+			tamper: func(blk *Block) {
+				other := testTx("c", 3)
+				blk.TxMerkleTree.LeafNodes[0].Transaction = other
+				blk.TxMerkleTree.LeafNodes[0].HashValue, _ = other.CalculateTxHash()
+			},
+			hashOK:   true,
+			merkleOK: false,
+		},
+		{
+			name: "transaction dropped",
+			txs:  []Tx{testTx("a", 1), testTx("b", 2), testTx("c", 3)},
+			tamper: func(blk *Block) {
+				blk.TxMerkleTree.LeafNodes = blk.TxMerkleTree.LeafNodes[:2]
+			},
+			hashOK:   true,
+			merkleOK: false,
+		},
+		{
+			name: "transactions reordered",
+			txs:  []Tx{testTx("a", 1), testTx("b", 2)},
+			tamper: func(blk *Block) {
+				leaves := blk.TxMerkleTree.LeafNodes
+				leaves[0], leaves[1] = leaves[1], leaves[0]
+			},
+			hashOK:   true,
+			merkleOK: false,
+		},
+		{
+			name: "merkle root replaced",
+			txs:  []Tx{testTx("a", 1), testTx("b", 2)},
+			tamper: func(blk *Block) {
+				blk.TxMerkleTree.Root = &Node{HashValue: []byte("some other root")}
+			},
+			hashOK:   false,
+			merkleOK: false,
+		},
+		{
+			name: "merkle root missing",
+			txs:  []Tx{testTx("a", 1)},
+			tamper: func(blk *Block) {
+				blk.TxMerkleTree.Root = nil
+				blk.TxMerkleTree.LeafNodes = nil
+			},
+			merkleOK: false,
+		},
+		{
+			name: "previous hash changed",
+			tamper: func(blk *Block) {
+				blk.PreviousHash = []byte("another previous block hash")
+			},
+			hashOK:   false,
+			merkleOK: true,
+		},
+		{
+			name: "miner changed",
+			tamper: func(blk *Block) {
+				blk.Miner = []byte("relayer")
+			},
+			hashOK:   false,
+			merkleOK: true,
+		},
+		{
+			name: "height changed",
+			txs:  []Tx{testTx("a", 1)},
+			tamper: func(blk *Block) {
+				blk.Height = 1000
+			},
+			hashOK:   false,
+			merkleOK: true,
+		},
+		{
+			name: "difficulty changed",
+			txs:  []Tx{testTx("a", 1)},
+			tamper: func(blk *Block) {
+				blk.Difficulty = 0
+			},
+			hashOK:   false,
+			merkleOK: true,
+		},
+		{
+			name: "nonce changed",
+			txs:  []Tx{testTx("a", 1)},
+			tamper: func(blk *Block) {
+				blk.Nonce++
+			},
+			hashOK:   false,
+			merkleOK: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blk := testBlock(t, test.txs...)
+			test.tamper(blk)
+			// a block without a root can not be hashed, the merkle check has to catch it first
+			if blk.IsEmpty() || blk.TxMerkleTree.Root != nil {
+				if got := blk.VerifyBlockHash(); got != test.hashOK {
+					t.Errorf("VerifyBlockHash() = %v, want %v", got, test.hashOK)
+				}
+			}
+			if got := blk.VerifyMerkleRoot(); got != test.merkleOK {
+				t.Errorf("VerifyMerkleRoot() = %v, want %v", got, test.merkleOK)
+			}
+		})
+	}
+}
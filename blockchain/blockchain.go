@@ -2,10 +2,14 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
 	"runtime"
+	"strconv"
+	"sync"
 
 	"github.com/dgraph-io/badger"
 	"github.com/pranjalpokharel7/yudhishthira/utility"
@@ -13,44 +17,66 @@ import (
 )
 
 // technically block chain is just a chain of blocks
-// TODO: single int field to determine whether the chain is main chain or test chain
+// the params tell which network (main, test or regtest) the chain belongs to
 type BlockChain struct {
 	Database *badger.DB
 	LastHash []byte
+	Params   *ChainParams
+
+	mutex   sync.Mutex  // held while a block is added or the chain reorganizes, so the tip can not move in between
+	pending *badger.Txn // set on the copy of the chain Reorganize switches branches on, reads see what it has not committed yet
 }
 
 type BlockChainIterator struct {
 	CurrentHash []byte
 	Database    *badger.DB
+
+	txn *badger.Txn // the pending transaction of the chain the iterator was made from, if any
+}
+
+func InitBlockChain(params *ChainParams) *BlockChain {
+	blockchain, err := OpenBlockChain(params)
+	utility.ErrThenPanic(err)
+	return blockchain
 }
 
-func InitBlockChain() *BlockChain {
+// same as InitBlockChain but the database failing to open is returned, eg. when a running node holds it
+func OpenBlockChain(params *ChainParams) (*BlockChain, error) {
 	var lastHash []byte
 
-	badgerOpts := badger.DefaultOptions(DB_PATH)
+	badgerOpts := badger.DefaultOptions(params.DBPath).WithLogger(utility.LevelLogger{Prefix: "badger: "})
 	os := runtime.GOOS
 	if os == "windows" {
 		badgerOpts.Truncate = true
 	}
 	db, err := badger.Open(badgerOpts)
-	utility.ErrThenPanic(err)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the chain database at %s: %v", params.DBPath, err)
+	}
 
 	// to perform read-write operations, use Update
 	err = db.Update(func(txn *badger.Txn) error {
 		if _, err := txn.Get([]byte(LAST_HASH)); err == badger.ErrKeyNotFound {
-			genesisBlock := CreateGenesisBlock()
+			genesisBlock := CreateGenesisBlock(params)
 			genesisSerialized, err := genesisBlock.SerializeBlockToGOB()
 			utility.ErrThenPanic(err)
 
 			err = txn.Set(genesisBlock.BlockHash, genesisSerialized)
 			utility.ErrThenPanic(err)
 
+			err = txn.Set([]byte(NETWORK_KEY), []byte(params.Name))
+			utility.ErrThenPanic(err)
+
 			err = txn.Set([]byte(LAST_HASH), genesisBlock.BlockHash)
 
 			lastHash = append(lastHash, genesisBlock.BlockHash...)
 			return err
 		}
 
+		if err := checkNetwork(txn, params); err != nil {
+			return err
+		}
+
 		// run a get transaction to get the last hash of the chain
 		item, err := txn.Get([]byte(LAST_HASH))
 		utility.ErrThenPanic(err)
@@ -62,40 +88,204 @@ func InitBlockChain() *BlockChain {
 		})
 		return err
 	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	utility.ErrThenPanic(err)
-
-	blockchain := &BlockChain{db, lastHash}
-	return blockchain
+	blockchain := &BlockChain{Database: db, LastHash: lastHash, Params: params}
+	if !blockchain.hasKey(PRICE_INDEX_KEY) {
+		if err := blockchain.ReindexPrices(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if !blockchain.hasKey(CREDIT_INDEX_KEY) {
+		if err := blockchain.ReindexCredits(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return blockchain, nil
 }
 
+// adds the block on top of the tip, blocks of another branch are added by Reorganize
 func (blockchain *BlockChain) AddBlock(latestBlock *Block) error {
+	blockchain.mutex.Lock()
+	defer blockchain.mutex.Unlock()
+
+	return blockchain.addBlock(latestBlock)
+}
+
+// must be called with the chain mutex held
+func (blockchain *BlockChain) addBlock(latestBlock *Block) error {
+	if err := blockchain.checkBlock(latestBlock); err != nil {
+		return err
+	}
+	err := blockchain.Database.Update(func(txn *badger.Txn) error {
+		return blockchain.writeBlock(txn, latestBlock)
+	})
+	if err != nil {
+		return err
+	}
+
+	blockchain.LastHash = latestBlock.BlockHash
+	return nil
+}
+
+// the block is valid on top of the tip, checked before it is written
+func (blockchain *BlockChain) checkBlock(latestBlock *Block) error {
+	// the hash commits to the transactions only through the merkle root
+	if !latestBlock.VerifyMerkleRoot() {
+		return errors.New("merkle root does not match the transactions of the block")
+	}
+
 	// check if block hash is correct
 	verifiedBlockHash := latestBlock.VerifyBlockHash()
 	if !verifiedBlockHash {
 		return errors.New("block hash does not match")
 	}
 
-	// check if proof of work has been done on the block
-	verifiedProof := latestBlock.VerifyProof()
-	if !verifiedProof {
-		return errors.New("proof of work hasn't been done on the block")
+	// proof of work or the signature of a validator, depending on the network
+	engine, err := blockchain.Params.ConsensusEngine()
+	if err != nil {
+		return err
+	}
+	if err := engine.VerifySeal(latestBlock, blockchain); err != nil {
+		return err
 	}
 
-	err := blockchain.Database.Update(func(txn *badger.Txn) error {
-		latestBlockSerialized, err := latestBlock.SerializeBlockToGOB()
-		utility.ErrThenPanic(err)
+	// transactions are checked against the state of the tip, a block on another tip is refused before that
+	// the tip is checked again when the block is written, so the state can not change in between
+	if !bytes.Equal(latestBlock.PreviousHash, blockchain.LastHash) {
+		return fmt.Errorf("block %x does not extend the tip %x", latestBlock.BlockHash, blockchain.LastHash)
+	}
 
-		err = txn.Set(latestBlock.BlockHash, latestBlockSerialized)
-		utility.ErrThenPanic(err)
+	// transfers still waiting for the buyer's countersignature, or swaps missing a seller's signature, can not be committed
+	if !latestBlock.IsEmpty() {
+		blockItems := make(map[string]bool)
+		for _, txNode := range latestBlock.TxMerkleTree.LeafNodes {
+			if txNode.Transaction.IsPending() {
+				return fmt.Errorf("transaction %x is pending acceptance or signatures", txNode.Transaction.TxID)
+			}
+			if !txNode.Transaction.IsFinal(latestBlock.Height, latestBlock.Timestamp) {
+				return fmt.Errorf("transaction %x is time locked beyond this block", txNode.Transaction.TxID)
+			}
+			// the rules the memory pool applies hold for mined blocks as well, eg. signatures, ownership, lost or retired items and escrow arbiters
+			// no transaction depends on another one of the block, as items move at most once per block
+			if rejection := validateTxContent(&txNode.Transaction, blockchain); rejection != nil {
+				return fmt.Errorf("transaction %x: %v", txNode.Transaction.TxID, rejection)
+			}
+			// an item moves at most once per block, including the legs of swaps and the parts of assemblies
+			for _, involvedItem := range txNode.Transaction.InvolvedItems() {
+				itemHashString := hex.EncodeToString(involvedItem)
+				if blockItems[itemHashString] {
+					return fmt.Errorf("item %s is moved by more than one transaction in the block", itemHashString)
+				}
+				blockItems[itemHashString] = true
+			}
+		}
+	}
+	return nil
+}
+
+// stores the block as the new tip along with the state it changes, and what that state was before so it can be taken off again
+func (blockchain *BlockChain) writeBlock(txn *badger.Txn, latestBlock *Block) error {
+	// blocks only ever extend the tip, a block mined on an older tip lost the race to one from a peer
+	// read within the transaction, so badger refuses one of two blocks added on the same tip at once
+	lastHashQuery, err := txn.Get([]byte(LAST_HASH))
+	if err != nil {
+		return err
+	}
+	lastHash, err := lastHashQuery.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(latestBlock.PreviousHash, lastHash) {
+		return fmt.Errorf("block %x does not extend the tip %x", latestBlock.BlockHash, lastHash)
+	}
+
+	if err := recordBlockUndo(txn, latestBlock, blockchain.Params); err != nil {
+		return err
+	}
+	// a block that spends more introduction credits than its wallets hold is refused as a whole
+	if err := applyBlockCredits(txn, latestBlock, blockchain.Params, true); err != nil {
+		return err
+	}
+	// votes decided by this block change the validators of the next one
+	if err := applyBlockGovernance(txn, latestBlock, blockchain.Params); err != nil {
+		return err
+	}
+
+	latestBlockSerialized, err := latestBlock.SerializeBlockToGOB()
+	utility.ErrThenPanic(err)
+
+	// returned rather than panicking, a reorganization too large for one transaction is refused with badger.ErrTxnTooBig
+	if err := txn.Set(latestBlock.BlockHash, latestBlockSerialized); err != nil {
+		return err
+	}
+	if err := txn.Set([]byte(LAST_HASH), latestBlock.BlockHash); err != nil {
+		return err
+	}
 
-		err = txn.Set([]byte(LAST_HASH), latestBlock.BlockHash)
-		blockchain.LastHash = latestBlock.BlockHash
+	// analytics are read from the price index instead of scanning the chain
+	return indexBlockPrices(txn, latestBlock)
+}
 
+// refuses to open a database created for another network
+// databases from before networks were recorded are accepted if they hold the genesis block of the network
+func checkNetwork(txn *badger.Txn, params *ChainParams) error {
+	item, err := txn.Get([]byte(NETWORK_KEY))
+	if err == badger.ErrKeyNotFound {
+		if _, err := txn.Get(CreateGenesisBlock(params).BlockHash); err != nil {
+			return fmt.Errorf("database at %s does not hold the genesis block of the %s network", params.DBPath, params.Name)
+		}
+		return txn.Set([]byte(NETWORK_KEY), []byte(params.Name))
+	}
+	if err != nil {
 		return err
+	}
+	return item.Value(func(val []byte) error {
+		if string(val) != params.Name {
+			return fmt.Errorf("database at %s belongs to the %s network, not %s", params.DBPath, val, params.Name)
+		}
+		return nil
 	})
+}
+
+// every block of the chain, starting with the genesis block
+func (blockchain *BlockChain) blocksFromGenesis() []*Block {
+	var blocks []*Block
+	iter := BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+		txn:         blockchain.pending,
+	}
+	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
+		blocks = append([]*Block{block}, blocks...)
+	}
+	return blocks
+}
+
+// true if the block is stored, whether it was mined here or received
+func (blockchain *BlockChain) HasBlock(blockHash []byte) bool {
+	return len(blockHash) != 0 && blockchain.hasKey(string(blockHash))
+}
 
-	return err
+// read only transaction, or the pending one of a chain in the middle of a reorganization
+func (blockchain *BlockChain) view(fn func(txn *badger.Txn) error) error {
+	if blockchain.pending != nil {
+		return fn(blockchain.pending)
+	}
+	return blockchain.Database.View(fn)
+}
+
+func (blockchain *BlockChain) hasKey(key string) bool {
+	err := blockchain.view(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(key))
+		return err
+	})
+	return err == nil
 }
 
 // return the last block from the chain and iterator backwards in the chain
@@ -107,7 +297,7 @@ func (iter *BlockChainIterator) GetBlockAndIter() *Block {
 	var block *Block
 
 	// to perform read only transaction, use the View method
-	err := iter.Database.View(func(txn *badger.Txn) error {
+	readBlock := func(txn *badger.Txn) error {
 		item, err := txn.Get(iter.CurrentHash)
 		utility.ErrThenPanic(err)
 		err = item.Value(func(val []byte) error {
@@ -115,7 +305,13 @@ func (iter *BlockChainIterator) GetBlockAndIter() *Block {
 			return err
 		})
 		return err
-	})
+	}
+	var err error
+	if iter.txn != nil {
+		err = readBlock(iter.txn)
+	} else {
+		err = iter.Database.View(readBlock)
+	}
 
 	utility.ErrThenPanic(err)
 	iter.CurrentHash = block.PreviousHash
@@ -130,7 +326,7 @@ func (chain *BlockChain) GetChainHeight() (uint64, error) {
 	}
 
 	// to perform read only transaction, use the View method
-	err := chain.Database.View(func(txn *badger.Txn) error {
+	err := chain.view(func(txn *badger.Txn) error {
 		item, err := txn.Get(chain.LastHash)
 		utility.ErrThenPanic(err)
 		err = item.Value(func(val []byte) error {
@@ -154,6 +350,7 @@ func (blockchain *BlockChain) GetLastNBlocks(n uint64) []*Block {
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	for block, i := iter.GetBlockAndIter(), uint64(0); i < n && block != nil; block, i = iter.GetBlockAndIter(), i+1 {
@@ -170,6 +367,7 @@ func (blockchain *BlockChain) GetLastNTxs(n uint64) []*Tx {
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	for block := iter.GetBlockAndIter(); txCount <= n && block != nil; block = iter.GetBlockAndIter() {
@@ -191,6 +389,7 @@ func (blockchain *BlockChain) GetBlockHashes(blockHash []byte) [][]byte {
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	// we only need heights after a certain block and not the block with the matching itself
@@ -214,6 +413,7 @@ func (blockchain *BlockChain) GetBlockHashesFromHeight(height uint64) [][]byte {
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	// we only need heights after a certain block and not the block with the matching itself
@@ -233,6 +433,7 @@ func (blockchain *BlockChain) GetBlock(blockhash []byte) (*Block, error) {
 	itr := &BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	for b := itr.GetBlockAndIter(); b != nil; b = itr.GetBlockAndIter() {
@@ -245,10 +446,65 @@ func (blockchain *BlockChain) GetBlock(blockhash []byte) (*Block, error) {
 	return nil, err
 }
 
+func (blockchain *BlockChain) BlockAtHeight(height uint64) (*Block, error) {
+	itr := &BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+		txn:         blockchain.pending,
+	}
+
+	for b := itr.GetBlockAndIter(); b != nil && b.Height >= height; b = itr.GetBlockAndIter() {
+		if b.Height == height {
+			return b, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no block at height %d", height)
+}
+
+// a block id is either the hex hash of the block or its height
+func (blockchain *BlockChain) BlockByID(id string) (*Block, error) {
+	if len(id) == 2*sha256.Size {
+		blockHash, err := hex.DecodeString(id)
+		if err != nil {
+			return nil, fmt.Errorf("bad block hash: %v", err)
+		}
+		return blockchain.GetBlock(blockHash)
+	}
+	height, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a block hash nor a height", id)
+	}
+	return blockchain.BlockAtHeight(height)
+}
+
+// the transaction with the id along with the block it was mined in
+func (blockchain *BlockChain) FindTx(txID []byte) (*Tx, *Block, error) {
+	itr := &BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+		txn:         blockchain.pending,
+	}
+
+	for b := itr.GetBlockAndIter(); b != nil; b = itr.GetBlockAndIter() {
+		if b.TxMerkleTree == nil {
+			continue
+		}
+		for _, txNode := range b.TxMerkleTree.LeafNodes {
+			if bytes.Equal(txNode.Transaction.TxID, txID) {
+				return &txNode.Transaction, b, nil
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("transaction %x not found in the chain", txID)
+}
+
 func (blockchain *BlockChain) LastBlock() *Block {
 	itr := &BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	block := itr.GetBlockAndIter()
@@ -259,6 +515,7 @@ func (blockchain *BlockChain) PrintChain() {
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 	block := iter.GetBlockAndIter()
 	for block != nil {
@@ -271,13 +528,14 @@ func (blockchain *BlockChain) FindItemExists(itemHash []byte) (bool, error) {
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	// if nil is returned then that means we reached beyond genesis block on iteration
 	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
 		if block.TxMerkleTree != nil {
 			for _, txNode := range block.TxMerkleTree.LeafNodes {
-				if bytes.Equal(txNode.Transaction.ItemHash, itemHash) {
+				if txNode.Transaction.InvolvesItem(itemHash) {
 					// fmt.Println("Item exists in the chain beforehand")
 					return true, nil
 				}
@@ -293,11 +551,12 @@ func (blockchain *BlockChain) LastBlockWithItem(itemHash []byte) (*Block, int, e
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
 		if block.TxMerkleTree != nil {
 			for txIndex, txNode := range block.TxMerkleTree.LeafNodes {
-				if bytes.Equal(txNode.Transaction.ItemHash, itemHash) {
+				if txNode.Transaction.InvolvesItem(itemHash) {
 					return block, txIndex, nil
 				}
 			}
@@ -307,17 +566,19 @@ func (blockchain *BlockChain) LastBlockWithItem(itemHash []byte) (*Block, int, e
 	return nil, -1, errors.New(err)
 }
 
-// return all transactions that contain the item
+// return all transactions that contain the item, either as the item hash or as a part of an assembly or disassembly
+// see ItemProvenance to also follow the parts
 func (blockchain *BlockChain) TxsIncludingItem(itemHash []byte) []*Tx {
 	var itemTxHistory []*Tx
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
 		if block.TxMerkleTree != nil {
 			for _, txNode := range block.TxMerkleTree.LeafNodes {
-				if bytes.Equal(txNode.Transaction.ItemHash, itemHash) {
+				if txNode.Transaction.InvolvesItem(itemHash) {
 					itemTxHistory = append(itemTxHistory, &txNode.Transaction)
 				}
 			}
@@ -332,6 +593,7 @@ func (blockchain *BlockChain) AllCoinBaseTxs() []*Tx {
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
 		if block.TxMerkleTree != nil {
@@ -367,6 +629,7 @@ func (blockchain *BlockChain) WalletMinedBlocks(walletAddress string) ([]*Block,
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 	pubKeyHash, err := wallet.PubKeyHashFromAddress(walletAddress)
 	if err != nil {
@@ -380,29 +643,48 @@ func (blockchain *BlockChain) WalletMinedBlocks(walletAddress string) ([]*Block,
 	return minedBlocks, nil
 }
 
-// check if wallet has sufficient funds for coinbase transaction
+// check if wallet has sufficient credits for a coinbase transaction
 func HasFundsForCoinbaseTx(walletAddress string, blockchain *BlockChain) (bool, error) {
-	minedBlocks, err := blockchain.WalletMinedBlocks(walletAddress)
+	credits, err := blockchain.WalletCredits(walletAddress)
 	if err != nil {
 		return false, err
 	}
+	return credits >= int64(blockchain.Params.IntroductionCost), nil
+}
 
-	coinbaseTxsDone, err := blockchain.WalletCoinBaseTxs(walletAddress)
+// number of further coinbase transactions the wallet's credits pay for
+func CoinbaseAllowance(walletAddress string, blockchain *BlockChain) (int, error) {
+	credits, err := blockchain.WalletCredits(walletAddress)
 	if err != nil {
-		return false, err
+		return 0, err
+	}
+	if credits <= 0 {
+		return 0, nil
 	}
+	if blockchain.Params.IntroductionCost == 0 {
+		return math.MaxInt32, nil
+	}
+	return int(credits / int64(blockchain.Params.IntroductionCost)), nil
+}
 
-	emptyBlocksMined := 0
-	for _, minedBlock := range minedBlocks {
-		if minedBlock.IsEmpty() {
-			emptyBlocksMined++
+// hex encoded hashes of every item that appears in the chain, collected in a single pass
+func (blockchain *BlockChain) AllItemHashes() map[string]bool {
+	itemHashes := make(map[string]bool)
+	iter := BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+		txn:         blockchain.pending,
+	}
+	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
+		if block.TxMerkleTree != nil {
+			for _, txNode := range block.TxMerkleTree.LeafNodes {
+				for _, involvedItem := range txNode.Transaction.InvolvedItems() {
+					itemHashes[hex.EncodeToString(involvedItem)] = true
+				}
+			}
 		}
 	}
-	nonEmptyBlocksMined := len(minedBlocks) - emptyBlocksMined
-	mineScore := emptyBlocksMined + nonEmptyBlocksMined*MINED_TO_SPEND_RATIO
-
-	hasSufficientFunds := mineScore > 2*MINED_TO_SPEND_RATIO*len(coinbaseTxsDone)
-	return hasSufficientFunds, nil
+	return itemHashes
 }
 
 // TODO: Optimize this function
@@ -414,6 +696,7 @@ func (blockchain *BlockChain) WalletOwnedItems(walletAddress string) ([]string,
 	iter := BlockChainIterator{
 		CurrentHash: blockchain.LastHash,
 		Database:    blockchain.Database,
+		txn:         blockchain.pending,
 	}
 
 	pubKeyHash, err := wallet.PubKeyHashFromAddress(walletAddress)
@@ -424,13 +707,20 @@ func (blockchain *BlockChain) WalletOwnedItems(walletAddress string) ([]string,
 	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
 		if block.TxMerkleTree != nil {
 			for _, txNode := range block.TxMerkleTree.LeafNodes {
-				itemHashString := hex.EncodeToString(txNode.Transaction.ItemHash)
-
-				if _, itemRecorded := chainItems[itemHashString]; !itemRecorded {
-					if bytes.Equal(txNode.Transaction.BuyerHash, pubKeyHash) {
-						chainItems[itemHashString] = true
-					} else if bytes.Equal(txNode.Transaction.SellerHash, pubKeyHash) {
-						chainItems[itemHashString] = false
+				for _, involvedItem := range txNode.Transaction.InvolvedItems() {
+					itemHashString := hex.EncodeToString(involvedItem)
+
+					if _, itemRecorded := chainItems[itemHashString]; !itemRecorded {
+						sellerHash, buyerHash := txNode.Transaction.PartiesFor(involvedItem)
+						// parts inside a composite and disassembled composites are owned by nobody
+						if txNode.Transaction.ConsumesItem(involvedItem) {
+							chainItems[itemHashString] = false
+						} else if bytes.Equal(txNode.Transaction.OwnerAfter(involvedItem), pubKeyHash) {
+							// items in escrow stay with the seller until released
+							chainItems[itemHashString] = true
+						} else if bytes.Equal(sellerHash, pubKeyHash) || bytes.Equal(buyerHash, pubKeyHash) {
+							chainItems[itemHashString] = false
+						}
 					}
 				}
 			}
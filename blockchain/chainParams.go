@@ -0,0 +1,163 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// consensus rules and defaults that are chosen per network rather than fixed in code
+// nodes only talk to peers using the same network magic and chain params id, see p2p.HandleVersion
+type ChainParams struct {
+	Name  string  `json:"name"`
+	Magic [4]byte `json:"magic"` // prefixes every p2p message
+
+	GenesisString    string `json:"genesis_string"` // hashed into the genesis block hash, so every network has its own genesis
+	GenesisTimestamp uint64 `json:"genesis_timestamp"`
+
+	// a block of height h needs BaseDifficulty + h / DifficultyInterval leading zeroes, an interval of zero keeps it constant
+	BaseDifficulty     uint64 `json:"base_difficulty"`
+	DifficultyInterval uint64 `json:"difficulty_interval"`
+	MaxPowIterations   uint64 `json:"max_pow_iterations"`
+
+	// engine sealing the blocks, see consensus.go, empty is proof of work like the networks from before engines existed
+	Consensus string `json:"consensus,omitempty"`
+	// proof of authority only, wallet addresses of the first validators, votes on the chain change the set later (see authority.go)
+	Validators []string `json:"validators,omitempty"`
+	// proof of authority only, seconds a validator waits per turn it is behind the validator whose turn it is
+	BlockPeriod uint64 `json:"block_period,omitempty"`
+
+	// introduction credits, mining a block credits the miner and every coinbase debits the introducer
+	BlockCredits      uint64 `json:"block_credits"`       // block with transactions
+	EmptyBlockCredits uint64 `json:"empty_block_credits"` // block without transactions
+	IntroductionCost  uint64 `json:"introduction_cost"`
+
+	// defaults a node falls back to when its configuration does not say otherwise
+	DBPath  string `json:"db_path"`
+	P2PPort string `json:"p2p_port"`
+	APIPort string `json:"api_port"`
+}
+
+// values of the network before profiles existed, so existing databases stay valid
+var MainNetParams = ChainParams{
+	Name:               "main",
+	Magic:              [4]byte{0x79, 0x75, 0x64, 0x68}, // "yudh"
+	GenesisString:      "BBC News (Thursday, March 10, 2022 1:33:39 PM) - Ukraine war: No progress on ceasefire after Kyiv-Moscow talks",
+	GenesisTimestamp:   1646919219,
+	BaseDifficulty:     1,
+	DifficultyInterval: 2016, // just like bitcoin
+	MaxPowIterations:   100000,
+	BlockCredits:       1,
+	EmptyBlockCredits:  1,
+	IntroductionCost:   2, // two mined blocks pay for introducing one item
+	DBPath:             "./db",
+	P2PPort:            "3000",
+	APIPort:            "8080",
+}
+
+// same rules as the main network on a separate chain, for trying things out with other nodes
+var TestNetParams = ChainParams{
+	Name:               "testnet",
+	Magic:              [4]byte{0x79, 0x75, 0x74, 0x73}, // "yuts"
+	GenesisString:      "yudhishthira testnet genesis",
+	GenesisTimestamp:   1646919219,
+	BaseDifficulty:     1,
+	DifficultyInterval: 2016,
+	MaxPowIterations:   100000,
+	BlockCredits:       1,
+	EmptyBlockCredits:  1,
+	IntroductionCost:   2,
+	DBPath:             "./db-testnet",
+	P2PPort:            "13000",
+	APIPort:            "18080",
+}
+
+// local network for tests, blocks are mined instantly and every mined block pays for an introduction
+var RegTestParams = ChainParams{
+	Name:               "regtest",
+	Magic:              [4]byte{0x79, 0x75, 0x72, 0x74}, // "yurt"
+	GenesisString:      "yudhishthira regtest genesis",
+	GenesisTimestamp:   1646919219,
+	BaseDifficulty:     0,
+	DifficultyInterval: 0,
+	MaxPowIterations:   100000,
+	BlockCredits:       1,
+	EmptyBlockCredits:  1,
+	IntroductionCost:   1,
+	DBPath:             "./db-regtest",
+	P2PPort:            "23000",
+	APIPort:            "28080",
+}
+
+// network of known parties, blocks are signed by the validators in turn instead of mined
+// the first validators come from the node configuration and have to be the same on every node
+var ConsortiumParams = ChainParams{
+	Name:              "consortium",
+	Magic:             [4]byte{0x79, 0x75, 0x63, 0x6f}, // "yuco"
+	GenesisString:     "yudhishthira consortium genesis",
+	GenesisTimestamp:  1646919219,
+	Consensus:         CONSENSUS_POA,
+	BlockPeriod:       5,
+	BlockCredits:      1,
+	EmptyBlockCredits: 1,
+	IntroductionCost:  1,
+	DBPath:            "./db-consortium",
+	P2PPort:           "33000",
+	APIPort:           "38080",
+}
+
+var networkParams = map[string]*ChainParams{
+	MainNetParams.Name:    &MainNetParams,
+	TestNetParams.Name:    &TestNetParams,
+	RegTestParams.Name:    &RegTestParams,
+	ConsortiumParams.Name: &ConsortiumParams,
+}
+
+func NetworkNames() []string {
+	var names []string
+	for name := range networkParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// copy of the built-in profile, so callers can override the node defaults without touching the profile
+func ParamsForNetwork(name string) (*ChainParams, error) {
+	params, ok := networkParams[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q, expected one of %v", name, NetworkNames())
+	}
+	paramsCopy := *params
+	return &paramsCopy, nil
+}
+
+// hash of the consensus rules, peers with another id follow another chain even if they share the magic
+// the node defaults (database path and ports) are left out, nodes may choose those freely
+func (params *ChainParams) ID() []byte {
+	consensusParams := *params
+	consensusParams.DBPath, consensusParams.P2PPort, consensusParams.APIPort = "", "", ""
+	encoded, err := json.Marshal(consensusParams)
+	if err != nil {
+		panic(err)
+	}
+	id := sha256.Sum256(encoded)
+	return id[:]
+}
+
+// credits paid to the miner of the block
+func (params *ChainParams) MiningCredits(block *Block) uint64 {
+	if block.IsEmpty() {
+		return params.EmptyBlockCredits
+	}
+	return params.BlockCredits
+}
+
+// leading zeroes the hash of a block at the given height needs
+func (params *ChainParams) Difficulty(height uint64) uint64 {
+	if params.DifficultyInterval == 0 {
+		return params.BaseDifficulty
+	}
+	return params.BaseDifficulty + height/params.DifficultyInterval
+}
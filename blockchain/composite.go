@@ -0,0 +1,325 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// item consumed by an assembly or released by a disassembly
+// UTXOID references the last transaction of the part, it is empty for parts that did not exist before
+type TxPart struct {
+	ItemHash utility.HexByte `json:"itemHash"`
+	UTXOID   utility.HexByte `json:"UTXOID"`
+}
+
+func (tx *Tx) PartHashes() []utility.HexByte {
+	var partHashes []utility.HexByte
+	for _, part := range tx.Parts {
+		partHashes = append(partHashes, part.ItemHash)
+	}
+	return partHashes
+}
+
+// the item hash of the transaction followed by the hashes of its parts, or the items of every leg for swaps
+func (tx *Tx) InvolvedItems() [][]byte {
+	var involvedItems [][]byte
+	if len(tx.ItemHash) != 0 {
+		involvedItems = append(involvedItems, tx.ItemHash)
+	}
+	for _, part := range tx.Parts {
+		involvedItems = append(involvedItems, part.ItemHash)
+	}
+	for _, leg := range tx.Legs {
+		involvedItems = append(involvedItems, leg.ItemHash)
+	}
+	return involvedItems
+}
+
+func (tx *Tx) InvolvesItem(itemHash []byte) bool {
+	for _, involvedItem := range tx.InvolvedItems() {
+		if bytes.Equal(involvedItem, itemHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// true if the item stops existing on its own after this transaction, ie. parts of an assembly and the composite of a disassembly
+func (tx *Tx) ConsumesItem(itemHash []byte) bool {
+	switch tx.Type {
+	case TX_ASSEMBLE:
+		return !bytes.Equal(tx.ItemHash, itemHash) && tx.InvolvesItem(itemHash)
+	case TX_DISASSEMBLE:
+		return bytes.Equal(tx.ItemHash, itemHash)
+	}
+	return false
+}
+
+// builds an assembly of owned parts into a new composite item, the assembler becomes the composite's introducer and pays the introduction cost
+func NewUnsignedAssemblyTransaction(ownerAddr string, compositeHash []byte, partHashes [][]byte, note string, chain *BlockChain) (*Tx, error) {
+	ownerPubKeyHash, err := wallet.PubKeyHashFromAddress(ownerAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []TxPart
+	for _, partHash := range partHashes {
+		partState, err := chain.ItemState(partHash)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, TxPart{ItemHash: partHash, UTXOID: partState.LastTxID})
+	}
+
+	newTx := Tx{
+		ItemHash:   compositeHash,
+		SellerHash: ownerPubKeyHash,
+		BuyerHash:  ownerPubKeyHash,
+		Timestamp:  uint64(time.Now().Unix()),
+		Type:       TX_ASSEMBLE,
+		Note:       note,
+		Parts:      parts,
+	}
+	return finishCompositeTx(&newTx, chain)
+}
+
+// builds a disassembly of an owned composite, parts are either the ones it was assembled from or new items paid at the introduction cost
+func NewUnsignedDisassemblyTransaction(ownerAddr string, compositeHash []byte, partHashes [][]byte, note string, chain *BlockChain) (*Tx, error) {
+	ownerPubKeyHash, err := wallet.PubKeyHashFromAddress(ownerAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	compositeState, err := chain.ItemState(compositeHash)
+	if err != nil {
+		return nil, err
+	}
+	// default to releasing the parts the composite was assembled from
+	if len(partHashes) == 0 {
+		for _, partHash := range compositeState.Parts {
+			partHashes = append(partHashes, partHash)
+		}
+	}
+
+	var parts []TxPart
+	for _, partHash := range partHashes {
+		part := TxPart{ItemHash: partHash}
+		if partState, err := chain.ItemState(partHash); err == nil {
+			part.UTXOID = partState.LastTxID
+		}
+		parts = append(parts, part)
+	}
+
+	newTx := Tx{
+		ItemHash:   compositeHash,
+		SellerHash: ownerPubKeyHash,
+		BuyerHash:  ownerPubKeyHash,
+		UTXOID:     compositeState.LastTxID,
+		Timestamp:  uint64(time.Now().Unix()),
+		Type:       TX_DISASSEMBLE,
+		Note:       note,
+		Parts:      parts,
+	}
+	return finishCompositeTx(&newTx, chain)
+}
+
+// validates against the chain before hashing, so the caller gets the reason instead of a transaction peers would refuse
+func finishCompositeTx(newTx *Tx, chain *BlockChain) (*Tx, error) {
+	if rejection := checkCompositeTx(newTx, chain); rejection != nil {
+		return nil, rejection
+	}
+	txID, err := newTx.CalculateTxHash()
+	if err != nil {
+		return nil, err
+	}
+	newTx.TxID = txID
+	return newTx, nil
+}
+
+func NewAssemblyTransaction(srcWallet *wallet.Wallet, compositeHash []byte, partHashes [][]byte, note string, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedAssemblyTransaction(string(srcWallet.Address), compositeHash, partHashes, note, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(srcWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+func NewDisassemblyTransaction(srcWallet *wallet.Wallet, compositeHash []byte, partHashes [][]byte, note string, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedDisassemblyTransaction(string(srcWallet.Address), compositeHash, partHashes, note, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(srcWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+// called by ValidateTransaction once hash and signatures are checked
+func validateCompositeTx(tx *Tx, chain *BlockChain) *TxRejection {
+	if tx.RequiresAcceptance {
+		return Reject(REJECT_MALFORMED, "only transfers can require acceptance")
+	}
+	return checkCompositeTx(tx, chain)
+}
+
+// the owner stays the same on both sides, every part must be usable and referenced by its latest transaction
+func checkCompositeTx(tx *Tx, chain *BlockChain) *TxRejection {
+	if !bytes.Equal(tx.SellerHash, tx.BuyerHash) {
+		return Reject(REJECT_MALFORMED, "assembly and disassembly keep the items with their owner")
+	}
+	if len(tx.Parts) == 0 {
+		return Reject(REJECT_MALFORMED, "at least one part is required")
+	}
+	seenParts := make(map[string]bool)
+	for _, part := range tx.Parts {
+		partHashString := hex.EncodeToString(part.ItemHash)
+		if len(part.ItemHash) == 0 || bytes.Equal(part.ItemHash, tx.ItemHash) || seenParts[partHashString] {
+			return Reject(REJECT_MALFORMED, fmt.Sprintf("invalid or repeated part %s", partHashString))
+		}
+		seenParts[partHashString] = true
+	}
+
+	var rejection *TxRejection
+	if tx.Type == TX_ASSEMBLE {
+		rejection = checkAssembly(tx, chain)
+	} else {
+		rejection = checkDisassembly(tx, chain)
+	}
+	if rejection != nil {
+		return rejection
+	}
+	// the new composite of an assembly and new parts of a disassembly are paid for like coinbases
+	return checkIntroductionFunds(tx, chain)
+}
+
+func checkAssembly(tx *Tx, chain *BlockChain) *TxRejection {
+	if len(tx.UTXOID) != 0 {
+		return Reject(REJECT_MALFORMED, "the composite of an assembly is a new item and spends nothing")
+	}
+	compositeExists, err := chain.FindItemExists(tx.ItemHash)
+	if err != nil {
+		return Reject(REJECT_INTERNAL, err.Error())
+	}
+	if compositeExists {
+		return Reject(REJECT_ITEM_EXISTS, "composite item already exists in the chain")
+	}
+
+	for _, part := range tx.Parts {
+		partState, err := chain.ItemState(part.ItemHash)
+		if err != nil {
+			return Reject(REJECT_UNKNOWN_ITEM, err.Error())
+		}
+		if !bytes.Equal(partState.Owner, tx.SellerHash) {
+			return Reject(REJECT_NOT_OWNER, fmt.Sprintf("part %x does not belong to the assembler", part.ItemHash))
+		}
+		if rejection := partState.transferRejection(); rejection != nil {
+			return rejection
+		}
+		if !bytes.Equal(partState.LastTxID, part.UTXOID) {
+			return Reject(REJECT_STALE_INPUT, fmt.Sprintf("part %x is not referenced by its latest transaction", part.ItemHash))
+		}
+	}
+	return nil
+}
+
+func checkDisassembly(tx *Tx, chain *BlockChain) *TxRejection {
+	compositeState, err := chain.ItemState(tx.ItemHash)
+	if err != nil {
+		return Reject(REJECT_UNKNOWN_ITEM, err.Error())
+	}
+	if bytes.Equal(compositeState.LastTxID, tx.TxID) {
+		return Reject(REJECT_ALREADY_MINED, "transaction is already part of the chain")
+	}
+	if !bytes.Equal(compositeState.Owner, tx.SellerHash) {
+		return Reject(REJECT_NOT_OWNER, "the composite item does not belong to the disassembler")
+	}
+	if rejection := compositeState.transferRejection(); rejection != nil {
+		return rejection
+	}
+	if !bytes.Equal(compositeState.LastTxID, tx.UTXOID) {
+		return Reject(REJECT_STALE_INPUT, "transaction does not spend the latest transaction of the composite item")
+	}
+
+	for _, part := range tx.Parts {
+		partState, err := chain.ItemState(part.ItemHash)
+		if err != nil {
+			// a part the chain has not seen yet is introduced by the disassembly, at the introduction cost (see CreditDebit)
+			if len(part.UTXOID) != 0 {
+				return Reject(REJECT_UNKNOWN_ITEM, err.Error())
+			}
+			continue
+		}
+		if partState.Status != ITEM_ASSEMBLED || !bytes.Equal(partState.AssembledInto, tx.ItemHash) {
+			return Reject(REJECT_INVALID_STATE, fmt.Sprintf("part %x is not assembled into the composite item", part.ItemHash))
+		}
+		if !bytes.Equal(partState.LastTxID, part.UTXOID) {
+			return Reject(REJECT_STALE_INPUT, fmt.Sprintf("part %x is not referenced by its latest transaction", part.ItemHash))
+		}
+	}
+	return nil
+}
+
+// every transaction of the item and of every item it was assembled from or split off, followed through all
+// assemblies and disassemblies, ordered from the latest transaction to the oldest one like TxsIncludingItem
+func (blockchain *BlockChain) ItemProvenance(itemHash []byte) ([]*Tx, error) {
+	provenanceItems := map[string]bool{hex.EncodeToString(itemHash): true}
+	queue := [][]byte{itemHash}
+	for len(queue) > 0 {
+		currentItem := queue[0]
+		queue = queue[1:]
+
+		for _, tx := range blockchain.TxsIncludingItem(currentItem) {
+			var sources [][]byte
+			if tx.Type == TX_ASSEMBLE && bytes.Equal(tx.ItemHash, currentItem) {
+				for _, partHash := range tx.PartHashes() {
+					sources = append(sources, partHash)
+				}
+			} else if tx.Type == TX_DISASSEMBLE && !bytes.Equal(tx.ItemHash, currentItem) {
+				sources = [][]byte{tx.ItemHash}
+			}
+			for _, source := range sources {
+				sourceString := hex.EncodeToString(source)
+				if !provenanceItems[sourceString] {
+					provenanceItems[sourceString] = true
+					queue = append(queue, source)
+				}
+			}
+		}
+	}
+
+	var provenanceTxs []*Tx
+	iter := BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+		txn:         blockchain.pending,
+	}
+	for block := iter.GetBlockAndIter(); block != nil; block = iter.GetBlockAndIter() {
+		if block.TxMerkleTree != nil {
+			for i := range block.TxMerkleTree.LeafNodes {
+				tx := &block.TxMerkleTree.LeafNodes[i].Transaction
+				for _, involvedItem := range tx.InvolvedItems() {
+					if provenanceItems[hex.EncodeToString(involvedItem)] {
+						provenanceTxs = append(provenanceTxs, tx)
+						break
+					}
+				}
+			}
+		}
+	}
+	if len(provenanceTxs) == 0 {
+		return nil, errors.New("item does not exist in the chain")
+	}
+	return provenanceTxs, nil
+}
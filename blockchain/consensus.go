@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// consensus engines, see ChainParams.Consensus
+const (
+	CONSENSUS_POW = "pow" // blocks are mined, the hash needs leading zeroes (see proof.go)
+	CONSENSUS_POA = "poa" // blocks are signed by the validators taking turns (see authority.go)
+)
+
+// decides who may add a block to the chain, the rest of AddBlock is the same for every engine
+type Consensus interface {
+	Name() string
+	// sets the hash of a block that has its transactions, previous hash and height, and whatever else the engine needs to accept it
+	Seal(ctx context.Context, blk *Block, chain *BlockChain, wlt *wallet.Wallet, threads int) error
+	// checks the seal of a block extending the tip, the hash matching the content is checked by AddBlock
+	VerifySeal(blk *Block, chain *BlockChain) error
+	// the part of VerifySeal that does not depend on the tip, Reorganize checks every block of a branch with it before switching
+	CheckSeal(blk *Block, params *ChainParams) error
+}
+
+type proofOfWork struct{}
+
+func (proofOfWork) Name() string {
+	return CONSENSUS_POW
+}
+
+func (proofOfWork) Seal(ctx context.Context, blk *Block, chain *BlockChain, wlt *wallet.Wallet, threads int) error {
+	// create function to calculate difficulty later based on txsum?
+	blk.Difficulty = chain.Params.Difficulty(blk.Height)
+	return ParallelProofOfWork(ctx, blk, chain.Params.MaxPowIterations, threads)
+}
+
+func (engine proofOfWork) VerifySeal(blk *Block, chain *BlockChain) error {
+	return engine.CheckSeal(blk, chain.Params)
+}
+
+// the difficulty only depends on the height, so the whole seal is checked without the chain
+func (proofOfWork) CheckSeal(blk *Block, params *ChainParams) error {
+	// the proof is checked against the difficulty the block claims, so that has to be the one the network asks for
+	if blk.Difficulty != params.Difficulty(blk.Height) {
+		return fmt.Errorf("block difficulty %d does not match the %d required at height %d", blk.Difficulty, params.Difficulty(blk.Height), blk.Height)
+	}
+	if !blk.VerifyProof() {
+		return errors.New("proof of work hasn't been done on the block")
+	}
+	return nil
+}
+
+// engine of the network, an unknown one is refused by CheckConsensus before a node starts
+func (params *ChainParams) ConsensusEngine() (Consensus, error) {
+	switch params.Consensus {
+	case "", CONSENSUS_POW:
+		return proofOfWork{}, nil
+	case CONSENSUS_POA:
+		return proofOfAuthority{}, nil
+	}
+	return nil, fmt.Errorf("unknown consensus engine %q, expected %s or %s", params.Consensus, CONSENSUS_POW, CONSENSUS_POA)
+}
+
+// the engine is known and has what it needs, eg. proof of authority needs at least one validator
+func (params *ChainParams) CheckConsensus() error {
+	engine, err := params.ConsensusEngine()
+	if err != nil {
+		return err
+	}
+	if engine.Name() != CONSENSUS_POA {
+		if len(params.Validators) != 0 {
+			return fmt.Errorf("the %s network is not run by validators, they need %s consensus", params.Name, CONSENSUS_POA)
+		}
+		return nil
+	}
+	if len(params.Validators) == 0 {
+		return fmt.Errorf("the %s network needs at least one validator", params.Name)
+	}
+	_, err = params.initialValidators()
+	return err
+}
@@ -0,0 +1,252 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// balances are signed since chains replayed from before the ledger existed may have spent credits ahead of mining them
+func creditKey(pubKeyHash []byte) []byte {
+	return []byte(CREDIT_PREFIX + hex.EncodeToString(pubKeyHash))
+}
+
+// marks a credit transfer as applied, credit transfers have no item whose latest transaction would prevent a replay
+func creditTxKey(txID []byte) []byte {
+	return []byte(CREDIT_TX_PREFIX + hex.EncodeToString(txID))
+}
+
+func creditBalance(txn *badger.Txn, pubKeyHash []byte) (int64, error) {
+	item, err := txn.Get(creditKey(pubKeyHash))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var balance int64
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return errors.New("corrupt credit balance")
+		}
+		balance = int64(binary.BigEndian.Uint64(val))
+		return nil
+	})
+	return balance, err
+}
+
+// adds delta to the balance, strict refuses to take a balance below zero
+func addCredits(txn *badger.Txn, pubKeyHash []byte, delta int64, strict bool) error {
+	balance, err := creditBalance(txn, pubKeyHash)
+	if err != nil {
+		return err
+	}
+	if strict && delta < 0 && balance+delta < 0 {
+		return fmt.Errorf("%s has %d credits, %d required", wallet.AddressFromPubKeyHash(pubKeyHash), balance, -delta)
+	}
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, uint64(balance+delta))
+	return txn.Set(creditKey(pubKeyHash), encoded)
+}
+
+// credits the miner first, so a miner can spend the reward of the block within the block itself
+func applyBlockCredits(txn *badger.Txn, block *Block, params *ChainParams, strict bool) error {
+	if len(block.Miner) != 0 {
+		if err := addCredits(txn, block.Miner, int64(params.MiningCredits(block)), strict); err != nil {
+			return err
+		}
+	}
+	if block.IsEmpty() {
+		return nil
+	}
+
+	for _, txNode := range block.TxMerkleTree.LeafNodes {
+		tx := &txNode.Transaction
+		// only the sender can move its credits, a block is refused if a transfer was not signed by it
+		// blocks replayed by ReindexCredits were checked when they were added
+		if strict && tx.Type == TX_CREDIT_TRANSFER {
+			if rejection := validateTxIntegrity(tx); rejection != nil {
+				return fmt.Errorf("credit transfer %x: %v", tx.TxID, rejection)
+			}
+			if rejection := checkCreditParties(tx); rejection != nil {
+				return fmt.Errorf("credit transfer %x: %v", tx.TxID, rejection)
+			}
+		}
+		payer, debit := tx.CreditDebit(params)
+		if debit == 0 {
+			continue
+		}
+		if err := addCredits(txn, payer, -int64(debit), strict); err != nil {
+			return fmt.Errorf("transaction %x: %v", tx.TxID, err)
+		}
+		if tx.Type != TX_CREDIT_TRANSFER {
+			continue
+		}
+
+		if _, err := txn.Get(creditTxKey(tx.TxID)); err == nil {
+			return fmt.Errorf("credit transfer %x is already part of the chain", tx.TxID)
+		}
+		if err := txn.Set(creditTxKey(tx.TxID), []byte{1}); err != nil {
+			return err
+		}
+		if err := addCredits(txn, tx.BuyerHash, int64(tx.Amount), strict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuilds the ledger from the whole chain, only needed once for chains created before the ledger existed
+func (blockchain *BlockChain) ReindexCredits() error {
+	blocks := blockchain.blocksFromGenesis()
+	return blockchain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			if err := applyBlockCredits(txn, block, blockchain.Params, false); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte(CREDIT_INDEX_KEY), []byte{1})
+	})
+}
+
+// introduction credits of the pubkey hash after the last block
+func (blockchain *BlockChain) CreditBalance(pubKeyHash []byte) (int64, error) {
+	var balance int64
+	err := blockchain.view(func(txn *badger.Txn) error {
+		var err error
+		balance, err = creditBalance(txn, pubKeyHash)
+		return err
+	})
+	return balance, err
+}
+
+func (blockchain *BlockChain) WalletCredits(walletAddress string) (int64, error) {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(walletAddress)
+	if err != nil {
+		return 0, err
+	}
+	return blockchain.CreditBalance(pubKeyHash)
+}
+
+// who pays credits for the transaction and how many, introductions and credit transfers are the only debits
+// every item new to the chain costs the introduction cost: the item of a coinbase, the composite of an assembly
+// and the parts a disassembly introduces, ie. the ones without a previous transaction
+func (tx *Tx) CreditDebit(params *ChainParams) ([]byte, uint64) {
+	if tx.IsCoinbase() {
+		return tx.BuyerHash, params.IntroductionCost
+	}
+	switch tx.Type {
+	case TX_CREDIT_TRANSFER:
+		return tx.SellerHash, tx.Amount
+	case TX_ASSEMBLE:
+		return tx.SellerHash, params.IntroductionCost
+	case TX_DISASSEMBLE:
+		var newParts uint64
+		for _, part := range tx.Parts {
+			if len(part.UTXOID) == 0 {
+				newParts++
+			}
+		}
+		if newParts == 0 {
+			return nil, 0
+		}
+		return tx.SellerHash, newParts * params.IntroductionCost
+	}
+	return nil, 0
+}
+
+func NewUnsignedCreditTransfer(sourceAddr string, destinationAddr string, amount uint64, chain *BlockChain) (*Tx, error) {
+	sourcePubKeyHash, err := wallet.PubKeyHashFromAddress(sourceAddr)
+	if err != nil {
+		return nil, err
+	}
+	destinationPubKeyHash, err := wallet.PubKeyHashFromAddress(destinationAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	creditTx := Tx{
+		SellerHash: sourcePubKeyHash,
+		BuyerHash:  destinationPubKeyHash,
+		Amount:     amount,
+		Timestamp:  uint64(time.Now().Unix()),
+		Type:       TX_CREDIT_TRANSFER,
+	}
+	if rejection := checkCreditTransfer(&creditTx, chain); rejection != nil {
+		return nil, rejection
+	}
+	txID, err := creditTx.CalculateTxHash()
+	if err != nil {
+		return nil, err
+	}
+	creditTx.TxID = txID
+	return &creditTx, nil
+}
+
+func NewCreditTransfer(srcWallet *wallet.Wallet, destinationAddr string, amount uint64, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedCreditTransfer(string(srcWallet.Address), destinationAddr, amount, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(srcWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+// called by ValidateTransaction once hash and signatures are checked
+func validateCreditTransfer(tx *Tx, chain *BlockChain) *TxRejection {
+	if len(tx.ItemHash) != 0 || len(tx.UTXOID) != 0 || len(tx.MetadataHash) != 0 {
+		return Reject(REJECT_MALFORMED, "credit transfers do not involve items")
+	}
+	if tx.RequiresAcceptance || len(tx.Parts) != 0 || len(tx.Legs) != 0 || len(tx.Signers) != 0 || len(tx.ArbiterHash) != 0 {
+		return Reject(REJECT_MALFORMED, "credit transfers are plain transfers signed by the sender")
+	}
+	if rejection := checkCreditParties(tx); rejection != nil {
+		return rejection
+	}
+	err := chain.view(func(txn *badger.Txn) error {
+		_, err := txn.Get(creditTxKey(tx.TxID))
+		return err
+	})
+	if err == nil {
+		return Reject(REJECT_ALREADY_MINED, "transaction is already part of the chain")
+	}
+	return checkCreditTransfer(tx, chain)
+}
+
+func checkCreditTransfer(tx *Tx, chain *BlockChain) *TxRejection {
+	if rejection := checkCreditParties(tx); rejection != nil {
+		return rejection
+	}
+	balance, err := chain.CreditBalance(tx.SellerHash)
+	if err != nil {
+		return Reject(REJECT_INTERNAL, err.Error())
+	}
+	if balance < int64(tx.Amount) {
+		return Reject(REJECT_INSUFFICIENT_FUNDS, fmt.Sprintf("sender has %d credits, %d required", balance, tx.Amount))
+	}
+	return nil
+}
+
+// sender, receiver and amount, the part of a credit transfer the ledger can check without the chain
+func checkCreditParties(tx *Tx) *TxRejection {
+	if len(tx.SellerHash) == 0 || len(tx.BuyerHash) == 0 {
+		return Reject(REJECT_MALFORMED, "credit transfers need a sender and a receiver")
+	}
+	if bytes.Equal(tx.SellerHash, tx.BuyerHash) {
+		return Reject(REJECT_MALFORMED, "sender and receiver of a credit transfer must differ")
+	}
+	if tx.Amount == 0 || tx.Amount > math.MaxInt64 {
+		return Reject(REJECT_MALFORMED, "credit transfers need a positive amount")
+	}
+	return nil
+}
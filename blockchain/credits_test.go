@@ -0,0 +1,194 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+func testCreditTransfer(t *testing.T, from *wallet.Wallet, to []byte, amount uint64) Tx {
+	t.Helper()
+	tx := Tx{
+		SellerHash: testPubKeyHash(t, from),
+		BuyerHash:  to,
+		Amount:     amount,
+		Timestamp:  1,
+		Type:       TX_CREDIT_TRANSFER,
+	}
+	tx.TxID, _ = tx.CalculateTxHash()
+	if err := tx.SignTransaction(from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func testCoinbase(t *testing.T, introducer *wallet.Wallet, itemHash string) Tx {
+	t.Helper()
+	tx, err := NewCoinbaseTx(introducer, []byte(itemHash), nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *tx
+}
+
+func TestApplyBlockCredits(t *testing.T) {
+	alice, bob, miner := testWallet(t), testWallet(t), testWallet(t)
+	aliceHash, bobHash, minerHash := testPubKeyHash(t, alice), testPubKeyHash(t, bob), testPubKeyHash(t, miner)
+
+	// regtest pays one credit per block and charges one per introduction
+	tests := []struct {
+		name         string
+		balances     map[string]int64 // before the block, keyed by pubkey hash
+		miner        []byte
+		txs          func() []Tx
+		strict       bool
+		appliedTwice bool
+		wantErr      bool
+		want         map[string]int64
+	}{
+		{
+			name:   "empty block credits the miner",
+			miner:  minerHash,
+			txs:    func() []Tx { return nil },
+			strict: true,
+			want:   map[string]int64{string(minerHash): 1},
+		},
+		{
+			name:     "coinbase debits the introducer",
+			balances: map[string]int64{string(aliceHash): 2},
+			miner:    minerHash,
+			txs:      func() []Tx { return []Tx{testCoinbase(t, alice, "item")} },
+			strict:   true,
+			want:     map[string]int64{string(aliceHash): 1, string(minerHash): 1},
+		},
+		{
+			name:   "miner spends the credits of the block within it",
+			miner:  aliceHash,
+			txs:    func() []Tx { return []Tx{testCoinbase(t, alice, "item")} },
+			strict: true,
+			want:   map[string]int64{string(aliceHash): 0},
+		},
+		{
+			name:    "introduction without credits is refused",
+			miner:   minerHash,
+			txs:     func() []Tx { return []Tx{testCoinbase(t, alice, "item")} },
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:   "replayed blocks may take a balance below zero",
+			miner:  minerHash,
+			txs:    func() []Tx { return []Tx{testCoinbase(t, alice, "item")} },
+			strict: false,
+			want:   map[string]int64{string(aliceHash): -1, string(minerHash): 1},
+		},
+		{
+			name:     "credit transfer moves credits",
+			balances: map[string]int64{string(aliceHash): 3},
+			miner:    minerHash,
+			txs:      func() []Tx { return []Tx{testCreditTransfer(t, alice, bobHash, 2)} },
+			strict:   true,
+			want:     map[string]int64{string(aliceHash): 1, string(bobHash): 2, string(minerHash): 1},
+		},
+		{
+			name:     "credit transfer over the balance is refused",
+			balances: map[string]int64{string(aliceHash): 1},
+			miner:    minerHash,
+			txs:      func() []Tx { return []Tx{testCreditTransfer(t, alice, bobHash, 2)} },
+			strict:   true,
+			wantErr:  true,
+		},
+		{
+			name:     "credit transfer not signed by the sender is refused",
+			balances: map[string]int64{string(aliceHash): 3},
+			miner:    minerHash,
+			txs: func() []Tx {
+				tx := testCreditTransfer(t, bob, bobHash, 2)
+				tx.SellerHash = aliceHash
+				tx.BuyerHash = minerHash
+				tx.TxID, _ = tx.CalculateTxHash()
+				return []Tx{tx}
+			},
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:     "credit transfer with a changed amount is refused",
+			balances: map[string]int64{string(aliceHash): 3},
+			miner:    minerHash,
+			txs: func() []Tx {
+				tx := testCreditTransfer(t, alice, bobHash, 1)
+				tx.Amount = 3
+				return []Tx{tx}
+			},
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:     "credit transfer to the sender is refused",
+			balances: map[string]int64{string(aliceHash): 3},
+			miner:    minerHash,
+			txs:      func() []Tx { return []Tx{testCreditTransfer(t, alice, aliceHash, 1)} },
+			strict:   true,
+			wantErr:  true,
+		},
+		{
+			name:         "credit transfer is applied once",
+			balances:     map[string]int64{string(aliceHash): 5},
+			miner:        minerHash,
+			txs:          func() []Tx { return []Tx{testCreditTransfer(t, alice, bobHash, 1)} },
+			strict:       true,
+			appliedTwice: true,
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := testChain(t, RegTestParams.Name)
+			err := chain.Database.Update(func(txn *badger.Txn) error {
+				for pubKeyHash, balance := range test.balances {
+					if err := addCredits(txn, []byte(pubKeyHash), balance, false); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blk := &Block{Height: 1, Miner: test.miner}
+			if txs := test.txs(); len(txs) != 0 {
+				if err := blk.AddTransactionsToBlock(txs); err != nil {
+					t.Fatal(err)
+				}
+			}
+			apply := func() error {
+				return chain.Database.Update(func(txn *badger.Txn) error {
+					return applyBlockCredits(txn, blk, chain.Params, test.strict)
+				})
+			}
+			if test.appliedTwice {
+				if err := apply(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = apply()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("applyBlockCredits() error = %v, want error %v", err, test.wantErr)
+			}
+			for pubKeyHash, want := range test.want {
+				got, err := chain.CreditBalance([]byte(pubKeyHash))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("balance of %s = %d, want %d", wallet.AddressFromPubKeyHash([]byte(pubKeyHash)), got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// true once a block of the given height and timestamp may include the transaction
+func (tx *Tx) IsFinal(blockHeight uint64, blockTimestamp uint64) bool {
+	return blockHeight >= tx.LockHeight && blockTimestamp >= tx.LockTime
+}
+
+// the locks are part of the transaction id, so they are set before hashing
+func (tx *Tx) setLock(lockHeight uint64, lockTime uint64) error {
+	tx.LockHeight = lockHeight
+	tx.LockTime = lockTime
+	txID, err := tx.CalculateTxHash()
+	if err != nil {
+		return err
+	}
+	tx.TxID = txID
+	return nil
+}
+
+// transfer that can only be mined from the given block height and unix time on, zero leaves a lock unset
+func NewLockedTransaction(srcWallet *wallet.Wallet, destinationAddr string, itemHash []byte, amount uint64, lockHeight uint64, lockTime uint64, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedTransaction(string(srcWallet.Address), destinationAddr, itemHash, amount, false, chain)
+	if err != nil {
+		return nil, err
+	}
+	if err := newTx.setLock(lockHeight, lockTime); err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(srcWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+// hands the item to the arbiter on behalf of the buyer, the escrow itself can be time locked as well
+func NewUnsignedEscrowTransaction(sourceAddr string, destinationAddr string, arbiterAddr string, itemHash []byte, amount uint64, lockHeight uint64, lockTime uint64, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedTransaction(sourceAddr, destinationAddr, itemHash, amount, false, chain)
+	if err != nil {
+		return nil, err
+	}
+	arbiterPubKeyHash, err := wallet.PubKeyHashFromAddress(arbiterAddr)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(arbiterPubKeyHash, newTx.SellerHash) || bytes.Equal(arbiterPubKeyHash, newTx.BuyerHash) {
+		return nil, errors.New("the arbiter has to be a third party")
+	}
+	newTx.Type = TX_ESCROW
+	newTx.ArbiterHash = arbiterPubKeyHash
+	if err := newTx.setLock(lockHeight, lockTime); err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+func NewEscrowTransaction(srcWallet *wallet.Wallet, destinationAddr string, arbiterAddr string, itemHash []byte, amount uint64, lockHeight uint64, lockTime uint64, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedEscrowTransaction(string(srcWallet.Address), destinationAddr, arbiterAddr, itemHash, amount, lockHeight, lockTime, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(srcWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+// settles the escrow holding the item, release moves it to the buyer and refund returns it to the seller
+func NewUnsignedEscrowSettlement(arbiterAddr string, itemHash []byte, release bool, chain *BlockChain) (*Tx, error) {
+	itemState, err := chain.ItemState(itemHash)
+	if err != nil {
+		return nil, err
+	}
+	if itemState.Status != ITEM_IN_ESCROW {
+		return nil, errors.New("the item is not held in escrow")
+	}
+	arbiterPubKeyHash, err := wallet.PubKeyHashFromAddress(arbiterAddr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(arbiterPubKeyHash, itemState.EscrowArbiter) {
+		return nil, errors.New("only the arbiter of the escrow can settle it")
+	}
+
+	settlementTx := Tx{
+		ItemHash:    itemHash,
+		SellerHash:  itemState.Owner,
+		BuyerHash:   itemState.Owner,
+		ArbiterHash: arbiterPubKeyHash,
+		UTXOID:      itemState.LastTxID,
+		Timestamp:   uint64(time.Now().Unix()),
+		Type:        TX_ESCROW_REFUND,
+	}
+	if release {
+		settlementTx.BuyerHash = itemState.EscrowBuyer
+		settlementTx.Amount = itemState.EscrowAmount
+		settlementTx.Type = TX_ESCROW_RELEASE
+	}
+	txID, err := settlementTx.CalculateTxHash()
+	if err != nil {
+		return nil, err
+	}
+	settlementTx.TxID = txID
+	return &settlementTx, nil
+}
+
+func NewEscrowSettlement(arbiterWallet *wallet.Wallet, itemHash []byte, release bool, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedEscrowSettlement(string(arbiterWallet.Address), itemHash, release, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(arbiterWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
+
+// release and refund spend the escrow transaction and must name the parties it was opened with
+func checkEscrowSettlement(tx *Tx, itemState *ItemState) *TxRejection {
+	if itemState.Status != ITEM_IN_ESCROW {
+		return Reject(REJECT_INVALID_STATE, "the item is not held in escrow")
+	}
+	if !bytes.Equal(tx.ArbiterHash, itemState.EscrowArbiter) {
+		return Reject(REJECT_NOT_ARBITER, "only the arbiter of the escrow can settle it")
+	}
+	if !bytes.Equal(tx.SellerHash, itemState.Owner) {
+		return Reject(REJECT_MALFORMED, "settlement does not name the seller of the escrow")
+	}
+	expectedBuyer := itemState.Owner
+	if tx.Type == TX_ESCROW_RELEASE {
+		expectedBuyer = itemState.EscrowBuyer
+	}
+	if !bytes.Equal(tx.BuyerHash, expectedBuyer) {
+		return Reject(REJECT_MALFORMED, "settlement does not move the item to the right party")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// overall status of an item, recalls are a separate flag since recalled items can still change hands
+const (
+	ITEM_ACTIVE        = "active"
+	ITEM_RETIRED       = "retired"
+	ITEM_REPORTED_LOST = "reported-lost"
+	ITEM_ASSEMBLED     = "assembled"    // consumed as a part of a composite item until it is disassembled
+	ITEM_DISASSEMBLED  = "disassembled" // composite item that was taken apart, it no longer exists
+	ITEM_IN_ESCROW     = "in-escrow"    // owned by the seller but held by an arbiter until released or refunded
+)
+
+// state of an item after replaying every transaction that includes it
+type ItemState struct {
+	ItemHash     utility.HexByte `json:"item_hash"`
+	Status       string          `json:"status"`
+	Owner        utility.HexByte `json:"owner"`
+	Introducer   utility.HexByte `json:"introducer"`
+	LastTxID     utility.HexByte `json:"last_tx_id"`
+	Recalled     bool            `json:"recalled"`
+	RecallNote   string          `json:"recall_note,omitempty"`
+	ReportNote   string          `json:"report_note,omitempty"`
+	RetiredNote  string          `json:"retired_note,omitempty"`
+	IntroducedAt uint64          `json:"introduced_at"`
+	// composite items list their parts, parts point to the composite they are assembled into
+	Parts         []utility.HexByte `json:"parts,omitempty"`
+	AssembledInto utility.HexByte   `json:"assembled_into,omitempty"`
+	// set while the item is in escrow
+	EscrowBuyer   utility.HexByte `json:"escrow_buyer,omitempty"`
+	EscrowArbiter utility.HexByte `json:"escrow_arbiter,omitempty"`
+	EscrowAmount  uint64          `json:"escrow_amount,omitempty"`
+	Transactions  int             `json:"transactions"`
+}
+
+func (blockchain *BlockChain) ItemState(itemHash []byte) (*ItemState, error) {
+	itemTxHistory := blockchain.TxsIncludingItem(itemHash)
+	if len(itemTxHistory) == 0 {
+		return nil, fmt.Errorf("item with hash %x does not exist", itemHash)
+	}
+
+	state := &ItemState{ItemHash: itemHash, Status: ITEM_ACTIVE, Transactions: len(itemTxHistory)}
+	// history is ordered from the latest transaction to the oldest one
+	for i := len(itemTxHistory) - 1; i >= 0; i-- {
+		state.apply(itemTxHistory[i], itemHash)
+	}
+	return state, nil
+}
+
+func (state *ItemState) apply(tx *Tx, itemHash []byte) {
+	state.LastTxID = tx.TxID
+	state.Owner = tx.OwnerAfter(itemHash)
+	if tx.IsCoinbase() {
+		state.Introducer = tx.BuyerHash
+		state.IntroducedAt = tx.Timestamp
+		return
+	}
+
+	switch tx.Type {
+	case TX_RETIRE:
+		state.Status = ITEM_RETIRED
+		state.RetiredNote = tx.Note
+	case TX_RECALL:
+		state.Recalled = true
+		state.RecallNote = tx.Note
+	case TX_REPORT_LOST:
+		state.Status = ITEM_REPORTED_LOST
+		state.ReportNote = tx.Note
+	case TX_CLEAR_REPORT:
+		state.Status = ITEM_ACTIVE
+		state.ReportNote = ""
+	case TX_ASSEMBLE:
+		if bytes.Equal(tx.ItemHash, itemHash) {
+			// the composite is introduced by whoever assembled it
+			state.Introducer = tx.SellerHash
+			state.IntroducedAt = tx.Timestamp
+			state.Parts = tx.PartHashes()
+		} else {
+			state.Status = ITEM_ASSEMBLED
+			state.AssembledInto = tx.ItemHash
+		}
+	case TX_DISASSEMBLE:
+		if bytes.Equal(tx.ItemHash, itemHash) {
+			state.Status = ITEM_DISASSEMBLED
+			return
+		}
+		// parts that never existed before are introduced by the disassembly
+		if len(state.Introducer) == 0 {
+			state.Introducer = tx.SellerHash
+			state.IntroducedAt = tx.Timestamp
+		}
+		state.Status = ITEM_ACTIVE
+		state.AssembledInto = nil
+	case TX_ESCROW:
+		state.Status = ITEM_IN_ESCROW
+		state.EscrowBuyer = tx.BuyerHash
+		state.EscrowArbiter = tx.ArbiterHash
+		state.EscrowAmount = tx.Amount
+	case TX_ESCROW_RELEASE, TX_ESCROW_REFUND:
+		state.Status = ITEM_ACTIVE
+		state.EscrowBuyer, state.EscrowArbiter, state.EscrowAmount = nil, nil, 0
+	}
+}
+
+// owner of the item once the transaction is mined, escrowed items stay with the seller until released
+func (tx *Tx) OwnerAfter(itemHash []byte) []byte {
+	sellerHash, buyerHash := tx.PartiesFor(itemHash)
+	if tx.Type == TX_ESCROW {
+		return sellerHash
+	}
+	return buyerHash
+}
+
+func (state *ItemState) CheckTransferable() error {
+	switch state.Status {
+	case ITEM_RETIRED:
+		return errors.New("the item has been retired and can no longer be transferred")
+	case ITEM_REPORTED_LOST:
+		return errors.New("the item is reported lost or stolen, the owner must clear the report before transferring it")
+	case ITEM_ASSEMBLED:
+		return fmt.Errorf("the item is assembled into %x, disassemble it first", state.AssembledInto)
+	case ITEM_DISASSEMBLED:
+		return errors.New("the item has been disassembled into its parts")
+	case ITEM_IN_ESCROW:
+		return errors.New("the item is held in escrow until the arbiter releases or refunds it")
+	}
+	return nil
+}
+
+// same as CheckTransferable, with the rejection code peers use for the state
+func (state *ItemState) transferRejection() *TxRejection {
+	err := state.CheckTransferable()
+	if err == nil {
+		return nil
+	}
+	reason := fmt.Sprintf("item %x: %v", []byte(state.ItemHash), err)
+	switch state.Status {
+	case ITEM_RETIRED:
+		return Reject(REJECT_ITEM_RETIRED, reason)
+	case ITEM_REPORTED_LOST:
+		return Reject(REJECT_ITEM_REPORTED_LOST, reason)
+	case ITEM_IN_ESCROW:
+		return Reject(REJECT_IN_ESCROW, reason)
+	}
+	return Reject(REJECT_ITEM_CONSUMED, reason)
+}
+
+// checks a lifecycle transaction of the given kind against the item state, the signer is the seller hash
+func (state *ItemState) checkLifecycleTx(txType uint8, signerHash []byte) *TxRejection {
+	if state.Status == ITEM_RETIRED {
+		return Reject(REJECT_ITEM_RETIRED, "the item has been retired, no further transactions are allowed")
+	}
+	if state.Status == ITEM_DISASSEMBLED {
+		return Reject(REJECT_ITEM_CONSUMED, "the item has been disassembled into its parts")
+	}
+
+	// parts inside a composite can still be recalled, everything else waits for the disassembly
+	if txType == TX_RECALL {
+		if !bytes.Equal(state.Introducer, signerHash) {
+			return Reject(REJECT_NOT_INTRODUCER, "only the address that introduced the item can recall it")
+		}
+		if state.Recalled {
+			return Reject(REJECT_INVALID_STATE, "the item is already recalled")
+		}
+		return nil
+	}
+
+	if state.Status == ITEM_ASSEMBLED || state.Status == ITEM_IN_ESCROW {
+		return state.transferRejection()
+	}
+	if !bytes.Equal(state.Owner, signerHash) {
+		return Reject(REJECT_NOT_OWNER, "only the owner of the item can change its state")
+	}
+	switch txType {
+	case TX_REPORT_LOST:
+		if state.Status == ITEM_REPORTED_LOST {
+			return Reject(REJECT_INVALID_STATE, "the item is already reported lost or stolen")
+		}
+	case TX_CLEAR_REPORT:
+		if state.Status != ITEM_REPORTED_LOST {
+			return Reject(REJECT_INVALID_STATE, "the item has no lost or stolen report to clear")
+		}
+	case TX_RETIRE:
+	default:
+		return Reject(REJECT_MALFORMED, fmt.Sprintf("%s is not a lifecycle transaction", TxTypeName(txType)))
+	}
+	return nil
+}
+
+// builds a retire, recall or lost report transaction for the signer to sign, the item keeps its owner
+func NewUnsignedLifecycleTransaction(signerAddr string, itemHash []byte, txType uint8, note string, chain *BlockChain) (*Tx, error) {
+	itemState, err := chain.ItemState(itemHash)
+	if err != nil {
+		return nil, err
+	}
+
+	signerPubKeyHash, err := wallet.PubKeyHashFromAddress(signerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if rejection := itemState.checkLifecycleTx(txType, signerPubKeyHash); rejection != nil {
+		return nil, rejection
+	}
+
+	newTx := Tx{
+		ItemHash:   itemHash,
+		SellerHash: signerPubKeyHash,
+		BuyerHash:  itemState.Owner,
+		UTXOID:     itemState.LastTxID,
+		Timestamp:  uint64(time.Now().Unix()),
+		Type:       txType,
+		Note:       note,
+	}
+	txID, err := newTx.CalculateTxHash()
+	if err != nil {
+		return nil, err
+	}
+	newTx.TxID = txID
+	return &newTx, nil
+}
+
+func NewLifecycleTransaction(srcWallet *wallet.Wallet, itemHash []byte, txType uint8, note string, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedLifecycleTransaction(string(srcWallet.Address), itemHash, txType, note, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(srcWallet)
+	if err != nil {
+		return nil, err
+	}
+	return newTx, nil
+}
@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pranjalpokharel7/yudhishthira/object"
+)
+
+func metadataKey(metadataHash []byte) []byte {
+	return append([]byte(METADATA_PREFIX), metadataHash...)
+}
+
+// hash a coinbase commits to for the blob, the blob is stored under it
+func MetadataHash(blob []byte) []byte {
+	metadataHash := sha256.Sum256(blob)
+	return metadataHash[:]
+}
+
+// stores the blob under its own hash and returns that hash for the coinbase to commit to
+func (blockchain *BlockChain) StoreMetadata(blob []byte) ([]byte, error) {
+	metadataHash := MetadataHash(blob)
+	err := blockchain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(metadataKey(metadataHash), blob)
+	})
+	return metadataHash, err
+}
+
+// returns the blob only if it still hashes to the requested hash
+func (blockchain *BlockChain) GetMetadata(metadataHash []byte) ([]byte, error) {
+	var blob []byte
+	err := blockchain.view(func(txn *badger.Txn) error {
+		item, err := txn.Get(metadataKey(metadataHash))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			blob = append(blob, val...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blobHash := sha256.Sum256(blob)
+	if !bytes.Equal(blobHash[:], metadataHash) {
+		return nil, errors.New("stored metadata does not match its hash")
+	}
+	return blob, nil
+}
+
+func (blockchain *BlockChain) HasMetadata(metadataHash []byte) bool {
+	err := blockchain.view(func(txn *badger.Txn) error {
+		_, err := txn.Get(metadataKey(metadataHash))
+		return err
+	})
+	return err == nil
+}
+
+// transaction that introduced the item into the chain
+func (blockchain *BlockChain) CoinbaseTxOfItem(itemHash []byte) (*Tx, error) {
+	itemTxHistory := blockchain.TxsIncludingItem(itemHash)
+	if len(itemTxHistory) == 0 {
+		return nil, errors.New("item does not exist in the chain")
+	}
+
+	// history is ordered from the latest transaction to the oldest one
+	coinbaseTx := itemTxHistory[len(itemTxHistory)-1]
+	if !coinbaseTx.IsCoinbase() {
+		return nil, errors.New("item has no coinbase transaction in the chain")
+	}
+	return coinbaseTx, nil
+}
+
+// metadata committed to by the item's coinbase, checked against both the commitment and the item hash
+func (blockchain *BlockChain) VerifiedItemMetadata(itemHash []byte) (*object.Object, error) {
+	coinbaseTx, err := blockchain.CoinbaseTxOfItem(itemHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(coinbaseTx.MetadataHash) == 0 {
+		return nil, errors.New("item was introduced without a metadata commitment")
+	}
+
+	blob, err := blockchain.GetMetadata(coinbaseTx.MetadataHash)
+	if err != nil {
+		return nil, err
+	}
+	objectInstance, err := object.ObjectFromMetadataBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+	if !objectInstance.VerifyObjectHashVersion(itemHash, coinbaseTx.ItemHashVersion) {
+		return nil, fmt.Errorf("metadata does not hash to the item hash under hash version %d", coinbaseTx.ItemHashVersion)
+	}
+	return objectInstance, nil
+}
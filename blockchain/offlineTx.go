@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+const UNSIGNED_TX_FORMAT = 1 // bump when the envelope below changes
+
+// envelope for a transaction built on a watch-only node and signed on a machine holding the key
+type UnsignedTx struct {
+	Format     uint8           `json:"format"`
+	SignerHash utility.HexByte `json:"signer_hash"` // pubkey hash of the wallet expected to sign
+	Tx         Tx              `json:"tx"`
+}
+
+func NewUnsignedTxEnvelope(tx *Tx) *UnsignedTx {
+	return &UnsignedTx{
+		Format:     UNSIGNED_TX_FORMAT,
+		SignerHash: tx.SellerHash,
+		Tx:         *tx,
+	}
+}
+
+func LoadUnsignedTxFromFile(unsignedTxFile string) (*UnsignedTx, error) {
+	fileContent, err := ioutil.ReadFile(unsignedTxFile)
+	if err != nil {
+		return nil, err
+	}
+	var unsignedTx UnsignedTx
+	if err := json.Unmarshal(fileContent, &unsignedTx); err != nil {
+		return nil, err
+	}
+	if unsignedTx.Format != UNSIGNED_TX_FORMAT {
+		return nil, fmt.Errorf("unsupported unsigned transaction format %d", unsignedTx.Format)
+	}
+	return &unsignedTx, nil
+}
+
+// checks that the envelope describes what it claims to before signing it with the given wallet
+func (unsignedTx *UnsignedTx) Sign(wlt *wallet.Wallet) (*Tx, error) {
+	tx := unsignedTx.Tx
+
+	walletPubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(walletPubKeyHash, unsignedTx.SignerHash) || !bytes.Equal(walletPubKeyHash, tx.SellerHash) {
+		return nil, errors.New("the wallet is not the seller of this transaction")
+	}
+	if len(tx.Signature) != 0 {
+		return nil, errors.New("transaction is already signed")
+	}
+
+	// the signer can not see the chain, but it can make sure the id commits to the content shown to it
+	txHash, err := tx.CalculateTxHash()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(txHash, tx.TxID) {
+		return nil, errors.New("transaction hash does not match transaction content")
+	}
+
+	if err := tx.SignTransaction(wlt); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
@@ -0,0 +1,426 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pranjalpokharel7/yudhishthira/object"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+)
+
+// periods the market volume can be bucketed into, in seconds
+const (
+	PERIOD_DAY   = 24 * 60 * 60
+	PERIOD_WEEK  = 7 * PERIOD_DAY
+	PERIOD_MONTH = 30 * PERIOD_DAY
+)
+
+// sale of an item as recorded by the price index, timestamped with the block that committed it
+type PricePoint struct {
+	ItemHash  utility.HexByte `json:"item_hash"`
+	TxID      utility.HexByte `json:"tx_id"`
+	Amount    uint64          `json:"amount"`
+	Height    uint64          `json:"height"`
+	Timestamp uint64          `json:"timestamp"`
+	Brand     string          `json:"brand"`
+	Category  string          `json:"category"`
+}
+
+// metadata of an item the index needs to value it, taken from the metadata its coinbase committed to
+type itemPriceInfo struct {
+	Brand     string
+	Category  string
+	BasePrice uint64
+}
+
+type ItemPriceHistory struct {
+	ItemHash      utility.HexByte `json:"item_hash"`
+	Brand         string          `json:"brand"`
+	Category      string          `json:"category"`
+	BasePrice     uint64          `json:"base_price"`
+	LastSalePrice uint64          `json:"last_sale_price"`
+	LastSaleAt    uint64          `json:"last_sale_at"`
+	// percent change of the last sale over the base price, nil when either is unknown
+	Appreciation *float64     `json:"appreciation"`
+	Sales        []PricePoint `json:"sales"`
+}
+
+type PeriodVolume struct {
+	PeriodStart uint64 `json:"period_start"`
+	Sales       int    `json:"sales"`
+	Volume      uint64 `json:"volume"`
+}
+
+// sales of every item sharing a brand or a category
+type MarketGroupStats struct {
+	Name          string         `json:"name"`
+	Items         int            `json:"items"`
+	Sales         int            `json:"sales"`
+	Volume        uint64         `json:"volume"`
+	LastSalePrice uint64         `json:"last_sale_price"`
+	LastSaleAt    uint64         `json:"last_sale_at"`
+	Appreciation  *float64       `json:"appreciation"` // average over items with a base price
+	Periods       []PeriodVolume `json:"periods"`
+}
+
+type MarketStats struct {
+	Period     uint64             `json:"period"`
+	Sales      int                `json:"sales"`
+	Volume     uint64             `json:"volume"`
+	Periods    []PeriodVolume     `json:"periods"`
+	Brands     []MarketGroupStats `json:"brands"`
+	Categories []MarketGroupStats `json:"categories"`
+}
+
+// sale points of an item are keyed by height and position in the block, so a prefix scan returns them oldest first
+func pricePointPrefix(itemHash []byte) []byte {
+	return []byte(PRICE_POINT_PREFIX + hex.EncodeToString(itemHash) + "/")
+}
+
+func pricePointKey(itemHash []byte, height uint64, txIndex int) []byte {
+	position := make([]byte, 12)
+	binary.BigEndian.PutUint64(position[:8], height)
+	binary.BigEndian.PutUint32(position[8:], uint32(txIndex))
+	return append(pricePointPrefix(itemHash), position...)
+}
+
+func priceInfoKey(itemHash []byte) []byte {
+	return []byte(PRICE_INFO_PREFIX + hex.EncodeToString(itemHash))
+}
+
+// item and amount of every sale in the transaction, escrows are counted once they are released
+func (tx *Tx) sales() []TxLeg {
+	switch tx.Type {
+	case TX_TRANSFER:
+		if tx.IsCoinbase() || tx.Amount == 0 {
+			return nil
+		}
+		return []TxLeg{{ItemHash: tx.ItemHash, Amount: tx.Amount}}
+	case TX_ESCROW_RELEASE:
+		return []TxLeg{{ItemHash: tx.ItemHash, Amount: tx.Amount}}
+	case TX_SWAP:
+		var legs []TxLeg
+		for _, leg := range tx.Legs {
+			if leg.Amount != 0 {
+				legs = append(legs, leg)
+			}
+		}
+		return legs
+	}
+	return nil
+}
+
+func getGob(txn *badger.Txn, key []byte, value interface{}) error {
+	item, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	return item.Value(func(val []byte) error {
+		return gob.NewDecoder(bytes.NewReader(val)).Decode(value)
+	})
+}
+
+func setGob(txn *badger.Txn, key []byte, value interface{}) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(value); err != nil {
+		return err
+	}
+	return txn.Set(key, encoded.Bytes())
+}
+
+// brand, category and base price of an item introduced with a metadata blob this node holds
+func coinbasePriceInfo(txn *badger.Txn, tx *Tx) (*itemPriceInfo, bool) {
+	item, err := txn.Get(metadataKey(tx.MetadataHash))
+	if err != nil {
+		return nil, false
+	}
+	var objectInstance *object.Object
+	err = item.Value(func(val []byte) error {
+		objectInstance, err = object.ObjectFromMetadataBlob(val)
+		return err
+	})
+	if err != nil || !objectInstance.VerifyObjectHashVersion(tx.ItemHash, tx.ItemHashVersion) {
+		return nil, false
+	}
+	return &itemPriceInfo{Brand: objectInstance.Brand, Category: objectInstance.Category, BasePrice: objectInstance.BasePrice}, true
+}
+
+// records the sales of a block, called by AddBlock within the transaction that stores the block
+func indexBlockPrices(txn *badger.Txn, block *Block) error {
+	if block.IsEmpty() {
+		return nil
+	}
+	for txIndex, txNode := range block.TxMerkleTree.LeafNodes {
+		tx := &txNode.Transaction
+		if tx.IsCoinbase() && len(tx.MetadataHash) != 0 {
+			if priceInfo, ok := coinbasePriceInfo(txn, tx); ok {
+				if err := setGob(txn, priceInfoKey(tx.ItemHash), priceInfo); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, sale := range tx.sales() {
+			pricePoint := PricePoint{
+				ItemHash:  sale.ItemHash,
+				TxID:      tx.TxID,
+				Amount:    sale.Amount,
+				Height:    block.Height,
+				Timestamp: block.Timestamp,
+			}
+			// sales of items without known metadata are still recorded, only without brand and category
+			var priceInfo itemPriceInfo
+			if err := getGob(txn, priceInfoKey(sale.ItemHash), &priceInfo); err == nil {
+				pricePoint.Brand = priceInfo.Brand
+				pricePoint.Category = priceInfo.Category
+			}
+			if err := setGob(txn, pricePointKey(sale.ItemHash, block.Height, txIndex), &pricePoint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexes the metadata of a coinbase whose blob arrived after the block, eg. fetched from a peer by requestMissingMetadata
+// sales of the item indexed in the meantime get the brand and category they are missing
+func (blockchain *BlockChain) IndexCoinbaseMetadata(tx *Tx) error {
+	return blockchain.Database.Update(func(txn *badger.Txn) error {
+		priceInfo, ok := coinbasePriceInfo(txn, tx)
+		if !ok {
+			return nil
+		}
+		if err := setGob(txn, priceInfoKey(tx.ItemHash), priceInfo); err != nil {
+			return err
+		}
+
+		// collected first, so the index is not written to while it is iterated
+		var keys [][]byte
+		var pricePoints []PricePoint
+		prefix := pricePointPrefix(tx.ItemHash)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var pricePoint PricePoint
+			err := it.Item().Value(func(val []byte) error {
+				return gob.NewDecoder(bytes.NewReader(val)).Decode(&pricePoint)
+			})
+			if err != nil {
+				it.Close()
+				return err
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+			pricePoints = append(pricePoints, pricePoint)
+		}
+		it.Close()
+
+		for i := range pricePoints {
+			pricePoints[i].Brand = priceInfo.Brand
+			pricePoints[i].Category = priceInfo.Category
+			if err := setGob(txn, keys[i], &pricePoints[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// builds the price index from the whole chain, only needed once for chains created before the index existed
+func (blockchain *BlockChain) ReindexPrices() error {
+	// coinbases have to be indexed before the sales of their items
+	blocks := blockchain.blocksFromGenesis()
+	return blockchain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			if err := indexBlockPrices(txn, block); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte(PRICE_INDEX_KEY), []byte{PRICE_INDEX_VERSION})
+	})
+}
+
+// every indexed sale whose key starts with the prefix, ordered by key
+func (blockchain *BlockChain) scanPricePoints(prefix []byte) ([]PricePoint, error) {
+	var pricePoints []PricePoint
+	err := blockchain.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var pricePoint PricePoint
+			err := it.Item().Value(func(val []byte) error {
+				return gob.NewDecoder(bytes.NewReader(val)).Decode(&pricePoint)
+			})
+			if err != nil {
+				return err
+			}
+			pricePoints = append(pricePoints, pricePoint)
+		}
+		return nil
+	})
+	return pricePoints, err
+}
+
+func appreciation(basePrice uint64, salePrice uint64) *float64 {
+	if basePrice == 0 || salePrice == 0 {
+		return nil
+	}
+	percent := (float64(salePrice) - float64(basePrice)) / float64(basePrice) * 100
+	return &percent
+}
+
+func (blockchain *BlockChain) ItemPriceHistory(itemHash []byte) (*ItemPriceHistory, error) {
+	pricePoints, err := blockchain.scanPricePoints(pricePointPrefix(itemHash))
+	if err != nil {
+		return nil, err
+	}
+
+	history := ItemPriceHistory{ItemHash: itemHash, Sales: pricePoints}
+	var priceInfo itemPriceInfo
+	err = blockchain.view(func(txn *badger.Txn) error {
+		return getGob(txn, priceInfoKey(itemHash), &priceInfo)
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+	history.Brand = priceInfo.Brand
+	history.Category = priceInfo.Category
+	history.BasePrice = priceInfo.BasePrice
+
+	if len(pricePoints) != 0 {
+		lastSale := pricePoints[len(pricePoints)-1]
+		history.LastSalePrice = lastSale.Amount
+		history.LastSaleAt = lastSale.Timestamp
+		history.Appreciation = appreciation(priceInfo.BasePrice, lastSale.Amount)
+	}
+	return &history, nil
+}
+
+// running totals of a market group while the index is scanned
+type marketGroup struct {
+	stats         MarketGroupStats
+	lastHeight    uint64
+	periods       map[uint64]*PeriodVolume
+	lastSales     map[string]uint64 // latest sale price per item
+	appreciations []float64
+}
+
+func newMarketGroup(name string) *marketGroup {
+	return &marketGroup{
+		stats:     MarketGroupStats{Name: name},
+		periods:   make(map[uint64]*PeriodVolume),
+		lastSales: make(map[string]uint64),
+	}
+}
+
+func (group *marketGroup) add(pricePoint *PricePoint, period uint64) {
+	group.stats.Sales++
+	group.stats.Volume += pricePoint.Amount
+	// blocks can share a timestamp, the height tells which sale came last
+	if group.lastHeight == 0 || pricePoint.Height >= group.lastHeight {
+		group.lastHeight = pricePoint.Height
+		group.stats.LastSalePrice = pricePoint.Amount
+		group.stats.LastSaleAt = pricePoint.Timestamp
+	}
+	periodStart := pricePoint.Timestamp - pricePoint.Timestamp%period
+	if group.periods[periodStart] == nil {
+		group.periods[periodStart] = &PeriodVolume{PeriodStart: periodStart}
+	}
+	group.periods[periodStart].Sales++
+	group.periods[periodStart].Volume += pricePoint.Amount
+	// points of an item are scanned oldest first
+	group.lastSales[hex.EncodeToString(pricePoint.ItemHash)] = pricePoint.Amount
+}
+
+func (group *marketGroup) finish(basePrices map[string]uint64) MarketGroupStats {
+	group.stats.Items = len(group.lastSales)
+	for _, periodVolume := range group.periods {
+		group.stats.Periods = append(group.stats.Periods, *periodVolume)
+	}
+	sort.Slice(group.stats.Periods, func(i, j int) bool {
+		return group.stats.Periods[i].PeriodStart < group.stats.Periods[j].PeriodStart
+	})
+
+	var total float64
+	var valued int
+	for itemHashString, lastSale := range group.lastSales {
+		if percent := appreciation(basePrices[itemHashString], lastSale); percent != nil {
+			total += *percent
+			valued++
+		}
+	}
+	if valued != 0 {
+		average := total / float64(valued)
+		group.stats.Appreciation = &average
+	}
+	return group.stats
+}
+
+func sortedGroupStats(groups map[string]*marketGroup, basePrices map[string]uint64) []MarketGroupStats {
+	groupStats := []MarketGroupStats{}
+	for _, group := range groups {
+		groupStats = append(groupStats, group.finish(basePrices))
+	}
+	sort.Slice(groupStats, func(i, j int) bool {
+		return groupStats[i].Volume > groupStats[j].Volume
+	})
+	return groupStats
+}
+
+// sale volume and appreciation of the whole market and per brand and category, bucketed by period seconds
+func (blockchain *BlockChain) MarketStats(period uint64) (*MarketStats, error) {
+	if period == 0 {
+		period = PERIOD_DAY
+	}
+	pricePoints, err := blockchain.scanPricePoints([]byte(PRICE_POINT_PREFIX))
+	if err != nil {
+		return nil, err
+	}
+
+	basePrices := make(map[string]uint64)
+	err = blockchain.view(func(txn *badger.Txn) error {
+		for _, pricePoint := range pricePoints {
+			itemHashString := hex.EncodeToString(pricePoint.ItemHash)
+			if _, seen := basePrices[itemHashString]; seen {
+				continue
+			}
+			var priceInfo itemPriceInfo
+			if err := getGob(txn, priceInfoKey(pricePoint.ItemHash), &priceInfo); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			basePrices[itemHashString] = priceInfo.BasePrice
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	market := newMarketGroup("")
+	brands := make(map[string]*marketGroup)
+	categories := make(map[string]*marketGroup)
+	for i := range pricePoints {
+		pricePoint := &pricePoints[i]
+		market.add(pricePoint, period)
+		if brands[pricePoint.Brand] == nil {
+			brands[pricePoint.Brand] = newMarketGroup(pricePoint.Brand)
+		}
+		brands[pricePoint.Brand].add(pricePoint, period)
+		if categories[pricePoint.Category] == nil {
+			categories[pricePoint.Category] = newMarketGroup(pricePoint.Category)
+		}
+		categories[pricePoint.Category].add(pricePoint, period)
+	}
+
+	marketStats := market.finish(basePrices)
+	return &MarketStats{
+		Period:     period,
+		Sales:      marketStats.Sales,
+		Volume:     marketStats.Volume,
+		Periods:    marketStats.Periods,
+		Brands:     sortedGroupStats(brands, basePrices),
+		Categories: sortedGroupStats(categories, basePrices),
+	}, nil
+}
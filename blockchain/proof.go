@@ -1,33 +1,72 @@
 package blockchain
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
+	"math"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-const DIFFICULTY = 3 // make difficulty dynamic later on
-
 func containsLeadingZeroes(hash []byte, difficulty uint64) bool {
 	var hexRepresentation string = hex.EncodeToString(hash[:])
 	var leadingZeroes string = strings.Repeat("0", int(difficulty))
 	return hexRepresentation[0:difficulty] == leadingZeroes
 }
 
-func ProofOfWork(blk *Block) error {
+// nonces tried by a thread between checks of whether mining was cancelled
+const POW_CANCEL_CHECK = 1024
+
+func ProofOfWork(blk *Block, maxIterations uint64) error {
+	return ParallelProofOfWork(context.Background(), blk, maxIterations, 1)
+}
+
+// splits the nonces between the threads, thread t tries t, t + threads, t + 2 * threads, ...
+// the lowest nonce found wins, so the result does not depend on which thread finishes first
+// cancelling the context stops the search, eg. when the node shuts down
+func ParallelProofOfWork(ctx context.Context, blk *Block, maxIterations uint64, threads int) error {
 	HashStrategy := CalculateHashNonEmptyBlock
 	if blk.IsEmpty() {
 		HashStrategy = CalculateHashEmptyBlock
 	}
-	for i := uint64(0); i < MAX_ITERATIONS_POW; i++ { // arbitrary 1000 to prevent potential endless loop
-		hash := HashStrategy(blk, i)
-		if containsLeadingZeroes(hash, blk.Difficulty) {
-			blk.BlockHash = hash
-			blk.Nonce = i
-			return nil
-		}
+	if threads < 1 {
+		threads = 1
+	}
+
+	var found uint64 = math.MaxUint64 // lowest nonce found so far, read by every thread to stop early
+	var wg sync.WaitGroup
+	for t := 0; t < threads; t++ {
+		wg.Add(1)
+		go func(start uint64) {
+			defer wg.Done()
+			for i, tries := start, 0; i < maxIterations && i < atomic.LoadUint64(&found); i, tries = i+uint64(threads), tries+1 { // bounded by the chain params to prevent potential endless loop
+				if tries%POW_CANCEL_CHECK == 0 && ctx.Err() != nil {
+					return
+				}
+				if containsLeadingZeroes(HashStrategy(blk, i), blk.Difficulty) {
+					for {
+						current := atomic.LoadUint64(&found)
+						if i >= current || atomic.CompareAndSwapUint64(&found, current, i) {
+							return
+						}
+					}
+				}
+			}
+		}(uint64(t))
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if found == math.MaxUint64 {
+		return errors.New("proof of work could not be calculated within the given number of iterations")
 	}
-	return errors.New("proof of work could not be calculated within the given number of iterations")
+	blk.BlockHash = HashStrategy(blk, found)
+	blk.Nonce = found
+	return nil
 }
 
 func (blk *Block) VerifyProof() bool {
@@ -0,0 +1,142 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// the main chain locator has this many blocks below the tip before the steps between them start doubling
+const LOCATOR_DENSE_BLOCKS = 10
+
+// hashes of main chain blocks from the tip back to the genesis block, every block near the tip and ever fewer further down
+// a peer on another branch finds the last block both chains share among them, see HashesAfterLocator
+func (blockchain *BlockChain) BlockLocator() [][]byte {
+	var locator [][]byte
+	iter := BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+		txn:         blockchain.pending,
+	}
+	step, nextIndex := 1, 0
+	for index, block := 0, iter.GetBlockAndIter(); block != nil; index, block = index+1, iter.GetBlockAndIter() {
+		// the genesis block is always part of it, every chain of the network shares that one
+		if index != nextIndex && len(block.PreviousHash) != 0 {
+			continue
+		}
+		locator = append(locator, block.BlockHash)
+		if len(locator) >= LOCATOR_DENSE_BLOCKS {
+			step *= 2
+		}
+		nextIndex += step
+	}
+	return locator
+}
+
+// hashes of the main chain after the highest block of the locator that is part of it, oldest first
+// the whole chain if none is
+func (blockchain *BlockChain) HashesAfterLocator(locator [][]byte) [][]byte {
+	locatorHashes := make(map[string]bool)
+	for _, blockHash := range locator {
+		locatorHashes[hex.EncodeToString(blockHash)] = true
+	}
+
+	var hashes [][]byte
+	iter := BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+		txn:         blockchain.pending,
+	}
+	for block := iter.GetBlockAndIter(); block != nil && !locatorHashes[hex.EncodeToString(block.BlockHash)]; block = iter.GetBlockAndIter() {
+		hashes = append(hashes, block.BlockHash)
+	}
+
+	var hashesInOrder [][]byte
+	for i := len(hashes) - 1; i >= 0; i-- {
+		hashesInOrder = append(hashesInOrder, hashes[i])
+	}
+	return hashesInOrder
+}
+
+// makes the branch the main chain, its first block extends a block of the main chain and its last one is higher than the tip
+// the difficulty only depends on the height, so the longer chain is the one with the most work
+// the blocks taken off have their state undone and every block of the branch is checked like a new one,
+// all of it in one database transaction, so a branch with an invalid block or a crash on the way leaves the chain as it was
+// returns the blocks taken off the main chain, oldest first, they are no longer stored
+func (blockchain *BlockChain) Reorganize(branch []*Block) ([]*Block, error) {
+	blockchain.mutex.Lock()
+	defer blockchain.mutex.Unlock()
+
+	if len(branch) == 0 {
+		return nil, errors.New("the branch has no blocks")
+	}
+	engine, err := blockchain.Params.ConsensusEngine()
+	if err != nil {
+		return nil, err
+	}
+	// what can be checked without the state at the fork is checked first, so a branch of bogus blocks costs no more than reading it
+	// the rest of the seal, eg. whether the signer was a validator in turn, is checked as each block is added
+	for i, block := range branch {
+		if !block.VerifyMerkleRoot() {
+			return nil, fmt.Errorf("merkle root of block %x of the branch does not match its transactions", block.BlockHash)
+		}
+		if !block.VerifyBlockHash() {
+			return nil, fmt.Errorf("hash of block %x of the branch does not match", block.BlockHash)
+		}
+		if err := engine.CheckSeal(block, blockchain.Params); err != nil {
+			return nil, fmt.Errorf("block %x of the branch: %v", block.BlockHash, err)
+		}
+		if i != 0 && (!bytes.Equal(block.PreviousHash, branch[i-1].BlockHash) || block.Height != branch[i-1].Height+1) {
+			return nil, fmt.Errorf("block %x of the branch does not extend the one before it", block.BlockHash)
+		}
+	}
+	branchTip := branch[len(branch)-1]
+	if branchTip.Height <= blockchain.GetHeight() {
+		return nil, fmt.Errorf("the branch up to height %d is not longer than the main chain", branchTip.Height)
+	}
+
+	forkHash := branch[0].PreviousHash
+	var disconnected []*Block
+	iter := BlockChainIterator{
+		CurrentHash: blockchain.LastHash,
+		Database:    blockchain.Database,
+	}
+	block := iter.GetBlockAndIter()
+	for ; block != nil && !bytes.Equal(block.BlockHash, forkHash); block = iter.GetBlockAndIter() {
+		disconnected = append([]*Block{block}, disconnected...)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("the branch does not fork off the main chain at %x", forkHash)
+	}
+	// the height of the branch is what makes it win, so it has to count the blocks since the fork
+	if branch[0].Height != block.Height+1 {
+		return nil, fmt.Errorf("block %x at height %d does not follow the height %d of the fork", branch[0].BlockHash, branch[0].Height, block.Height)
+	}
+
+	// the branch is checked and written on a copy of the chain that reads through the transaction, nothing is seen before the commit
+	txn := blockchain.Database.NewTransaction(true)
+	defer txn.Discard()
+	staged := &BlockChain{Database: blockchain.Database, LastHash: blockchain.LastHash, Params: blockchain.Params, pending: txn}
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := disconnectBlock(txn, disconnected[i]); err != nil {
+			return nil, err
+		}
+		staged.LastHash = disconnected[i].PreviousHash
+	}
+	for _, block := range branch {
+		if err := staged.checkBlock(block); err != nil {
+			return nil, fmt.Errorf("block %x of the branch: %v", block.BlockHash, err)
+		}
+		if err := staged.writeBlock(txn, block); err != nil {
+			return nil, fmt.Errorf("block %x of the branch: %v", block.BlockHash, err)
+		}
+		staged.LastHash = block.BlockHash
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+
+	blockchain.LastHash = staged.LastHash
+	return disconnected, nil
+}
@@ -0,0 +1,198 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// mines the transactions on top of the tip of the chain and adds the block
+func testMineBlock(t *testing.T, chain *BlockChain, miner *wallet.Wallet, txs ...Tx) *Block {
+	t.Helper()
+	blk := CreateBlock()
+	if len(txs) != 0 {
+		if err := blk.AddTransactionsToBlock(txs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := blk.MineBlock(chain, miner); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	return blk
+}
+
+// block on top of the parent that no chain checked, sealed with the given difficulty
+func testBlockOn(t *testing.T, parent *Block, miner []byte, difficulty uint64, txs ...Tx) *Block {
+	t.Helper()
+	blk := &Block{
+		Height:       parent.Height + 1,
+		Timestamp:    parent.Timestamp + 1,
+		Difficulty:   difficulty,
+		PreviousHash: parent.BlockHash,
+		Miner:        miner,
+	}
+	if len(txs) != 0 {
+		if err := blk.AddTransactionsToBlock(txs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ProofOfWork(blk, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	return blk
+}
+
+// tip as stored, which Reorganize has to keep in line with the LastHash of the chain
+func testStoredTip(t *testing.T, chain *BlockChain) []byte {
+	t.Helper()
+	var lastHash []byte
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(LAST_HASH))
+		if err != nil {
+			return err
+		}
+		lastHash, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lastHash
+}
+
+func TestReorganize(t *testing.T) {
+	alice, bob, minerA, minerB := testWallet(t), testWallet(t), testWallet(t), testWallet(t)
+	aliceHash, bobHash := testPubKeyHash(t, alice), testPubKeyHash(t, bob)
+	wallets := [][]byte{aliceHash, bobHash, testPubKeyHash(t, minerA), testPubKeyHash(t, minerB)}
+
+	// both chains share the first block, then the main chain has one block and the other chain two
+	// the block of the main chain spends the credit of alice on an introduction, the other chain moves it to bob
+	tests := []struct {
+		name     string
+		branch   func(t *testing.T, other []*Block) []*Block
+		wantErr  bool
+		switched bool
+	}{
+		{
+			name:     "longer branch is taken",
+			branch:   func(t *testing.T, other []*Block) []*Block { return other },
+			switched: true,
+		},
+		{
+			name:    "branch as high as the main chain",
+			branch:  func(t *testing.T, other []*Block) []*Block { return other[:1] },
+			wantErr: true,
+		},
+		{
+			name: "block with a difficulty the network does not ask for",
+			branch: func(t *testing.T, other []*Block) []*Block {
+				return []*Block{other[0], testBlockOn(t, other[0], other[1].Miner, 1)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "block spending credits no one has",
+			branch: func(t *testing.T, other []*Block) []*Block {
+				overspend := testCreditTransfer(t, bob, aliceHash, 5)
+				return []*Block{other[0], testBlockOn(t, other[0], other[1].Miner, 0, overspend)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid block after a valid one",
+			branch: func(t *testing.T, other []*Block) []*Block {
+				overspend := testCreditTransfer(t, bob, aliceHash, 5)
+				return append(other, testBlockOn(t, other[1], other[1].Miner, 0, overspend))
+			},
+			wantErr: true,
+		},
+		{
+			name: "branch off a block the chain does not have",
+			branch: func(t *testing.T, other []*Block) []*Block {
+				unknown := testBlockOn(t, other[0], other[1].Miner, 0)
+				first := testBlockOn(t, unknown, other[1].Miner, 0)
+				return []*Block{first, testBlockOn(t, first, other[1].Miner, 0)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "block taken out of the branch",
+			branch: func(t *testing.T, other []*Block) []*Block {
+				return []*Block{other[0], testBlockOn(t, other[1], other[1].Miner, 0)}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain, otherChain := testChain(t, "regtest"), testChain(t, "regtest")
+			common := testMineBlock(t, chain, alice)
+			if err := otherChain.AddBlock(common); err != nil {
+				t.Fatal(err)
+			}
+			mainBlock := testMineBlock(t, chain, minerA, testCoinbase(t, alice, "item"))
+			other := []*Block{
+				testMineBlock(t, otherChain, minerB),
+				testMineBlock(t, otherChain, minerB, testCreditTransfer(t, alice, bobHash, 1)),
+			}
+
+			balances := func(chain *BlockChain) []int64 {
+				var got []int64
+				for _, pubKeyHash := range wallets {
+					balance, err := chain.CreditBalance(pubKeyHash)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, balance)
+				}
+				return got
+			}
+			before := balances(chain)
+			tipBefore := chain.LastHash
+
+			disconnected, err := chain.Reorganize(test.branch(t, other))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Reorganize() error = %v, wantErr %v", err, test.wantErr)
+			}
+
+			wantTip, wantBalances := tipBefore, before
+			if test.switched {
+				wantTip, wantBalances = otherChain.LastHash, balances(otherChain)
+				if len(disconnected) != 1 || !bytes.Equal(disconnected[0].BlockHash, mainBlock.BlockHash) {
+					t.Errorf("Reorganize() took off %d blocks, want the block of the main chain", len(disconnected))
+				}
+			}
+			if !bytes.Equal(chain.LastHash, wantTip) || !bytes.Equal(testStoredTip(t, chain), wantTip) {
+				t.Errorf("tip = %x, stored %x, want %x", chain.LastHash, testStoredTip(t, chain), wantTip)
+			}
+			for i, balance := range balances(chain) {
+				if balance != wantBalances[i] {
+					t.Errorf("balance of wallet %d = %d, want %d", i, balance, wantBalances[i])
+				}
+			}
+			if chain.HasBlock(mainBlock.BlockHash) == test.switched {
+				t.Errorf("HasBlock() of the block of the main chain = %v, want %v", test.switched, !test.switched)
+			}
+			for _, block := range other {
+				if chain.HasBlock(block.BlockHash) != test.switched {
+					t.Errorf("HasBlock() of block %x of the branch = %v, want %v", block.BlockHash, !test.switched, test.switched)
+				}
+			}
+
+			// the item introduced by the block taken off is gone with it
+			itemOnChain, err := chain.FindItemExists([]byte("item"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if itemOnChain == test.switched {
+				t.Errorf("item introduced on the main chain exists = %v, want %v", itemOnChain, !test.switched)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// single item moving inside a swap, every leg is a transfer of its own that only happens together with the others
+type TxLeg struct {
+	ItemHash   utility.HexByte `json:"itemHash"`
+	UTXOID     utility.HexByte `json:"UTXOID"`
+	SellerHash utility.HexByte `json:"sellerHash"`
+	BuyerHash  utility.HexByte `json:"buyerHash"`
+	Amount     uint64          `json:"amount"`
+}
+
+// signature of one of the sellers of a swap over the transaction id
+type TxSigner struct {
+	PublicKey utility.HexByte `json:"publicKey"`
+	Signature utility.HexByte `json:"signature"`
+}
+
+// seller and buyer of the item in this transaction, swaps keep them per leg
+func (tx *Tx) PartiesFor(itemHash []byte) ([]byte, []byte) {
+	for _, leg := range tx.Legs {
+		if bytes.Equal(leg.ItemHash, itemHash) {
+			return leg.SellerHash, leg.BuyerHash
+		}
+	}
+	return tx.SellerHash, tx.BuyerHash
+}
+
+// distinct sellers of a swap in leg order, each of them has to sign
+func (tx *Tx) SwapSellers() [][]byte {
+	var sellers [][]byte
+	seen := make(map[string]bool)
+	for _, leg := range tx.Legs {
+		sellerString := hex.EncodeToString(leg.SellerHash)
+		if !seen[sellerString] {
+			seen[sellerString] = true
+			sellers = append(sellers, leg.SellerHash)
+		}
+	}
+	return sellers
+}
+
+func (tx *Tx) signerFor(sellerHash []byte) *TxSigner {
+	for i := range tx.Signers {
+		signerHash, err := pubKeyHashOfBytes(tx.Signers[i].PublicKey)
+		if err == nil && bytes.Equal(signerHash, sellerHash) {
+			return &tx.Signers[i]
+		}
+	}
+	return nil
+}
+
+func pubKeyHashOfBytes(pubKeyBytes []byte) ([]byte, error) {
+	pubKey, err := wallet.BytesToPublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.PubKeyHashFromPublicKey(pubKey)
+}
+
+// sellers that have not signed the swap yet
+func (tx *Tx) MissingSigners() [][]byte {
+	var missing [][]byte
+	for _, seller := range tx.SwapSellers() {
+		if tx.signerFor(seller) == nil {
+			missing = append(missing, seller)
+		}
+	}
+	return missing
+}
+
+// every signature present must be valid and belong to a seller, missing ones only keep the swap pending
+func (tx *Tx) verifySwapSignatures() error {
+	sellers := make(map[string]bool)
+	for _, seller := range tx.SwapSellers() {
+		sellers[hex.EncodeToString(seller)] = true
+	}
+	signed := make(map[string]bool)
+	for _, signer := range tx.Signers {
+		pubKey, err := wallet.BytesToPublicKey(signer.PublicKey)
+		if err != nil {
+			return err
+		}
+		signerHash, err := wallet.PubKeyHashFromPublicKey(pubKey)
+		if err != nil {
+			return err
+		}
+		signerString := hex.EncodeToString(signerHash)
+		if !sellers[signerString] {
+			return fmt.Errorf("signer %s is not a seller in the swap", signerString)
+		}
+		if signed[signerString] {
+			return fmt.Errorf("seller %s signed the swap more than once", signerString)
+		}
+		signed[signerString] = true
+		if err := rsa.VerifyPSS(pubKey, crypto.SHA256, tx.TxID, signer.Signature, nil); err != nil {
+			return fmt.Errorf("signature of seller %s: %v", signerString, err)
+		}
+	}
+	return nil
+}
+
+// adds the wallet's signature to a swap it sells an item in, the swap is complete once every seller signed
+func (tx *Tx) SignSwap(wlt *wallet.Wallet) error {
+	if tx.Type != TX_SWAP {
+		return errors.New("transaction is not a swap")
+	}
+	walletPubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+	if err != nil {
+		return err
+	}
+	isSeller := false
+	for _, seller := range tx.SwapSellers() {
+		isSeller = isSeller || bytes.Equal(seller, walletPubKeyHash)
+	}
+	if !isSeller {
+		return errors.New("the wallet does not sell any item in this swap")
+	}
+	if tx.signerFor(walletPubKeyHash) != nil {
+		return errors.New("the wallet has already signed this swap")
+	}
+
+	// never sign legs that do not match the id the other parties signed
+	txHash, err := tx.CalculateTxHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(txHash, tx.TxID) {
+		return errors.New("transaction hash does not match transaction content")
+	}
+
+	publicKey, err := wallet.PublicKeyToBytes(&wlt.PublicKey)
+	if err != nil {
+		return err
+	}
+	signature, err := sign(&wlt.PrivateKey, tx.TxID)
+	if err != nil {
+		return err
+	}
+	tx.Signers = append(tx.Signers, TxSigner{PublicKey: publicKey, Signature: signature})
+	return nil
+}
+
+// copies signatures of sellers that only signed the other copy of the same swap, returns how many were added
+func (tx *Tx) MergeSigners(other *Tx) int {
+	if !bytes.Equal(tx.TxID, other.TxID) {
+		return 0
+	}
+	merged := 0
+	for _, seller := range other.SwapSellers() {
+		if tx.signerFor(seller) != nil {
+			continue
+		}
+		if signer := other.signerFor(seller); signer != nil {
+			tx.Signers = append(tx.Signers, *signer)
+			merged++
+		}
+	}
+	return merged
+}
+
+// builds an unsigned swap, legs only need the item, buyer and amount; sellers and inputs are taken from the chain
+func NewUnsignedSwapTransaction(legs []TxLeg, chain *BlockChain) (*Tx, error) {
+	swapTx := Tx{
+		Timestamp: uint64(time.Now().Unix()),
+		Type:      TX_SWAP,
+	}
+	for _, leg := range legs {
+		itemState, err := chain.ItemState(leg.ItemHash)
+		if err != nil {
+			return nil, err
+		}
+		leg.SellerHash = itemState.Owner
+		leg.UTXOID = itemState.LastTxID
+		swapTx.Legs = append(swapTx.Legs, leg)
+	}
+	if rejection := checkSwap(&swapTx, chain); rejection != nil {
+		return nil, rejection
+	}
+
+	txID, err := swapTx.CalculateTxHash()
+	if err != nil {
+		return nil, err
+	}
+	swapTx.TxID = txID
+	return &swapTx, nil
+}
+
+// called by ValidateTransaction once hash and signatures are checked
+func validateSwap(tx *Tx, chain *BlockChain) *TxRejection {
+	if len(tx.ItemHash) != 0 || len(tx.SellerHash) != 0 || len(tx.BuyerHash) != 0 || len(tx.UTXOID) != 0 {
+		return Reject(REJECT_MALFORMED, "swaps keep items and parties in their legs only")
+	}
+	if tx.RequiresAcceptance || len(tx.Parts) != 0 || len(tx.Signature) != 0 {
+		return Reject(REJECT_MALFORMED, "swaps are signed through their signer list only")
+	}
+	return checkSwap(tx, chain)
+}
+
+// every leg must be a valid transfer on its own, and no item may move twice
+func checkSwap(tx *Tx, chain *BlockChain) *TxRejection {
+	if len(tx.Legs) < 2 {
+		return Reject(REJECT_MALFORMED, "a swap needs at least two legs, use a transfer for a single item")
+	}
+
+	seenItems := make(map[string]bool)
+	for _, leg := range tx.Legs {
+		itemHashString := hex.EncodeToString(leg.ItemHash)
+		if len(leg.ItemHash) == 0 || len(leg.SellerHash) == 0 || len(leg.BuyerHash) == 0 {
+			return Reject(REJECT_MALFORMED, "every leg needs an item, a seller and a buyer")
+		}
+		if seenItems[itemHashString] {
+			return Reject(REJECT_MALFORMED, fmt.Sprintf("item %s appears in more than one leg", itemHashString))
+		}
+		seenItems[itemHashString] = true
+		if bytes.Equal(leg.SellerHash, leg.BuyerHash) {
+			return Reject(REJECT_MALFORMED, fmt.Sprintf("item %s is sold to its own owner", itemHashString))
+		}
+
+		itemState, err := chain.ItemState(leg.ItemHash)
+		if err != nil {
+			return Reject(REJECT_UNKNOWN_ITEM, err.Error())
+		}
+		if len(tx.TxID) != 0 && bytes.Equal(itemState.LastTxID, tx.TxID) {
+			return Reject(REJECT_ALREADY_MINED, "transaction is already part of the chain")
+		}
+		if !bytes.Equal(itemState.Owner, leg.SellerHash) {
+			return Reject(REJECT_NOT_OWNER, fmt.Sprintf("item %s does not belong to the seller of its leg", itemHashString))
+		}
+		if rejection := itemState.transferRejection(); rejection != nil {
+			return rejection
+		}
+		if !bytes.Equal(itemState.LastTxID, leg.UTXOID) {
+			return Reject(REJECT_STALE_INPUT, fmt.Sprintf("leg of item %s does not spend its latest transaction", itemHashString))
+		}
+	}
+	return nil
+}
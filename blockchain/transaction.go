@@ -12,11 +12,56 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pranjalpokharel7/yudhishthira/object"
 	"github.com/pranjalpokharel7/yudhishthira/utility"
 	"github.com/pranjalpokharel7/yudhishthira/wallet"
 )
 
+// transaction kinds, the zero value keeps coinbases and transfers made before kinds existed valid
+const (
+	TX_TRANSFER         = 0  // coinbase or change of ownership
+	TX_RETIRE           = 1  // owner retires (burns) the item, it can never be transacted again
+	TX_RECALL           = 2  // introducer flags the item as recalled, every later owner sees the flag
+	TX_REPORT_LOST      = 3  // owner reports the item lost or stolen, transfers are blocked until cleared
+	TX_CLEAR_REPORT     = 4  // owner clears an earlier lost or stolen report
+	TX_ASSEMBLE         = 5  // owner consumes the parts and produces the composite item (see composite.go)
+	TX_DISASSEMBLE      = 6  // owner consumes the composite item and releases the parts
+	TX_SWAP             = 7  // moves several items between several parties at once, signed by every seller (see swap.go)
+	TX_ESCROW           = 8  // seller hands the item to an arbiter for the buyer, ownership stays with the seller until settled (see escrow.go)
+	TX_ESCROW_RELEASE   = 9  // arbiter settles an escrow in favour of the buyer
+	TX_ESCROW_REFUND    = 10 // arbiter settles an escrow by returning the item to the seller
+	TX_CREDIT_TRANSFER  = 11 // sender moves introduction credits to the buyer, no item is involved (see credits.go)
+	TX_ADD_VALIDATOR    = 12 // validator in the seller field votes for adding the buyer to the validators (see authority.go)
+	TX_REMOVE_VALIDATOR = 13 // validator in the seller field votes for removing the buyer from the validators
+)
+
+var txTypeNames = map[uint8]string{
+	TX_TRANSFER:         "transfer",
+	TX_RETIRE:           "retire",
+	TX_RECALL:           "recall",
+	TX_REPORT_LOST:      "report-lost",
+	TX_CLEAR_REPORT:     "clear-report",
+	TX_ASSEMBLE:         "assemble",
+	TX_DISASSEMBLE:      "disassemble",
+	TX_SWAP:             "swap",
+	TX_ESCROW:           "escrow",
+	TX_ESCROW_RELEASE:   "escrow-release",
+	TX_ESCROW_REFUND:    "escrow-refund",
+	TX_CREDIT_TRANSFER:  "credit-transfer",
+	TX_ADD_VALIDATOR:    "add-validator",
+	TX_REMOVE_VALIDATOR: "remove-validator",
+}
+
+func TxTypeName(txType uint8) string {
+	if name, ok := txTypeNames[txType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", txType)
+}
+
 // TODO: timestamp of item -> when coinbase? necessary?
+// lifecycle transactions (retire, recall, reports) keep the buyer hash set to the current owner,
+// so the buyer of the latest transaction with an item is always its owner
 type Tx struct {
 	TxID       utility.HexByte `json:"txID"`       // hash of this transaction
 	UTXOID     utility.HexByte `json:"UTXOID"`     // reference to the hash last transaction the item was a part of
@@ -26,6 +71,21 @@ type Tx struct {
 	BuyerHash  utility.HexByte `json:"buyerHash"`  // pubkey hash of the buyer
 	Amount     uint64          `json:"amount"`     // amount invloved in transaction
 	Timestamp  uint64          `json:"timestamp"`
+
+	PublicKey          utility.HexByte `json:"publicKey"`          // public key of the signer (seller, or introducer for coinbase)
+	RequiresAcceptance bool            `json:"requiresAcceptance"` // if set, transfer stays pending until the buyer countersigns
+	BuyerPublicKey     utility.HexByte `json:"buyerPublicKey"`     // public key of the buyer, set on acceptance
+	BuyerSignature     utility.HexByte `json:"buyerSignature"`     // countersignature of the buyer accepting the transfer
+	MetadataHash       utility.HexByte `json:"metadataHash"`       // coinbase only, hash of the descriptive metadata blob of the item
+	ItemHashVersion    uint8           `json:"itemHashVersion"`    // coinbase only, object hash scheme the item hash was computed with (see object.HASH_VERSION_*)
+	Type               uint8           `json:"type"`               // one of the TX_* kinds
+	Note               string          `json:"note"`               // free text reason, eg. for recalls and lost reports
+	Parts              []TxPart        `json:"parts"`              // assembly and disassembly only, the items consumed or released along with the composite item
+	Legs               []TxLeg         `json:"legs"`               // swap only, item, seller and buyer fields above stay empty
+	Signers            []TxSigner      `json:"signers"`            // swap only, one signature per distinct seller
+	LockHeight         uint64          `json:"lockHeight"`         // if set, the transaction can only be mined in a block of at least this height
+	LockTime           uint64          `json:"lockTime"`           // if set, the transaction can only be mined in a block with at least this unix timestamp
+	ArbiterHash        utility.HexByte `json:"arbiterHash"`        // escrow only, pubkey hash of the arbiter who releases or refunds the item
 }
 
 func (tx Tx) SerializeTxToGOB() ([]byte, error) {
@@ -43,6 +103,7 @@ func DeserializeTxFromGOB(serializedTx []byte) (*Tx, error) {
 func (tx Tx) String() string {
 	var lines []string
 	lines = append(lines, fmt.Sprintf("------------ Transaction: %x ------------", tx.TxID))
+	lines = append(lines, fmt.Sprintf("Type: %s", TxTypeName(tx.Type)))
 	lines = append(lines, fmt.Sprintf("UTXOID: %x", tx.UTXOID))
 	lines = append(lines, fmt.Sprintf("Signature: %x", tx.Signature))
 	lines = append(lines, fmt.Sprintf("Item Hash: %x", tx.ItemHash))
@@ -50,37 +111,48 @@ func (tx Tx) String() string {
 	lines = append(lines, fmt.Sprintf("Buyer Hash: %x", tx.BuyerHash))
 	lines = append(lines, fmt.Sprintf("Amount: %d", tx.Amount))
 	lines = append(lines, fmt.Sprintf("Timestamp: %d", tx.Timestamp))
+	if tx.Note != "" {
+		lines = append(lines, fmt.Sprintf("Note: %s", tx.Note))
+	}
+	if tx.LockHeight != 0 || tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("Locked Until: height %d, time %d", tx.LockHeight, tx.LockTime))
+	}
+	if len(tx.ArbiterHash) != 0 {
+		lines = append(lines, fmt.Sprintf("Arbiter Hash: %x", tx.ArbiterHash))
+	}
+	for _, part := range tx.Parts {
+		lines = append(lines, fmt.Sprintf("Part: %x (UTXOID: %x)", part.ItemHash, part.UTXOID))
+	}
+	for _, leg := range tx.Legs {
+		lines = append(lines, fmt.Sprintf("Leg: %x from %x to %x for %d (UTXOID: %x)", leg.ItemHash, leg.SellerHash, leg.BuyerHash, leg.Amount, leg.UTXOID))
+	}
+	if tx.Type == TX_SWAP {
+		lines = append(lines, fmt.Sprintf("Signatures: %d of %d sellers", len(tx.Signers), len(tx.SwapSellers())))
+	}
+	if tx.RequiresAcceptance {
+		lines = append(lines, fmt.Sprintf("Buyer Signature: %x", tx.BuyerSignature))
+	}
+	if len(tx.MetadataHash) != 0 {
+		lines = append(lines, fmt.Sprintf("Metadata Hash: %x", tx.MetadataHash))
+		lines = append(lines, fmt.Sprintf("Item Hash Version: %d", tx.ItemHashVersion))
+	}
 	return strings.Join(lines, "\n")
 }
 
-func (tx *Tx) deepCopy() Tx {
-	var txCopy Tx
-	txCopy.Amount = tx.Amount
-	txCopy.Timestamp = tx.Timestamp
-	copy(txCopy.BuyerHash, tx.BuyerHash)
-	copy(txCopy.SellerHash, tx.SellerHash)
-	copy(txCopy.ItemHash, tx.ItemHash)
-	copy(txCopy.UTXOID, tx.UTXOID)
-	copy(txCopy.Signature, tx.Signature)
-	copy(txCopy.TxID, tx.TxID)
-	return txCopy
-}
-
-// can be used to verify hash as well, so we need to make a copy beforehand
+// can be used to verify hash as well, the hash is over the canonical encoding (see txEncoding.go) and not gob,
+// whose encoding depends on the types the process met before
 func (tx *Tx) CalculateTxHash() ([]byte, error) {
-	var hash [32]byte
-	txCopy := tx.deepCopy()
-	txCopy.TxID = []byte{}
-	txCopy.Signature = []byte{} // since tx is only signed after hash is calculated, do not factor this into hash calculation
-	txCopySerialized, err := txCopy.SerializeTxToGOB()
-	if err != nil {
-		return nil, err
-	}
-	hash = sha256.Sum256(txCopySerialized)
+	hash := sha256.Sum256(tx.canonicalEncoding())
 	return hash[:], nil
 }
 
 func CoinBaseTransaction(srcWallet *wallet.Wallet, itemHash []byte, amount uint64, chain *BlockChain) (*Tx, error) {
+	return CoinBaseTransactionWithMetadata(srcWallet, itemHash, nil, object.HASH_VERSION_LEGACY, amount, chain)
+}
+
+// coinbase that also commits to the hash of the item's metadata blob (see StoreMetadata)
+// and to the object hash scheme the item hash was derived with
+func CoinBaseTransactionWithMetadata(srcWallet *wallet.Wallet, itemHash []byte, metadataHash []byte, hashVersion uint8, amount uint64, chain *BlockChain) (*Tx, error) {
 	// check if the item already exists in the chain before, if yes, can't enter existing item as new item
 	itemExists, err := chain.FindItemExists(itemHash)
 	if err != nil {
@@ -90,30 +162,35 @@ func CoinBaseTransaction(srcWallet *wallet.Wallet, itemHash []byte, amount uint6
 		return nil, errors.New("item already exists in the chain")
 	}
 
-	// check if the address is valid
-	walletAddress := string(srcWallet.Address)
-	pubKeyHash, err := wallet.PubKeyHashFromAddress(walletAddress)
+	// check if the address has sufficient funds for coinbase transactions
+	hasFunds, err := HasFundsForCoinbaseTx(string(srcWallet.Address), chain)
 	if err != nil {
 		return nil, err
 	}
-
-	// check if the address has sufficient funds for coinbase transactions
-	hasFunds, err := HasFundsForCoinbaseTx(walletAddress, chain)
 	if !hasFunds {
-		return nil, errors.New("the address owner does not have sufficient funds for introducing items into the chain")
+		return nil, errors.New("the address owner does not have sufficient credits for introducing items into the chain")
 	}
+	return NewCoinbaseTx(srcWallet, itemHash, metadataHash, hashVersion, amount)
+}
+
+// builds and signs a coinbase without looking at the chain, the caller has checked that the item is new and the wallet has the credits
+// eg. imports check every row against one set of the chain items (see AllItemHashes)
+func NewCoinbaseTx(srcWallet *wallet.Wallet, itemHash []byte, metadataHash []byte, hashVersion uint8, amount uint64) (*Tx, error) {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(string(srcWallet.Address))
 	if err != nil {
 		return nil, err
 	}
 
 	// coinbase transactions have seller hash nil, previous linked output nil
 	coinBaseTx := Tx{
-		ItemHash:   itemHash,
-		BuyerHash:  pubKeyHash,
-		Amount:     amount,
-		SellerHash: nil,
-		UTXOID:     nil,
-		Timestamp:  uint64(time.Now().Unix()),
+		ItemHash:        itemHash,
+		BuyerHash:       pubKeyHash,
+		Amount:          amount,
+		SellerHash:      nil,
+		UTXOID:          nil,
+		Timestamp:       uint64(time.Now().Unix()),
+		MetadataHash:    metadataHash,
+		ItemHashVersion: hashVersion,
 	}
 
 	// calculate transaciton hash
@@ -129,8 +206,10 @@ func CoinBaseTransaction(srcWallet *wallet.Wallet, itemHash []byte, amount uint6
 	return &coinBaseTx, nil
 }
 
+// hex decoded JSON gives empty rather than nil slices, so compare lengths
+// swaps keep their sellers and inputs in the legs, so only plain transfers can be coinbases
 func (tx *Tx) IsCoinbase() bool {
-	return tx.SellerHash == nil && tx.UTXOID == nil
+	return tx.Type == TX_TRANSFER && len(tx.SellerHash) == 0 && len(tx.UTXOID) == 0
 }
 
 func LastTxWithItem(chain *BlockChain, itemHash []byte) (*Tx, error) {
@@ -143,19 +222,45 @@ func LastTxWithItem(chain *BlockChain, itemHash []byte) (*Tx, error) {
 }
 
 func NewTransaction(srcWallet *wallet.Wallet, destinationAddr string, itemHash []byte, amount uint64, chain *BlockChain) (*Tx, error) {
-	// check if the last transaction destination address is the current source address
-	lastTxWithItem, err := LastTxWithItem(chain, itemHash)
+	return newTransfer(srcWallet, destinationAddr, itemHash, amount, false, chain)
+}
+
+// creates a transfer that only becomes valid once the buyer countersigns it with AcceptTransaction
+func NewTransferOffer(srcWallet *wallet.Wallet, destinationAddr string, itemHash []byte, amount uint64, chain *BlockChain) (*Tx, error) {
+	return newTransfer(srcWallet, destinationAddr, itemHash, amount, true, chain)
+}
+
+func newTransfer(srcWallet *wallet.Wallet, destinationAddr string, itemHash []byte, amount uint64, requiresAcceptance bool, chain *BlockChain) (*Tx, error) {
+	newTx, err := NewUnsignedTransaction(string(srcWallet.Address), destinationAddr, itemHash, amount, requiresAcceptance, chain)
+	if err != nil {
+		return nil, err
+	}
+	err = newTx.SignTransaction(srcWallet)
 	if err != nil {
 		return nil, err
 	}
 
-	sellerPubKeyHash, err := wallet.PubKeyHashFromAddress(string(srcWallet.Address))
+	return newTx, nil
+}
+
+// builds a transfer with its id calculated but without any signature, needs only the seller's address
+func NewUnsignedTransaction(sourceAddr string, destinationAddr string, itemHash []byte, amount uint64, requiresAcceptance bool, chain *BlockChain) (*Tx, error) {
+	// check if the current owner of the item is the source address and that it may be transferred
+	itemState, err := chain.ItemState(itemHash)
 	if err != nil {
 		return nil, err
 	}
-	if !bytes.Equal(lastTxWithItem.BuyerHash, sellerPubKeyHash) {
+
+	sellerPubKeyHash, err := wallet.PubKeyHashFromAddress(sourceAddr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(itemState.Owner, sellerPubKeyHash) {
 		return nil, errors.New("the item does not belong to the source address to sell")
 	}
+	if err := itemState.CheckTransferable(); err != nil {
+		return nil, err
+	}
 
 	// create new transaction
 	buyerPubKeyHash, err := wallet.PubKeyHashFromAddress(destinationAddr)
@@ -163,12 +268,13 @@ func NewTransaction(srcWallet *wallet.Wallet, destinationAddr string, itemHash [
 		return nil, err
 	}
 	newTx := Tx{
-		ItemHash:   itemHash,
-		SellerHash: sellerPubKeyHash,
-		BuyerHash:  buyerPubKeyHash,
-		Amount:     amount,
-		UTXOID:     lastTxWithItem.TxID,
-		Timestamp:  uint64(time.Now().Unix()),
+		ItemHash:           itemHash,
+		SellerHash:         sellerPubKeyHash,
+		BuyerHash:          buyerPubKeyHash,
+		Amount:             amount,
+		UTXOID:             itemState.LastTxID,
+		Timestamp:          uint64(time.Now().Unix()),
+		RequiresAcceptance: requiresAcceptance,
 	}
 
 	// calculate transaction hash
@@ -177,7 +283,6 @@ func NewTransaction(srcWallet *wallet.Wallet, destinationAddr string, itemHash [
 		return nil, err
 	}
 	newTx.TxID = txID
-	newTx.SignTransaction(srcWallet)
 
 	return &newTx, nil
 }
@@ -188,7 +293,15 @@ func sign(privKey *rsa.PrivateKey, txHash []byte) ([]byte, error) {
 }
 
 func (tx *Tx) SignTransaction(wlt *wallet.Wallet) error {
+	if tx.Type == TX_SWAP {
+		return tx.SignSwap(wlt)
+	}
 	sellerPrivKey := wlt.PrivateKey
+	publicKey, err := wallet.PublicKeyToBytes(&wlt.PublicKey)
+	if err != nil {
+		return err
+	}
+	tx.PublicKey = publicKey
 
 	if tx.IsCoinbase() {
 		signature, err := sign(&sellerPrivKey, tx.TxID)
@@ -211,3 +324,147 @@ func (tx *Tx) SignTransaction(wlt *wallet.Wallet) error {
 func VerifySignature(tx *Tx, sellerPubKey *rsa.PublicKey) error {
 	return rsa.VerifyPSS(sellerPubKey, crypto.SHA256, tx.TxID, tx.Signature, nil)
 }
+
+func VerifyBuyerSignature(tx *Tx, buyerPubKey *rsa.PublicKey) error {
+	return rsa.VerifyPSS(buyerPubKey, crypto.SHA256, tx.TxID, tx.BuyerSignature, nil)
+}
+
+// parses the embedded public key and checks that it belongs to the expected pubkey hash
+func publicKeyMatchingHash(pubKeyBytes []byte, pubKeyHash []byte) (*rsa.PublicKey, error) {
+	if len(pubKeyBytes) == 0 {
+		return nil, errors.New("transaction does not carry the signer's public key")
+	}
+	pubKey, err := wallet.BytesToPublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	derivedHash, err := wallet.PubKeyHashFromPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(derivedHash, pubKeyHash) {
+		return nil, errors.New("public key does not match the pubkey hash in the transaction")
+	}
+	return pubKey, nil
+}
+
+// pubkey hash of whoever has to sign the transaction, the seller unless stated otherwise
+func (tx *Tx) SignerHash() []byte {
+	if tx.IsCoinbase() {
+		return tx.BuyerHash // coinbase is signed by the introducer
+	}
+	if tx.Type == TX_ESCROW_RELEASE || tx.Type == TX_ESCROW_REFUND {
+		return tx.ArbiterHash
+	}
+	return tx.SellerHash
+}
+
+// verifies the signer's signature and, for transfers requiring acceptance, the buyer's countersignature
+func (tx *Tx) VerifySignatures() error {
+	if tx.Type == TX_SWAP {
+		return tx.verifySwapSignatures()
+	}
+	signerPubKey, err := publicKeyMatchingHash(tx.PublicKey, tx.SignerHash())
+	if err != nil {
+		return err
+	}
+	if err := VerifySignature(tx, signerPubKey); err != nil {
+		return err
+	}
+
+	if !tx.RequiresAcceptance || tx.IsPending() {
+		return nil
+	}
+	buyerPubKey, err := publicKeyMatchingHash(tx.BuyerPublicKey, tx.BuyerHash)
+	if err != nil {
+		return err
+	}
+	return VerifyBuyerSignature(tx, buyerPubKey)
+}
+
+// a transfer offer the buyer has neither accepted nor declined yet, or a swap some seller has not signed, can not be mined
+func (tx *Tx) IsPending() bool {
+	if tx.Type == TX_SWAP {
+		return len(tx.MissingSigners()) != 0
+	}
+	return tx.RequiresAcceptance && len(tx.BuyerSignature) == 0
+}
+
+// countersigns a pending transfer offer with the buyer's wallet
+func (tx *Tx) AcceptTransaction(wlt *wallet.Wallet) error {
+	if !tx.RequiresAcceptance {
+		return errors.New("transaction does not require buyer acceptance")
+	}
+	if !tx.IsPending() {
+		return errors.New("transaction has already been accepted")
+	}
+
+	buyerPubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buyerPubKeyHash, tx.BuyerHash) {
+		return errors.New("only the buyer of the transfer can accept it")
+	}
+
+	// never countersign something the seller did not sign, or whose content was altered
+	txHash, err := tx.CalculateTxHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(txHash, tx.TxID) {
+		return errors.New("transaction hash does not match transaction content")
+	}
+	if err := tx.VerifySignatures(); err != nil {
+		return err
+	}
+
+	buyerPublicKey, err := wallet.PublicKeyToBytes(&wlt.PublicKey)
+	if err != nil {
+		return err
+	}
+	signature, err := sign(&wlt.PrivateKey, tx.TxID)
+	if err != nil {
+		return err
+	}
+	tx.BuyerPublicKey = buyerPublicKey
+	tx.BuyerSignature = signature
+	return nil
+}
+
+// declines are signed over a separate digest so that they can never be replayed as an acceptance
+func declineDigest(txID []byte) []byte {
+	digest := sha256.Sum256(append([]byte("decline:"), txID...))
+	return digest[:]
+}
+
+// signs the buyer's refusal of a pending transfer offer, returns the buyer public key and signature
+func DeclineTransaction(tx *Tx, wlt *wallet.Wallet) ([]byte, []byte, error) {
+	if !tx.IsPending() {
+		return nil, nil, errors.New("only pending transfer offers can be declined")
+	}
+	buyerPubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(buyerPubKeyHash, tx.BuyerHash) {
+		return nil, nil, errors.New("only the buyer of the transfer can decline it")
+	}
+	buyerPublicKey, err := wallet.PublicKeyToBytes(&wlt.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := sign(&wlt.PrivateKey, declineDigest(tx.TxID))
+	if err != nil {
+		return nil, nil, err
+	}
+	return buyerPublicKey, signature, nil
+}
+
+func VerifyDecline(tx *Tx, buyerPublicKey []byte, signature []byte) error {
+	buyerPubKey, err := publicKeyMatchingHash(buyerPublicKey, tx.BuyerHash)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPSS(buyerPubKey, crypto.SHA256, declineDigest(tx.TxID), signature, nil)
+}
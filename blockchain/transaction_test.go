@@ -0,0 +1,172 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fixed transactions of every kind of field, their ids must never change or the chain stops validating
+func goldenTxs() []Tx {
+	return []Tx{
+		{
+			ItemHash:  []byte("item"),
+			BuyerHash: []byte("buyer"),
+			Amount:    10,
+			Timestamp: 1646919219,
+		},
+		{
+			UTXOID:             []byte("previous tx"),
+			ItemHash:           []byte("item"),
+			SellerHash:         []byte("seller"),
+			BuyerHash:          []byte("buyer"),
+			Amount:             25,
+			Timestamp:          1646919220,
+			RequiresAcceptance: true,
+			LockHeight:         7,
+			LockTime:           1646919300,
+			ArbiterHash:        []byte("arbiter"),
+			Type:               TX_ESCROW,
+		},
+		{
+			SellerHash: []byte("seller"),
+			Timestamp:  1646919221,
+			Type:       TX_ASSEMBLE,
+			ItemHash:   []byte("composite"),
+			Parts: []TxPart{
+				{ItemHash: []byte("part a"), UTXOID: []byte("tx a")},
+				{ItemHash: []byte("part b")},
+			},
+			Note: "assembled",
+		},
+		{
+			Timestamp: 1646919222,
+			Type:      TX_SWAP,
+			Legs: []TxLeg{
+				{ItemHash: []byte("item a"), UTXOID: []byte("tx a"), SellerHash: []byte("alice"), BuyerHash: []byte("bob"), Amount: 1},
+				{ItemHash: []byte("item b"), UTXOID: []byte("tx b"), SellerHash: []byte("bob"), BuyerHash: []byte("alice"), Amount: 2},
+			},
+		},
+		{
+			ItemHash:        []byte("item"),
+			BuyerHash:       []byte("buyer"),
+			Timestamp:       1646919223,
+			MetadataHash:    []byte("metadata"),
+			ItemHashVersion: 1,
+		},
+	}
+}
+
+var goldenTxIDs = []string{
+	"a0111b9e4131ffa7ec7fdca915e91432978318269cab1d202eb683128c35390e",
+	"6b36d788c84ec0ffdeb5739929c650256d3e224d69ed2fd148c13edb0571edca",
+	"2a1d0f54eafe75e167b0054abddce967cf44a17512780a47ace68b3ec9cf1726",
+	"dd22d04119d684c1762724e721bc655e60614d5674af66e578e46215dab270a3",
+	"832b34bd2a0a5ba238aa8f737e0dfaa4139f096896b0a208c19d8b4f47c30abb",
+}
+
+func TestCalculateTxHashGolden(t *testing.T) {
+	for i, tx := range goldenTxs() {
+		txID, err := tx.CalculateTxHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(txID); got != goldenTxIDs[i] {
+			t.Errorf("transaction %d: id %s, want %s", i, got, goldenTxIDs[i])
+		}
+	}
+}
+
+// the id only depends on the transaction, not on what the process gob encoded before
+// the child process encodes other types first, so gob would number the types differently there
+func TestCalculateTxHashAcrossProcesses(t *testing.T) {
+	if os.Getenv("YUDHISHTHIRA_TXID_CHILD") == "1" {
+		var encoded bytes.Buffer
+		gob.NewEncoder(&encoded).Encode(&Block{Height: 1})
+		gob.NewEncoder(&encoded).Encode(TxSigner{PublicKey: []byte("key")})
+		for _, tx := range goldenTxs() {
+			txID, _ := tx.CalculateTxHash()
+			fmt.Printf("%x\n", txID)
+		}
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCalculateTxHashAcrossProcesses$")
+	cmd.Env = append(os.Environ(), "YUDHISHTHIRA_TXID_CHILD=1")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	childIDs := strings.Fields(string(output))
+	for i, tx := range goldenTxs() {
+		txID, _ := tx.CalculateTxHash()
+		if i >= len(childIDs) || childIDs[i] != hex.EncodeToString(txID) {
+			t.Fatalf("transaction %d: id differs between processes, child printed %v", i, childIDs)
+		}
+	}
+}
+
+func TestCalculateTxHash(t *testing.T) {
+	base := func() Tx {
+		return Tx{
+			UTXOID:     []byte("previous tx"),
+			ItemHash:   []byte("item"),
+			SellerHash: []byte("seller"),
+			BuyerHash:  []byte("buyer"),
+			Amount:     5,
+			Timestamp:  1646919219,
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(tx *Tx)
+		same   bool
+	}{
+		{"signature", func(tx *Tx) { tx.Signature = []byte("signature") }, true},
+		{"public key", func(tx *Tx) { tx.PublicKey = []byte("key") }, true},
+		{"buyer signature", func(tx *Tx) { tx.BuyerSignature = []byte("signature") }, true},
+		{"buyer public key", func(tx *Tx) { tx.BuyerPublicKey = []byte("key") }, true},
+		{"swap signers", func(tx *Tx) { tx.Signers = []TxSigner{{PublicKey: []byte("key"), Signature: []byte("signature")}} }, true},
+		{"transaction id", func(tx *Tx) { tx.TxID = []byte("id") }, true},
+		{"empty instead of nil", func(tx *Tx) { tx.MetadataHash, tx.ArbiterHash, tx.Parts = []byte{}, []byte{}, []TxPart{} }, true},
+		{"amount", func(tx *Tx) { tx.Amount++ }, false},
+		{"timestamp", func(tx *Tx) { tx.Timestamp++ }, false},
+		{"type", func(tx *Tx) { tx.Type = TX_RETIRE }, false},
+		{"note", func(tx *Tx) { tx.Note = "lost" }, false},
+		{"acceptance", func(tx *Tx) { tx.RequiresAcceptance = true }, false},
+		{"lock height", func(tx *Tx) { tx.LockHeight = 1 }, false},
+		{"lock time", func(tx *Tx) { tx.LockTime = 1 }, false},
+		{"arbiter", func(tx *Tx) { tx.ArbiterHash = []byte("arbiter") }, false},
+		{"metadata", func(tx *Tx) { tx.MetadataHash = []byte("metadata") }, false},
+		{"hash version", func(tx *Tx) { tx.ItemHashVersion = 1 }, false},
+		{"part", func(tx *Tx) { tx.Parts = []TxPart{{ItemHash: []byte("part")}} }, false},
+		{"leg", func(tx *Tx) { tx.Legs = []TxLeg{{ItemHash: []byte("item")}} }, false},
+		// fields written back to back would collide, the length prefixes keep them apart
+		{"bytes moved between fields", func(tx *Tx) { tx.ItemHash, tx.SellerHash = []byte("items"), []byte("eller") }, false},
+		{"seller and buyer swapped", func(tx *Tx) { tx.SellerHash, tx.BuyerHash = tx.BuyerHash, tx.SellerHash }, false},
+	}
+
+	baseTx := base()
+	baseID, err := baseTx.CalculateTxHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := base()
+			test.change(&tx)
+			txID, err := tx.CalculateTxHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := bytes.Equal(txID, baseID); same != test.same {
+				t.Errorf("id unchanged = %v, want %v", same, test.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// leads the encoding a transaction id is the hash of, so no other signed hash (eg. of a block or an api token) can be a transaction id
+const TX_ENCODING_TAG = "yudhishthira-tx:"
+
+// bump when the encoding below changes, the version follows the tag
+const TX_ENCODING_VERSION = 1
+
+// field tags of the encoding, never reuse or renumber these
+const (
+	txTagUTXOID             = 0x01
+	txTagItemHash           = 0x02
+	txTagSellerHash         = 0x03
+	txTagBuyerHash          = 0x04
+	txTagAmount             = 0x05
+	txTagTimestamp          = 0x06
+	txTagRequiresAcceptance = 0x07
+	txTagMetadataHash       = 0x08
+	txTagItemHashVersion    = 0x09
+	txTagType               = 0x0a
+	txTagNote               = 0x0b
+	txTagPart               = 0x0c // value is the encoded item hash and utxo id of the part
+	txTagLeg                = 0x0d // value is the encoded item hash, utxo id, seller, buyer and amount of the leg
+	txTagLockHeight         = 0x0e
+	txTagLockTime           = 0x0f
+	txTagArbiterHash        = 0x10
+)
+
+func writeTxField(buf *bytes.Buffer, tag byte, value []byte) {
+	lengthPrefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lengthPrefix, uint64(len(value)))
+	buf.WriteByte(tag)
+	buf.Write(lengthPrefix[:n])
+	buf.Write(value)
+}
+
+func uint64Bytes(value uint64) []byte {
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, value)
+	return encoded
+}
+
+// every field the transaction id commits to, in a fixed order and each written as tag, length, value
+// so the id is the same in every process and no two distinct transactions share an encoding
+// ids and signatures are left out since they are made over the id, public keys are bound through the pubkey hashes
+// and swap signers sign one after another, all of them over the same id
+// nil and empty fields are written the same, transactions decoded from json carry empty ones
+func (tx *Tx) canonicalEncoding() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(TX_ENCODING_TAG)
+	buf.WriteByte(TX_ENCODING_VERSION)
+
+	var requiresAcceptance byte
+	if tx.RequiresAcceptance {
+		requiresAcceptance = 1
+	}
+	writeTxField(&buf, txTagUTXOID, tx.UTXOID)
+	writeTxField(&buf, txTagItemHash, tx.ItemHash)
+	writeTxField(&buf, txTagSellerHash, tx.SellerHash)
+	writeTxField(&buf, txTagBuyerHash, tx.BuyerHash)
+	writeTxField(&buf, txTagAmount, uint64Bytes(tx.Amount))
+	writeTxField(&buf, txTagTimestamp, uint64Bytes(tx.Timestamp))
+	writeTxField(&buf, txTagRequiresAcceptance, []byte{requiresAcceptance})
+	writeTxField(&buf, txTagMetadataHash, tx.MetadataHash)
+	writeTxField(&buf, txTagItemHashVersion, []byte{tx.ItemHashVersion})
+	writeTxField(&buf, txTagType, []byte{tx.Type})
+	writeTxField(&buf, txTagNote, []byte(tx.Note))
+	for _, part := range tx.Parts {
+		var partBuf bytes.Buffer
+		writeTxField(&partBuf, txTagItemHash, part.ItemHash)
+		writeTxField(&partBuf, txTagUTXOID, part.UTXOID)
+		writeTxField(&buf, txTagPart, partBuf.Bytes())
+	}
+	for _, leg := range tx.Legs {
+		var legBuf bytes.Buffer
+		writeTxField(&legBuf, txTagItemHash, leg.ItemHash)
+		writeTxField(&legBuf, txTagUTXOID, leg.UTXOID)
+		writeTxField(&legBuf, txTagSellerHash, leg.SellerHash)
+		writeTxField(&legBuf, txTagBuyerHash, leg.BuyerHash)
+		writeTxField(&legBuf, txTagAmount, uint64Bytes(leg.Amount))
+		writeTxField(&buf, txTagLeg, legBuf.Bytes())
+	}
+	writeTxField(&buf, txTagLockHeight, uint64Bytes(tx.LockHeight))
+	writeTxField(&buf, txTagLockTime, uint64Bytes(tx.LockTime))
+	writeTxField(&buf, txTagArbiterHash, tx.ArbiterHash)
+	return buf.Bytes()
+}
@@ -0,0 +1,212 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// rejection codes, stable strings so that API clients can branch on them
+const (
+	REJECT_MALFORMED          = "malformed"
+	REJECT_BAD_HASH           = "bad-txid"
+	REJECT_BAD_SIGNATURE      = "bad-signature"
+	REJECT_ITEM_EXISTS        = "item-exists"
+	REJECT_INSUFFICIENT_FUNDS = "insufficient-funds"
+	REJECT_UNKNOWN_ITEM       = "unknown-item"
+	REJECT_NOT_OWNER          = "not-owner"
+	REJECT_STALE_INPUT        = "stale-input"
+	REJECT_ALREADY_MINED      = "already-mined"
+	REJECT_DUPLICATE          = "duplicate"
+	REJECT_MEMPOOL_CONFLICT   = "mempool-conflict"
+	REJECT_ITEM_RETIRED       = "item-retired"
+	REJECT_ITEM_REPORTED_LOST = "item-reported-lost"
+	REJECT_NOT_INTRODUCER     = "not-introducer"
+	REJECT_INVALID_STATE      = "invalid-state"
+	REJECT_ITEM_CONSUMED      = "item-consumed"
+	REJECT_IN_ESCROW          = "in-escrow"
+	REJECT_NOT_ARBITER        = "not-arbiter"
+	REJECT_NOT_VALIDATOR      = "not-validator"
+	REJECT_LOCKED             = "locked"         // valid otherwise, but not minable before its lock height or time
+	REJECT_INTERNAL           = "internal-error" // the node could not check the transaction, eg. a database error, no fault of the transaction
+)
+
+// reason a transaction was refused, returned as an error by the validation functions
+type TxRejection struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (rejection *TxRejection) Error() string {
+	return rejection.Code + ": " + rejection.Reason
+}
+
+func Reject(code string, reason string) *TxRejection {
+	return &TxRejection{Code: code, Reason: reason}
+}
+
+// the transaction id commits to the content and every signature present is valid
+func validateTxIntegrity(tx *Tx) *TxRejection {
+	if len(tx.TxID) == 0 {
+		return Reject(REJECT_MALFORMED, "transaction id is required")
+	}
+	txHash, err := tx.CalculateTxHash()
+	if err != nil {
+		return Reject(REJECT_MALFORMED, err.Error())
+	}
+	if !bytes.Equal(txHash, tx.TxID) {
+		return Reject(REJECT_BAD_HASH, "transaction hash does not match transaction content")
+	}
+	if err := tx.VerifySignatures(); err != nil {
+		return Reject(REJECT_BAD_SIGNATURE, err.Error())
+	}
+	return nil
+}
+
+// checks a transaction signed elsewhere against its own content and the current state of the chain
+// transactions that are valid apart from their time lock are refused with REJECT_LOCKED, checked last
+func ValidateTransaction(tx *Tx, chain *BlockChain) *TxRejection {
+	if rejection := validateTxContent(tx, chain); rejection != nil {
+		return rejection
+	}
+	// the next block is the earliest one the transaction can be part of
+	if !tx.IsFinal(chain.GetHeight()+1, uint64(time.Now().Unix())) {
+		return Reject(REJECT_LOCKED, fmt.Sprintf("transaction is locked until height %d and unix time %d", tx.LockHeight, tx.LockTime))
+	}
+	return nil
+}
+
+// same checks as ValidateTransaction for a coinbase, with the item looked up in a set of the chain items (see AllItemHashes) instead of a scan of the chain
+// bulk imports collect the set once for every row, it has to be taken at the current tip
+func ValidateIntroduction(tx *Tx, chainItems map[string]bool, chain *BlockChain) *TxRejection {
+	if !tx.IsCoinbase() || len(tx.ItemHash) == 0 || len(tx.BuyerHash) == 0 {
+		return Reject(REJECT_MALFORMED, "only coinbase transactions with an item hash and buyer hash introduce items")
+	}
+	if rejection := validateTxIntegrity(tx); rejection != nil {
+		return rejection
+	}
+	if len(tx.Parts) != 0 || len(tx.Legs) != 0 || len(tx.Signers) != 0 || len(tx.ArbiterHash) != 0 {
+		return Reject(REJECT_MALFORMED, "coinbase transactions can not list parts, legs, signers or an arbiter")
+	}
+	if chainItems[hex.EncodeToString(tx.ItemHash)] {
+		return Reject(REJECT_ITEM_EXISTS, "item already exists in the chain")
+	}
+	if rejection := checkIntroductionFunds(tx, chain); rejection != nil {
+		return rejection
+	}
+	if !tx.IsFinal(chain.GetHeight()+1, uint64(time.Now().Unix())) {
+		return Reject(REJECT_LOCKED, fmt.Sprintf("transaction is locked until height %d and unix time %d", tx.LockHeight, tx.LockTime))
+	}
+	return nil
+}
+
+// items new to the chain cost the introduction cost, paid by the payer of CreditDebit
+func checkIntroductionFunds(tx *Tx, chain *BlockChain) *TxRejection {
+	payer, debit := tx.CreditDebit(chain.Params)
+	if debit == 0 {
+		return nil
+	}
+	credits, err := chain.CreditBalance(payer)
+	if err != nil {
+		return Reject(REJECT_INTERNAL, err.Error())
+	}
+	if credits < int64(debit) {
+		return Reject(REJECT_INSUFFICIENT_FUNDS, fmt.Sprintf("the address owner has %d credits, introducing the items into the chain needs %d", credits, debit))
+	}
+	return nil
+}
+
+func validateTxContent(tx *Tx, chain *BlockChain) *TxRejection {
+	if tx.Type == TX_SWAP {
+		if rejection := validateTxIntegrity(tx); rejection != nil {
+			return rejection
+		}
+		return validateSwap(tx, chain)
+	}
+	if tx.Type == TX_CREDIT_TRANSFER {
+		if rejection := validateTxIntegrity(tx); rejection != nil {
+			return rejection
+		}
+		return validateCreditTransfer(tx, chain)
+	}
+	if tx.IsValidatorVote() {
+		if rejection := validateTxIntegrity(tx); rejection != nil {
+			return rejection
+		}
+		return validateValidatorVote(tx, chain)
+	}
+	if len(tx.ItemHash) == 0 || len(tx.BuyerHash) == 0 {
+		return Reject(REJECT_MALFORMED, "item hash and buyer hash are required")
+	}
+	if rejection := validateTxIntegrity(tx); rejection != nil {
+		return rejection
+	}
+
+	if len(tx.Parts) != 0 && tx.Type != TX_ASSEMBLE && tx.Type != TX_DISASSEMBLE {
+		return Reject(REJECT_MALFORMED, "only assembly and disassembly transactions can list parts")
+	}
+	if len(tx.Legs) != 0 || len(tx.Signers) != 0 {
+		return Reject(REJECT_MALFORMED, "only swaps can list legs and signers")
+	}
+	isEscrowTx := tx.Type == TX_ESCROW || tx.Type == TX_ESCROW_RELEASE || tx.Type == TX_ESCROW_REFUND
+	if len(tx.ArbiterHash) != 0 && !isEscrowTx {
+		return Reject(REJECT_MALFORMED, "only escrow transactions can name an arbiter")
+	}
+
+	if tx.IsCoinbase() {
+		itemExists, err := chain.FindItemExists(tx.ItemHash)
+		if err != nil {
+			return Reject(REJECT_INTERNAL, err.Error())
+		}
+		if itemExists {
+			return Reject(REJECT_ITEM_EXISTS, "item already exists in the chain")
+		}
+		return checkIntroductionFunds(tx, chain)
+	}
+
+	if tx.Type == TX_ASSEMBLE || tx.Type == TX_DISASSEMBLE {
+		return validateCompositeTx(tx, chain)
+	}
+
+	itemState, err := chain.ItemState(tx.ItemHash)
+	if err != nil {
+		return Reject(REJECT_UNKNOWN_ITEM, err.Error())
+	}
+	if bytes.Equal(itemState.LastTxID, tx.TxID) {
+		return Reject(REJECT_ALREADY_MINED, "transaction is already part of the chain")
+	}
+
+	if tx.RequiresAcceptance && tx.Type != TX_TRANSFER {
+		return Reject(REJECT_MALFORMED, "only transfers can require acceptance")
+	}
+
+	switch tx.Type {
+	case TX_TRANSFER, TX_ESCROW:
+		if !bytes.Equal(itemState.Owner, tx.SellerHash) {
+			return Reject(REJECT_NOT_OWNER, "the item does not belong to the source address to sell")
+		}
+		if rejection := itemState.transferRejection(); rejection != nil {
+			return rejection
+		}
+		if tx.Type == TX_ESCROW && (len(tx.ArbiterHash) == 0 || bytes.Equal(tx.ArbiterHash, tx.SellerHash) || bytes.Equal(tx.ArbiterHash, tx.BuyerHash)) {
+			return Reject(REJECT_MALFORMED, "escrow needs an arbiter other than the seller and the buyer")
+		}
+	case TX_ESCROW_RELEASE, TX_ESCROW_REFUND:
+		if rejection := checkEscrowSettlement(tx, itemState); rejection != nil {
+			return rejection
+		}
+	default:
+		if !bytes.Equal(itemState.Owner, tx.BuyerHash) {
+			return Reject(REJECT_MALFORMED, "lifecycle transactions must keep the current owner as buyer")
+		}
+		if rejection := itemState.checkLifecycleTx(tx.Type, tx.SellerHash); rejection != nil {
+			return rejection
+		}
+	}
+
+	if !bytes.Equal(itemState.LastTxID, tx.UTXOID) {
+		return Reject(REJECT_STALE_INPUT, "transaction does not spend the latest transaction of the item")
+	}
+	return nil
+}
@@ -1,19 +1,70 @@
 package cli
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/config"
+	"github.com/pranjalpokharel7/yudhishthira/importer"
 	"github.com/pranjalpokharel7/yudhishthira/object"
 	"github.com/pranjalpokharel7/yudhishthira/utility"
 	"github.com/pranjalpokharel7/yudhishthira/wallet"
 )
 
 func CommandLineHelp() {
+	fmt.Println("Usage: yudhishthira <command> [flags] [args]")
+	fmt.Println("Node Commands:")
+	fmt.Println("\t node run [--config file] [flags] - Start a node, see yudhishthira.example.yaml for its settings (\"node [flags]\" works as well)")
+	fmt.Println("\t wallet new [--file filename] - Generate a wallet, never overwriting an existing file")
+	fmt.Println("\t wallet list [--dir directory] - List the *.keystore wallets in the directory with their addresses")
+	fmt.Println("\t wallet show [--file filename] - Show the keys, introduction credits and owned items of a wallet")
+	fmt.Println("\t wallet address [--file filename] - Print the address of the wallet, or of the node wallet with --node")
+	fmt.Println("\t chain print [--limit n] - Print the blocks from the tip backwards")
+	fmt.Println("\t chain height - Print the height of the chain")
+	fmt.Println("\t chain block <hash|height> - Print a block")
+	fmt.Println("\t item introduce --item id | --obj filename [--amount n] - Introduce an item with the node wallet")
+	fmt.Println("\t item transfer --to address --item itemhash --amount n [--lock-height n] [--lock-time unix] - Transfer an item owned by the node wallet")
+	fmt.Println("\t item history <itemhash> - Print the transactions with the item, latest first")
+	fmt.Println("\t item owner <itemhash> - Print the owner and status of the item")
+	fmt.Println("\t tx submit [--in filename] - Submit a signed transaction to the node")
+	fmt.Println("\t tx show <txid> - Print a transaction and the block it was mined in, or the pool it waits in")
+	fmt.Println("\t peers list - List the peers and banned hosts of the node")
+	fmt.Println("\t peers add <host:port> - Connect the node to a peer")
+	fmt.Println("\t peers ban <host[:port]> - Ban a host and drop it from the peers of the node")
+	fmt.Println("\t validators list - List the validators of a proof of authority network and the open votes on changing them")
+	fmt.Println("\t validators vote <address> [--remove] - Vote with the node wallet, a validator, for adding or removing a validator")
+	fmt.Println("\t mine [--blocks n] [--wallet filename] [--threads n] - Mine empty blocks into the local database, or let the node mine its memory pool with --node")
+	fmt.Println("\t Every node command takes --json to print json, --config, --network and --data-dir to pick the local database, and --node url to use a running node instead.")
+	fmt.Println("\t Commands that need the node wallet or pools use the API address of the config if --node is not given.")
 	fmt.Println("Available Commands:")
 	fmt.Println("\t genwallet --file filename - Generate wallet and store in filename")
-	fmt.Println("\t objhash --obj filename - Generate object hash for object details stored in filename (csv for now, see ./object/dummy_object.csv)")
+	fmt.Println("\t objhash --obj filename [--schemas dir] [--verify itemhash] - Validate the object in filename (csv, json or yaml, see ./object/dummy_object.csv) against its category schema and print its hash, or check it against itemhash under every hash version")
+	fmt.Println("\t offer --to address --item itemhash --amount n [--node url] - Offer an item to the buyer, pending until the buyer accepts")
+	fmt.Println("\t offers --address address [--node url] - List pending transfer offers to and from the address")
+	fmt.Println("\t acceptoffer --tx txid [--node url] - Countersign a pending offer with the node's wallet")
+	fmt.Println("\t declineoffer --tx txid [--node url] - Decline a pending offer with the node's wallet")
+	fmt.Println("\t swap --legs itemhash:address:amount,... [--node url] - Move several items between their owners and the given addresses at once, every seller has to sign")
+	fmt.Println("\t signswap --tx txid [--node url] - Sign a pending swap with the node's wallet")
+	fmt.Println("\t exporttx --from address --to address --item itemhash --amount n [--accept] [--node url] --out filename - Build an unsigned transfer on a (watch-only) node")
+	fmt.Println("\t signtx --in filename --wallet filename --out filename - Sign an exported transfer offline with the seller wallet")
+	fmt.Println("\t submittx --in filename [--node url] - Submit a signed transaction to the node for validation and broadcast")
+	fmt.Println("\t lifecycle --action retire|recall|report-lost|clear-report --item itemhash [--note text] [--node url] - Retire, recall or report an item lost with the node's wallet")
+	fmt.Println("\t assemble --item compositehash --parts hash,hash,... [--note text] [--node url] - Assemble owned parts into a new composite item with the node's wallet")
+	fmt.Println("\t disassemble --item compositehash [--parts hash,hash,...] [--note text] [--node url] - Take a composite item apart, releasing its original parts unless parts are given")
+	fmt.Println("\t credits --address address [--node url] - Show the introduction credits of an address")
+	fmt.Println("\t sendcredits --to address --amount n [--node url] - Send introduction credits from the node's wallet")
+	fmt.Println("\t itemstate --item itemhash [--node url] - Show the owner, recall flag and lifecycle status of an item")
+	fmt.Println("\t import --file filename [--format csv|jsonl|manifest] [--batch n] [--report filename] [--node url] - Introduce every item in the file with the node's wallet and write a per row report")
 }
 
 func RunCLI() {
@@ -21,12 +72,93 @@ func RunCLI() {
 		CommandLineHelp()
 		os.Exit(1)
 	}
+	// flags alone start the node, as the binary did before it had commands
+	if strings.HasPrefix(os.Args[1], "-") && os.Args[1] != "-h" && os.Args[1] != "--help" {
+		runNode(os.Args[1:])
+		return
+	}
+	if runCommandTree(os.Args[1:]) {
+		return
+	}
+	// POST requests of the single word commands need the token of the node as well
+	if cfg, err := config.Load(""); err == nil {
+		apiToken = cfg.API.AuthToken
+	}
 
 	genWallet := flag.NewFlagSet("genwallet", flag.ExitOnError)
 	checkObjectHash := flag.NewFlagSet("objhash", flag.ExitOnError)
+	makeOffer := flag.NewFlagSet("offer", flag.ExitOnError)
+	listOffers := flag.NewFlagSet("offers", flag.ExitOnError)
+	acceptOffer := flag.NewFlagSet("acceptoffer", flag.ExitOnError)
+	declineOffer := flag.NewFlagSet("declineoffer", flag.ExitOnError)
+	makeSwap := flag.NewFlagSet("swap", flag.ExitOnError)
+	signSwap := flag.NewFlagSet("signswap", flag.ExitOnError)
+	exportTx := flag.NewFlagSet("exporttx", flag.ExitOnError)
+	signTx := flag.NewFlagSet("signtx", flag.ExitOnError)
+	submitTx := flag.NewFlagSet("submittx", flag.ExitOnError)
+	importItems := flag.NewFlagSet("import", flag.ExitOnError)
+	lifecycleTx := flag.NewFlagSet("lifecycle", flag.ExitOnError)
+	itemState := flag.NewFlagSet("itemstate", flag.ExitOnError)
+	assembleItem := flag.NewFlagSet("assemble", flag.ExitOnError)
+	disassembleItem := flag.NewFlagSet("disassemble", flag.ExitOnError)
+	walletCredits := flag.NewFlagSet("credits", flag.ExitOnError)
+	sendCredits := flag.NewFlagSet("sendcredits", flag.ExitOnError)
 
 	walletFileLocation := genWallet.String("file", wallet.WALLET_FILE, "The location to store the wallet file")
 	objectFileLocation := checkObjectHash.String("obj", "", "The location of the file where the object data is stored")
+	schemaDirLocation := checkObjectHash.String("schemas", object.SCHEMA_DIR, "The directory containing the category schemas")
+	verifyObjectHash := checkObjectHash.String("verify", "", "Item hash to verify the object against, under both the legacy and the current scheme")
+
+	offerDestination := makeOffer.String("to", "", "Address of the buyer")
+	offerItemHash := makeOffer.String("item", "", "Hash of the item being offered")
+	offerAmount := makeOffer.Uint64("amount", 0, "Amount the item is sold for")
+	offerNode := makeOffer.String("node", DEFAULT_NODE_URL, "API address of the node holding the seller wallet")
+	listOffersAddress := listOffers.String("address", "", "Address whose offers are listed")
+	listOffersNode := listOffers.String("node", DEFAULT_NODE_URL, "API address of the node")
+	acceptOfferTxID := acceptOffer.String("tx", "", "Transaction id of the offer")
+	acceptOfferNode := acceptOffer.String("node", DEFAULT_NODE_URL, "API address of the node holding the buyer wallet")
+	declineOfferTxID := declineOffer.String("tx", "", "Transaction id of the offer")
+	declineOfferNode := declineOffer.String("node", DEFAULT_NODE_URL, "API address of the node holding the buyer wallet")
+	swapLegs := makeSwap.String("legs", "", "Comma separated itemhash:address:amount legs")
+	swapNode := makeSwap.String("node", DEFAULT_NODE_URL, "API address of the node")
+	signSwapTxID := signSwap.String("tx", "", "Transaction id of the swap")
+	signSwapNode := signSwap.String("node", DEFAULT_NODE_URL, "API address of the node holding a seller wallet")
+	exportTxSource := exportTx.String("from", "", "Address of the seller")
+	exportTxDestination := exportTx.String("to", "", "Address of the buyer")
+	exportTxItemHash := exportTx.String("item", "", "Hash of the item being sold")
+	exportTxAmount := exportTx.Uint64("amount", 0, "Amount the item is sold for")
+	exportTxAccept := exportTx.Bool("accept", false, "Require the buyer to countersign the transfer")
+	exportTxNode := exportTx.String("node", DEFAULT_NODE_URL, "API address of the node")
+	exportTxOut := exportTx.String("out", "unsigned_tx.json", "File to write the unsigned transaction to")
+	signTxIn := signTx.String("in", "unsigned_tx.json", "File containing the unsigned transaction")
+	signTxWallet := signTx.String("wallet", wallet.WALLET_FILE, "Wallet file of the seller")
+	signTxOut := signTx.String("out", "signed_tx.json", "File to write the signed transaction to")
+	submitTxIn := submitTx.String("in", "signed_tx.json", "File containing the signed transaction")
+	submitTxNode := submitTx.String("node", DEFAULT_NODE_URL, "API address of the node")
+	importFile := importItems.String("file", "", "CSV, JSON-lines or manifest file listing the items")
+	importFormat := importItems.String("format", "", "Format of the file, guessed from the extension if empty")
+	importBatch := importItems.Int("batch", importer.DEFAULT_BATCH_SIZE, "Number of coinbase transactions created per batch")
+	importReport := importItems.String("report", "import_report.csv", "File to write the per row report to (.csv or .json)")
+	importNode := importItems.String("node", DEFAULT_NODE_URL, "API address of the node holding the introducing wallet")
+	lifecycleAction := lifecycleTx.String("action", "", "One of retire, recall, report-lost or clear-report")
+	lifecycleItemHash := lifecycleTx.String("item", "", "Hash of the item")
+	lifecycleNote := lifecycleTx.String("note", "", "Reason recorded on the chain with the transaction")
+	lifecycleNode := lifecycleTx.String("node", DEFAULT_NODE_URL, "API address of the node holding the owner (or introducer, for recalls) wallet")
+	itemStateHash := itemState.String("item", "", "Hash of the item")
+	itemStateNode := itemState.String("node", DEFAULT_NODE_URL, "API address of the node")
+	assembleItemHash := assembleItem.String("item", "", "Hash of the new composite item")
+	assembleParts := assembleItem.String("parts", "", "Comma separated hashes of the parts")
+	assembleNote := assembleItem.String("note", "", "Note recorded on the chain with the transaction")
+	assembleNode := assembleItem.String("node", DEFAULT_NODE_URL, "API address of the node holding the owner wallet")
+	disassembleItemHash := disassembleItem.String("item", "", "Hash of the composite item")
+	disassembleParts := disassembleItem.String("parts", "", "Comma separated hashes of the released parts, defaults to the assembled parts")
+	disassembleNote := disassembleItem.String("note", "", "Note recorded on the chain with the transaction")
+	disassembleNode := disassembleItem.String("node", DEFAULT_NODE_URL, "API address of the node holding the owner wallet")
+	walletCreditsAddress := walletCredits.String("address", "", "Address whose introduction credits are shown")
+	walletCreditsNode := walletCredits.String("node", DEFAULT_NODE_URL, "API address of the node")
+	sendCreditsDestination := sendCredits.String("to", "", "Address receiving the credits")
+	sendCreditsAmount := sendCredits.Uint64("amount", 0, "Number of credits to send")
+	sendCreditsNode := sendCredits.String("node", DEFAULT_NODE_URL, "API address of the node holding the sending wallet")
 
 	switch os.Args[1] {
 	case "genwallet":
@@ -35,6 +167,57 @@ func RunCLI() {
 	case "objhash":
 		err := checkObjectHash.Parse(os.Args[2:])
 		utility.ErrThenPanic(err)
+	case "offer":
+		err := makeOffer.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "offers":
+		err := listOffers.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "acceptoffer":
+		err := acceptOffer.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "declineoffer":
+		err := declineOffer.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "swap":
+		err := makeSwap.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "signswap":
+		err := signSwap.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "exporttx":
+		err := exportTx.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "signtx":
+		err := signTx.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "submittx":
+		err := submitTx.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "import":
+		err := importItems.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "lifecycle":
+		err := lifecycleTx.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "itemstate":
+		err := itemState.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "assemble":
+		err := assembleItem.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "disassemble":
+		err := disassembleItem.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "credits":
+		err := walletCredits.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	case "sendcredits":
+		err := sendCredits.Parse(os.Args[2:])
+		utility.ErrThenPanic(err)
+	default:
+		CommandLineHelp()
+		os.Exit(1)
 	}
 
 	if genWallet.Parsed() {
@@ -43,8 +226,195 @@ func RunCLI() {
 
 	if checkObjectHash.Parsed() {
 		var obj object.Object
-		err := obj.LoadCSVData(*objectFileLocation)
+		err := obj.LoadObjectFile(*objectFileLocation)
 		utility.ErrThenPanic(err)
-		fmt.Printf("Object Hash: %x\n", obj.HashObject())
+		schemas, err := object.LoadSchemas(*schemaDirLocation)
+		utility.ErrThenLogFatal(err)
+		err = schemas.Validate(&obj)
+		utility.ErrThenLogFatal(err)
+		fmt.Println(&obj)
+		legacyHash, _ := obj.HashObjectVersion(object.HASH_VERSION_LEGACY)
+		fmt.Printf("Object Hash (version %d): %x\n", object.CURRENT_HASH_VERSION, obj.HashObject())
+		fmt.Printf("Legacy Object Hash (version %d): %x\n", object.HASH_VERSION_LEGACY, legacyHash)
+
+		if *verifyObjectHash != "" {
+			providedHash, err := hex.DecodeString(*verifyObjectHash)
+			utility.ErrThenLogFatal(err)
+			if hashVersion, matched := obj.MatchHashVersion(providedHash); matched {
+				fmt.Printf("Verified: object matches the hash under version %d\n", hashVersion)
+			} else {
+				fmt.Println("Not verified: object does not match the hash under any known version")
+				os.Exit(1)
+			}
+		}
+	}
+
+	if makeOffer.Parsed() {
+		offerData := map[string]interface{}{
+			"destination": *offerDestination,
+			"item_hash":   *offerItemHash,
+			"amount":      *offerAmount,
+		}
+		body, err := postToNode(*offerNode, "/transaction/offer", offerData)
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if listOffers.Parsed() {
+		body, err := getFromNode(*listOffersNode, "/transaction/offers/"+*listOffersAddress)
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if acceptOffer.Parsed() {
+		body, err := postToNode(*acceptOfferNode, "/transaction/offer/accept", map[string]string{"tx_id": *acceptOfferTxID})
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if declineOffer.Parsed() {
+		body, err := postToNode(*declineOfferNode, "/transaction/offer/decline", map[string]string{"tx_id": *declineOfferTxID})
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if lifecycleTx.Parsed() {
+		switch *lifecycleAction {
+		case "retire", "recall", "report-lost", "clear-report":
+		default:
+			log.Fatalf("unknown lifecycle action %q", *lifecycleAction)
+		}
+		lifecycleData := map[string]string{"item_hash": *lifecycleItemHash, "note": *lifecycleNote}
+		body, err := postToNode(*lifecycleNode, "/item/"+*lifecycleAction, lifecycleData)
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if itemState.Parsed() {
+		body, err := getFromNode(*itemStateNode, "/item/state/"+*itemStateHash)
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if assembleItem.Parsed() {
+		assembleData := map[string]interface{}{"item_hash": *assembleItemHash, "parts": splitList(*assembleParts), "note": *assembleNote}
+		body, err := postToNode(*assembleNode, "/item/assemble", assembleData)
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if disassembleItem.Parsed() {
+		disassembleData := map[string]interface{}{"item_hash": *disassembleItemHash, "parts": splitList(*disassembleParts), "note": *disassembleNote}
+		body, err := postToNode(*disassembleNode, "/item/disassemble", disassembleData)
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if walletCredits.Parsed() {
+		body, err := getFromNode(*walletCreditsNode, "/wallet/"+*walletCreditsAddress+"/credits")
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if sendCredits.Parsed() {
+		creditData := map[string]interface{}{"destination": *sendCreditsDestination, "amount": *sendCreditsAmount}
+		body, err := postToNode(*sendCreditsNode, "/transaction/credits", creditData)
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if makeSwap.Parsed() {
+		var legs []map[string]interface{}
+		for _, leg := range splitList(*swapLegs) {
+			legFields := strings.Split(leg, ":")
+			if len(legFields) != 3 {
+				log.Fatalf("leg %q is not of the form itemhash:address:amount", leg)
+			}
+			amount, err := strconv.ParseUint(legFields[2], 10, 64)
+			utility.ErrThenLogFatal(err)
+			legs = append(legs, map[string]interface{}{"item_hash": legFields[0], "destination": legFields[1], "amount": amount})
+		}
+		body, err := postToNode(*swapNode, "/transaction/swap", map[string]interface{}{"legs": legs})
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if signSwap.Parsed() {
+		body, err := postToNode(*signSwapNode, "/transaction/swap/sign", map[string]string{"tx_id": *signSwapTxID})
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if exportTx.Parsed() {
+		unsignedTxData := map[string]interface{}{
+			"source":              *exportTxSource,
+			"destination":         *exportTxDestination,
+			"item_hash":           *exportTxItemHash,
+			"amount":              *exportTxAmount,
+			"requires_acceptance": *exportTxAccept,
+		}
+		body, err := postToNode(*exportTxNode, "/transaction/unsigned", unsignedTxData)
+		utility.ErrThenLogFatal(err)
+		err = ioutil.WriteFile(*exportTxOut, body, 0644)
+		utility.ErrThenLogFatal(err)
+		fmt.Printf("Unsigned transaction written to %s\n", *exportTxOut)
+	}
+
+	// runs entirely offline, meant for the machine holding the seller's keys
+	if signTx.Parsed() {
+		unsignedTx, err := blockchain.LoadUnsignedTxFromFile(*signTxIn)
+		utility.ErrThenLogFatal(err)
+
+		var wlt wallet.Wallet
+		err = wlt.LoadWalletFromFile(*signTxWallet)
+		utility.ErrThenLogFatal(err)
+
+		fmt.Println(unsignedTx.Tx)
+		signedTx, err := unsignedTx.Sign(&wlt)
+		utility.ErrThenLogFatal(err)
+
+		signedTxJSON, err := json.MarshalIndent(signedTx, "", "\t")
+		utility.ErrThenLogFatal(err)
+		err = ioutil.WriteFile(*signTxOut, signedTxJSON, 0644)
+		utility.ErrThenLogFatal(err)
+		fmt.Printf("Signed transaction written to %s\n", *signTxOut)
+	}
+
+	if submitTx.Parsed() {
+		signedTxJSON, err := ioutil.ReadFile(*submitTxIn)
+		utility.ErrThenLogFatal(err)
+		body, err := postToNode(*submitTxNode, "/transaction/submit", json.RawMessage(signedTxJSON))
+		utility.ErrThenLogFatal(err)
+		printJSON(body)
+	}
+
+	if importItems.Parsed() {
+		format := *importFormat
+		if format == "" {
+			format = importer.FormatFromFileName(*importFile)
+		}
+		fileContent, err := ioutil.ReadFile(*importFile)
+		utility.ErrThenLogFatal(err)
+
+		path := fmt.Sprintf("/item/import?format=%s&batch=%d", url.QueryEscape(format), *importBatch)
+		body, err := postRawToNode(*importNode, path, "application/octet-stream", fileContent)
+		utility.ErrThenLogFatal(err)
+
+		var report importer.Report
+		err = json.Unmarshal(body, &report)
+		utility.ErrThenLogFatal(err)
+
+		reportFile, err := os.Create(*importReport)
+		utility.ErrThenLogFatal(err)
+		defer reportFile.Close()
+		if strings.ToLower(filepath.Ext(*importReport)) == ".json" {
+			reportJSON, _ := json.MarshalIndent(report, "", "\t")
+			_, err = reportFile.Write(reportJSON)
+		} else {
+			err = report.WriteCSV(reportFile)
+		}
+		utility.ErrThenLogFatal(err)
+
+		fmt.Printf("%d rows: %d introduced, %d rejected, report written to %s\n", report.Total, report.Introduced, report.Rejected, *importReport)
 	}
 }
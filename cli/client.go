@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const DEFAULT_NODE_URL = "http://localhost:8080"
+
+// bearer token sent with POST requests, taken from the api.auth_token of the config
+var apiToken string
+
+func postRequest(url string, contentType string, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+apiToken)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// reads the response body, and turns the API's error json into a go error
+func readNodeResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errorJSON struct {
+			ErrorMsg string `json:"error"`
+		}
+		if json.Unmarshal(body, &errorJSON) == nil && errorJSON.ErrorMsg != "" {
+			return nil, fmt.Errorf("node responded with %d: %s", resp.StatusCode, errorJSON.ErrorMsg)
+		}
+		return nil, fmt.Errorf("node responded with %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+func getFromNode(nodeURL string, path string) ([]byte, error) {
+	resp, err := http.Get(nodeURL + path)
+	if err != nil {
+		return nil, err
+	}
+	return readNodeResponse(resp)
+}
+
+func postToNode(nodeURL string, path string, payload interface{}) ([]byte, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postRequest(nodeURL+path, "application/json", payloadJSON)
+	if err != nil {
+		return nil, err
+	}
+	return readNodeResponse(resp)
+}
+
+// posts a file as is, the node decides how to read it from the content type and query
+func postRawToNode(nodeURL string, path string, contentType string, payload []byte) ([]byte, error) {
+	resp, err := postRequest(nodeURL+path, contentType, payload)
+	if err != nil {
+		return nil, err
+	}
+	return readNodeResponse(resp)
+}
+
+// comma separated flag values, empty entries are dropped
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func printJSON(body []byte) {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "\t"); err != nil {
+		fmt.Println(string(body))
+		return
+	}
+	fmt.Println(indented.String())
+}
@@ -0,0 +1,737 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/config"
+	"github.com/pranjalpokharel7/yudhishthira/node"
+	"github.com/pranjalpokharel7/yudhishthira/object"
+	"github.com/pranjalpokharel7/yudhishthira/p2p"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// "yudhishthira <group> <command> [flags] [args]", mine is the only command without a group
+var commandTree = map[string]map[string]func(args []string){
+	"node":       {"run": runNode},
+	"wallet":     {"new": walletNew, "list": walletList, "show": walletShow, "address": walletAddress},
+	"chain":      {"print": chainPrint, "height": chainHeight, "block": chainBlock},
+	"item":       {"introduce": itemIntroduce, "transfer": itemTransfer, "history": itemHistory, "owner": itemOwner},
+	"tx":         {"submit": txSubmit, "show": txShow},
+	"peers":      {"list": peersList, "add": peersAdd, "ban": peersBan},
+	"validators": {"list": validatorsList, "vote": validatorsVote},
+}
+
+// runs the command if the arguments name one of the tree, false leaves them to the single word commands
+func runCommandTree(args []string) bool {
+	if args[0] == "mine" {
+		mine(args[1:])
+		return true
+	}
+	group, ok := commandTree[args[0]]
+	if !ok {
+		return false
+	}
+	// "node [flags]" starts the node as well
+	if args[0] == "node" && (len(args) == 1 || strings.HasPrefix(args[1], "-")) {
+		runNode(args[1:])
+		return true
+	}
+	if len(args) < 2 {
+		log.Fatalf("%s needs a command, one of %s", args[0], strings.Join(commandNames(group), ", "))
+	}
+	run, ok := group[args[1]]
+	if !ok {
+		log.Fatalf("unknown command %s %s, expected one of %s", args[0], args[1], strings.Join(commandNames(group), ", "))
+	}
+	run(args[2:])
+	return true
+}
+
+func commandNames(group map[string]func(args []string)) []string {
+	var names []string
+	for name := range group {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// flags every command of the tree shares
+// read commands use the local database of the network unless --node is given, commands that
+// need the wallet or the pools of a node talk to --node, or to the API address of the config
+type command struct {
+	flagSet    *flag.FlagSet
+	jsonOutput *bool
+	nodeURL    *string
+	configPath *string
+	network    *string
+	dataDir    *string
+	cfg        *config.Config
+}
+
+func newCommand(name string) *command {
+	flagSet := flag.NewFlagSet(name, flag.ExitOnError)
+	return &command{
+		flagSet:    flagSet,
+		jsonOutput: flagSet.Bool("json", false, "Print the result as json"),
+		nodeURL:    flagSet.String("node", "", "API address of a running node, eg. "+DEFAULT_NODE_URL),
+		configPath: flagSet.String("config", "", fmt.Sprintf("YAML config file, %s is used if it exists", config.DEFAULT_CONFIG)),
+		network:    flagSet.String("network", "", fmt.Sprintf("Network of the local database, one of %v", blockchain.NetworkNames())),
+		dataDir:    flagSet.String("data-dir", "", "Directory the local chain database is stored in"),
+	}
+}
+
+// flags may come before and after the positional arguments, which are returned
+func (cmd *command) parse(args []string) []string {
+	var positional []string
+	for {
+		cmd.flagSet.Parse(args)
+		if cmd.flagSet.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, cmd.flagSet.Arg(0))
+		args = cmd.flagSet.Args()[1:]
+	}
+}
+
+func (cmd *command) expectArgs(args []string, usage string) {
+	if len(args) != strings.Count(usage, "<") {
+		log.Fatalf("usage: yudhishthira %s %s", cmd.flagSet.Name(), usage)
+	}
+}
+
+// the node config read from the config file and the environment, with --network and --data-dir applied
+func (cmd *command) config() *config.Config {
+	if cmd.cfg != nil {
+		return cmd.cfg
+	}
+	cfg, err := config.Load(*cmd.configPath)
+	utility.ErrThenLogFatal(err)
+	if *cmd.network != "" {
+		cfg.Network = *cmd.network
+	}
+	if *cmd.dataDir != "" {
+		cfg.DataDir = *cmd.dataDir
+	}
+	utility.ErrThenLogFatal(cfg.Validate())
+	// the output of a command is its result, logs below warnings only show up if the config asks for debug logs
+	if cfg.LogLevel != utility.LOG_DEBUG {
+		cfg.LogLevel = utility.LOG_WARN
+	}
+	utility.SetLogLevel(cfg.LogLevel)
+	apiToken = cfg.API.AuthToken
+	cmd.cfg = cfg
+	return cfg
+}
+
+func (cmd *command) params() *blockchain.ChainParams {
+	params, err := cmd.config().ChainParams()
+	utility.ErrThenLogFatal(err)
+	return params
+}
+
+// --node, or the API the node of the config listens on
+func (cmd *command) nodeAPI() string {
+	cfg := cmd.config()
+	if *cmd.nodeURL != "" {
+		return strings.TrimSuffix(*cmd.nodeURL, "/")
+	}
+	host, port, err := net.SplitHostPort(cfg.APIListenAddress(cmd.params()))
+	utility.ErrThenLogFatal(err)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+func (cmd *command) source() chainSource {
+	if *cmd.nodeURL != "" {
+		return &apiSource{nodeURL: cmd.nodeAPI()}
+	}
+	source, err := openLocalSource(cmd.params())
+	utility.ErrThenLogFatal(err)
+	return source
+}
+
+// prints the value as json with --json, otherwise the human readable form
+func (cmd *command) output(value interface{}, human func()) {
+	if !*cmd.jsonOutput {
+		human()
+		return
+	}
+	valueJSON, err := json.MarshalIndent(value, "", "\t")
+	utility.ErrThenLogFatal(err)
+	fmt.Println(string(valueJSON))
+}
+
+// node API responses are printed as they are with --json
+func (cmd *command) outputBody(body []byte, value interface{}, human func()) {
+	if *cmd.jsonOutput {
+		printJSON(body)
+		return
+	}
+	utility.ErrThenLogFatal(json.Unmarshal(body, value))
+	human()
+}
+
+func decodeHash(hexString string, what string) []byte {
+	hash, err := hex.DecodeString(hexString)
+	if err != nil || len(hash) == 0 {
+		log.Fatalf("bad %s %q: not a hex string", what, hexString)
+	}
+	return hash
+}
+
+func addressOfHash(pubKeyHash []byte) string {
+	if len(pubKeyHash) == 0 {
+		return ""
+	}
+	return wallet.AddressFromPubKeyHash(pubKeyHash)
+}
+
+func runNode(args []string) {
+	nodeFlags := flag.NewFlagSet("node run", flag.ExitOnError)
+	configPath := config.RegisterFlags(nodeFlags)
+	nodeFlags.Parse(args)
+
+	cfg, err := config.FromFlags(nodeFlags, *configPath)
+	utility.ErrThenLogFatal(err)
+	utility.ErrThenLogFatal(node.Run(cfg))
+}
+
+func loadWallet(walletFile string) *wallet.Wallet {
+	var wlt wallet.Wallet
+	err := wlt.LoadWalletFromFile(walletFile)
+	if err != nil {
+		log.Fatalf("could not load wallet %s: %v", walletFile, err)
+	}
+	return &wlt
+}
+
+type walletInfo struct {
+	File          string   `json:"file,omitempty"`
+	Address       string   `json:"address"`
+	PublicKeyHash string   `json:"public_key_hash,omitempty"`
+	PublicKey     string   `json:"public_key,omitempty"`
+	Credits       *int64   `json:"credits,omitempty"`
+	OwnedItems    []string `json:"owned_items,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+func walletNew(args []string) {
+	cmd := newCommand("wallet new")
+	walletFile := cmd.flagSet.String("file", "", "File to store the new wallet in, defaults to the wallet of the config")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	if *walletFile == "" {
+		*walletFile = cmd.config().Wallet
+	}
+	if _, err := os.Stat(*walletFile); err == nil {
+		log.Fatalf("wallet %s already exists, it is not overwritten", *walletFile)
+	}
+	wlt, err := wallet.NewWallet()
+	utility.ErrThenLogFatal(err)
+	utility.ErrThenLogFatal(wlt.SaveWalletToFile(*walletFile))
+
+	info := walletInfo{File: *walletFile, Address: string(wlt.Address)}
+	cmd.output(info, func() {
+		fmt.Printf("Wallet generated and saved to %s\n", info.File)
+		fmt.Printf("Your address is %s\n", info.Address)
+	})
+}
+
+func walletList(args []string) {
+	cmd := newCommand("wallet list")
+	walletDir := cmd.flagSet.String("dir", ".", "Directory to look for *.keystore wallet files in")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	walletFiles, err := filepath.Glob(filepath.Join(*walletDir, "*.keystore"))
+	utility.ErrThenLogFatal(err)
+
+	wallets := []walletInfo{}
+	for _, walletFile := range walletFiles {
+		var wlt wallet.Wallet
+		if err := wlt.LoadWalletFromFile(walletFile); err != nil {
+			wallets = append(wallets, walletInfo{File: walletFile, Error: err.Error()})
+			continue
+		}
+		wallets = append(wallets, walletInfo{File: walletFile, Address: string(wlt.Address)})
+	}
+	cmd.output(wallets, func() {
+		if len(wallets) == 0 {
+			fmt.Printf("No wallets in %s\n", *walletDir)
+		}
+		for _, info := range wallets {
+			if info.Error != "" {
+				fmt.Printf("%s\tcould not be read: %s\n", info.File, info.Error)
+			} else {
+				fmt.Printf("%s\t%s\n", info.File, info.Address)
+			}
+		}
+	})
+}
+
+// keys of the wallet file along with its credits and items on the chain
+func walletShow(args []string) {
+	cmd := newCommand("wallet show")
+	walletFile := cmd.flagSet.String("file", "", "Wallet file, defaults to the wallet of the config")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	if *walletFile == "" {
+		*walletFile = cmd.config().Wallet
+	}
+	wlt := loadWallet(*walletFile)
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+	utility.ErrThenLogFatal(err)
+	publicKey, err := wallet.PublicKeyToBytes(&wlt.PublicKey)
+	utility.ErrThenLogFatal(err)
+
+	source := cmd.source()
+	defer source.Close()
+	credits, err := source.Credits(string(wlt.Address))
+	utility.ErrThenLogFatal(err)
+	ownedItems, err := source.OwnedItems(string(wlt.Address))
+	utility.ErrThenLogFatal(err)
+
+	info := walletInfo{
+		File:          *walletFile,
+		Address:       string(wlt.Address),
+		PublicKeyHash: hex.EncodeToString(pubKeyHash),
+		PublicKey:     hex.EncodeToString(publicKey),
+		Credits:       &credits,
+		OwnedItems:    ownedItems,
+	}
+	cmd.output(info, func() {
+		fmt.Printf("File: %s\n", info.File)
+		fmt.Printf("Address: %s\n", info.Address)
+		fmt.Printf("Public Key Hash: %s\n", info.PublicKeyHash)
+		fmt.Printf("Credits: %d\n", credits)
+		fmt.Printf("Owned Items: %d\n", len(ownedItems))
+		for _, itemHash := range ownedItems {
+			fmt.Printf("\t%s\n", itemHash)
+		}
+	})
+}
+
+// address of the wallet file, or of the wallet of the node with --node
+func walletAddress(args []string) {
+	cmd := newCommand("wallet address")
+	walletFile := cmd.flagSet.String("file", "", "Wallet file, defaults to the wallet of the config")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	var info walletInfo
+	if *cmd.nodeURL != "" {
+		body, err := getFromNode(cmd.nodeAPI(), "/my-wallet/address")
+		utility.ErrThenLogFatal(err)
+		utility.ErrThenLogFatal(json.Unmarshal(body, &info))
+	} else {
+		if *walletFile == "" {
+			*walletFile = cmd.config().Wallet
+		}
+		info = walletInfo{File: *walletFile, Address: string(loadWallet(*walletFile).Address)}
+	}
+	cmd.output(info, func() {
+		fmt.Println(info.Address)
+	})
+}
+
+// blocks from the tip backwards
+func chainPrint(args []string) {
+	cmd := newCommand("chain print")
+	limit := cmd.flagSet.Uint64("limit", 0, "Number of blocks to print from the tip, every block if 0")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	source := cmd.source()
+	defer source.Close()
+	blocks, err := source.LastBlocks(*limit)
+	utility.ErrThenLogFatal(err)
+	cmd.output(blocks, func() {
+		for _, block := range blocks {
+			fmt.Println(block)
+		}
+	})
+}
+
+func chainHeight(args []string) {
+	cmd := newCommand("chain height")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	source := cmd.source()
+	defer source.Close()
+	height, err := source.Height()
+	utility.ErrThenLogFatal(err)
+	cmd.output(map[string]uint64{"height": height}, func() {
+		fmt.Println(height)
+	})
+}
+
+func chainBlock(args []string) {
+	cmd := newCommand("chain block")
+	positional := cmd.parse(args)
+	cmd.expectArgs(positional, "<hash|height>")
+
+	source := cmd.source()
+	defer source.Close()
+	block, err := source.Block(positional[0])
+	utility.ErrThenLogFatal(err)
+	cmd.output(block, func() {
+		fmt.Println(block)
+	})
+}
+
+// introduces an item with the wallet of the node, from an identifier or an object file
+func itemIntroduce(args []string) {
+	cmd := newCommand("item introduce")
+	itemID := cmd.flagSet.String("item", "", "Identifier of the item, hashed into the item hash")
+	objectFile := cmd.flagSet.String("obj", "", "Object file (csv, json or yaml) committed on the chain as the metadata of the item")
+	amount := cmd.flagSet.Uint64("amount", 0, "Amount recorded on the coinbase, defaults to the base price of the object")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	coinbaseData := map[string]interface{}{"amount": *amount}
+	switch {
+	case *objectFile != "":
+		var obj object.Object
+		utility.ErrThenLogFatal(obj.LoadObjectFile(*objectFile))
+		if *amount == 0 {
+			coinbaseData["amount"] = obj.BasePrice
+		}
+		coinbaseData["object"] = &obj
+	case *itemID != "":
+		coinbaseData["item_hash"] = *itemID
+	default:
+		log.Fatal("either --item or --obj is required")
+	}
+
+	body, err := postToNode(cmd.nodeAPI(), "/transaction/coinbase", coinbaseData)
+	utility.ErrThenLogFatal(err)
+	var tx blockchain.Tx
+	cmd.outputBody(body, &tx, func() {
+		fmt.Println(tx)
+	})
+}
+
+// transfers an item owned by the wallet of the node, optionally time locked
+func itemTransfer(args []string) {
+	cmd := newCommand("item transfer")
+	destination := cmd.flagSet.String("to", "", "Address of the buyer")
+	itemHash := cmd.flagSet.String("item", "", "Hash of the item")
+	amount := cmd.flagSet.Uint64("amount", 0, "Amount the item is sold for")
+	lockHeight := cmd.flagSet.Uint64("lock-height", 0, "Earliest block height the transfer can be mined at")
+	lockTime := cmd.flagSet.Uint64("lock-time", 0, "Earliest unix time the transfer can be mined at")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	decodeHash(*itemHash, "item hash")
+	txData := map[string]interface{}{
+		"destination": *destination,
+		"item_hash":   *itemHash,
+		"amount":      *amount,
+		"lock_height": *lockHeight,
+		"lock_time":   *lockTime,
+	}
+	body, err := postToNode(cmd.nodeAPI(), "/transaction/new", txData)
+	utility.ErrThenLogFatal(err)
+	var tx blockchain.Tx
+	cmd.outputBody(body, &tx, func() {
+		fmt.Println(tx)
+	})
+}
+
+// transactions with the item, latest first
+func itemHistory(args []string) {
+	cmd := newCommand("item history")
+	positional := cmd.parse(args)
+	cmd.expectArgs(positional, "<itemhash>")
+	itemHash := decodeHash(positional[0], "item hash")
+
+	source := cmd.source()
+	defer source.Close()
+	txs, err := source.ItemHistory(itemHash)
+	utility.ErrThenLogFatal(err)
+	if txs == nil {
+		txs = []*blockchain.Tx{}
+	}
+	cmd.output(txs, func() {
+		if len(txs) == 0 {
+			fmt.Printf("No transactions with item %x\n", itemHash)
+		}
+		for _, tx := range txs {
+			fmt.Println(tx)
+		}
+	})
+}
+
+func itemOwner(args []string) {
+	cmd := newCommand("item owner")
+	positional := cmd.parse(args)
+	cmd.expectArgs(positional, "<itemhash>")
+	itemHash := decodeHash(positional[0], "item hash")
+
+	source := cmd.source()
+	defer source.Close()
+	itemState, err := source.ItemState(itemHash)
+	utility.ErrThenLogFatal(err)
+
+	ownerInfo := map[string]interface{}{
+		"item_hash":     itemState.ItemHash,
+		"owner":         itemState.Owner,
+		"owner_address": addressOfHash(itemState.Owner),
+		"status":        itemState.Status,
+		"recalled":      itemState.Recalled,
+	}
+	cmd.output(ownerInfo, func() {
+		fmt.Printf("Owner: %s (%x)\n", addressOfHash(itemState.Owner), itemState.Owner)
+		fmt.Printf("Status: %s\n", itemState.Status)
+		if itemState.Recalled {
+			fmt.Printf("Recalled: %s\n", itemState.RecallNote)
+		}
+	})
+}
+
+// submits a transaction signed elsewhere, eg. with signtx
+func txSubmit(args []string) {
+	cmd := newCommand("tx submit")
+	txFile := cmd.flagSet.String("in", "signed_tx.json", "File containing the signed transaction")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	signedTxJSON, err := ioutil.ReadFile(*txFile)
+	utility.ErrThenLogFatal(err)
+	body, err := postToNode(cmd.nodeAPI(), "/transaction/submit", json.RawMessage(signedTxJSON))
+	utility.ErrThenLogFatal(err)
+	var tx blockchain.Tx
+	cmd.outputBody(body, &tx, func() {
+		fmt.Printf("Transaction %x accepted by the node\n", tx.TxID)
+	})
+}
+
+func txShow(args []string) {
+	cmd := newCommand("tx show")
+	positional := cmd.parse(args)
+	cmd.expectArgs(positional, "<txid>")
+	txID := decodeHash(positional[0], "transaction id")
+
+	source := cmd.source()
+	defer source.Close()
+	info, err := source.Tx(txID)
+	utility.ErrThenLogFatal(err)
+	cmd.output(info, func() {
+		if info.Status == "confirmed" {
+			fmt.Printf("Status: confirmed in block %x at height %d\n", info.BlockHash, info.Height)
+		} else {
+			fmt.Printf("Status: %s\n", info.Status)
+		}
+		fmt.Println(info.Tx)
+	})
+}
+
+type peerInfo struct {
+	Advertised string   `json:"advertised"`
+	NodeID     string   `json:"node_id"`
+	Encrypted  bool     `json:"encrypted"`
+	Peers      []string `json:"peers"`
+	Banned     []string `json:"banned"`
+	Removed    []string `json:"removed"`
+
+	AddressBook []p2p.PeerRecord           `json:"address_book"`
+	Versions    map[string]p2p.PeerVersion `json:"versions"`
+}
+
+func peersList(args []string) {
+	cmd := newCommand("peers list")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	body, err := getFromNode(cmd.nodeAPI(), "/peers")
+	utility.ErrThenLogFatal(err)
+	var peers peerInfo
+	cmd.outputBody(body, &peers, func() {
+		fmt.Printf("Advertised as %s\n", peers.Advertised)
+		if peers.Encrypted {
+			fmt.Printf("Node id %s, connections encrypted\n", peers.NodeID)
+		} else {
+			fmt.Printf("Node id %s, connections in plaintext\n", peers.NodeID)
+		}
+		if len(peers.Peers) == 0 {
+			fmt.Println("No known peers")
+		}
+		for _, peer := range peers.Peers {
+			version, ok := peers.Versions[peer]
+			if !ok {
+				fmt.Printf("%s, no handshake yet\n", peer)
+				continue
+			}
+			fmt.Printf("%s %s, protocol %d, height %d\n", peer, version.UserAgent, version.ProtocolVersion, version.Height)
+			if version.NodeID != "" {
+				fmt.Printf("    node id %s\n", version.NodeID)
+			}
+		}
+		if len(peers.Banned) != 0 {
+			fmt.Printf("Banned: %s\n", strings.Join(peers.Banned, ", "))
+		}
+		if len(peers.AddressBook) != 0 {
+			fmt.Printf("\nAddress book (%d):\n", len(peers.AddressBook))
+		}
+		for _, record := range peers.AddressBook {
+			lastSeen := "never"
+			if !record.LastSeen.IsZero() {
+				lastSeen = record.LastSeen.Format(time.RFC3339)
+			}
+			stale := ""
+			if record.Stale() {
+				stale = fmt.Sprintf(", stale after %d failures", record.Failures)
+			}
+			fmt.Printf("%s score %d, %s, seen %s%s\n", record.Address, record.Score, record.Source, lastSeen, stale)
+		}
+	})
+}
+
+func peersAdd(args []string) {
+	cmd := newCommand("peers add")
+	positional := cmd.parse(args)
+	cmd.expectArgs(positional, "<host:port>")
+
+	body, err := postToNode(cmd.nodeAPI(), "/peers/add", map[string]string{"address": positional[0]})
+	utility.ErrThenLogFatal(err)
+	var peers peerInfo
+	cmd.outputBody(body, &peers, func() {
+		fmt.Printf("Added %s, the node knows %d peers\n", positional[0], len(peers.Peers))
+	})
+}
+
+func peersBan(args []string) {
+	cmd := newCommand("peers ban")
+	positional := cmd.parse(args)
+	cmd.expectArgs(positional, "<host[:port]>")
+
+	body, err := postToNode(cmd.nodeAPI(), "/peers/ban", map[string]string{"address": positional[0]})
+	utility.ErrThenLogFatal(err)
+	var peers peerInfo
+	cmd.outputBody(body, &peers, func() {
+		fmt.Printf("Banned %s\n", positional[0])
+		for _, peer := range peers.Removed {
+			fmt.Printf("Removed peer %s\n", peer)
+		}
+	})
+}
+
+type validatorInfo struct {
+	Validators  []string                       `json:"validators"`
+	NextHeight  uint64                         `json:"next_height"`
+	InTurn      string                         `json:"in_turn"`
+	BlockPeriod uint64                         `json:"block_period"`
+	Proposals   []blockchain.ValidatorProposal `json:"proposals"`
+}
+
+func validatorsList(args []string) {
+	cmd := newCommand("validators list")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	body, err := getFromNode(cmd.nodeAPI(), "/validators")
+	utility.ErrThenLogFatal(err)
+	var validators validatorInfo
+	cmd.outputBody(body, &validators, func() {
+		for _, validator := range validators.Validators {
+			if validator == validators.InTurn {
+				fmt.Printf("%s, signs block %d\n", validator, validators.NextHeight)
+			} else {
+				fmt.Println(validator)
+			}
+		}
+		for _, proposal := range validators.Proposals {
+			change := "add"
+			if proposal.Remove {
+				change = "remove"
+			}
+			fmt.Printf("Proposal to %s %s, voted for by %s\n", change, proposal.Validator, strings.Join(proposal.Voters, ", "))
+		}
+	})
+}
+
+// the wallet of the node votes, so the node has to be a validator
+func validatorsVote(args []string) {
+	cmd := newCommand("validators vote")
+	remove := cmd.flagSet.Bool("remove", false, "Vote for removing the validator instead of adding it")
+	positional := cmd.parse(args)
+	cmd.expectArgs(positional, "<address>")
+
+	body, err := postToNode(cmd.nodeAPI(), "/validators/vote", map[string]interface{}{"validator": positional[0], "remove": *remove})
+	utility.ErrThenLogFatal(err)
+	var voteTx blockchain.Tx
+	cmd.outputBody(body, &voteTx, func() {
+		fmt.Printf("Voted with transaction %x to %s %s\n", voteTx.TxID, strings.TrimSuffix(blockchain.TxTypeName(voteTx.Type), "-validator"), positional[0])
+	})
+}
+
+// with --node the node mines its memory pool, otherwise empty blocks are mined into the
+// local database with the wallet of the config, eg. to earn introduction credits on regtest
+func mine(args []string) {
+	cmd := newCommand("mine")
+	walletFile := cmd.flagSet.String("wallet", "", "Wallet the local blocks are mined with, defaults to the wallet of the config")
+	blockCount := cmd.flagSet.Int("blocks", 1, "Number of empty blocks mined into the local database")
+	threads := cmd.flagSet.Int("threads", 0, "Goroutines searching for the proof of work, defaults to the mining threads of the config")
+	cmd.expectArgs(cmd.parse(args), "")
+
+	if *cmd.nodeURL != "" {
+		poolBody, err := getFromNode(cmd.nodeAPI(), "/transaction/pool")
+		utility.ErrThenLogFatal(err)
+		body, err := postToNode(cmd.nodeAPI(), "/block/mine", json.RawMessage(poolBody))
+		utility.ErrThenLogFatal(err)
+		var block blockchain.Block
+		cmd.outputBody(body, &block, func() {
+			fmt.Printf("Node mined block %x at height %d\n", block.BlockHash, block.Height)
+		})
+		return
+	}
+
+	cfg := cmd.config()
+	if *walletFile == "" {
+		*walletFile = cfg.Wallet
+	}
+	if *threads < 1 {
+		*threads = cfg.Mining.Threads
+	}
+	wlt := loadWallet(*walletFile)
+	// unlike the read commands, mining starts a chain database that does not exist yet
+	params := cmd.params()
+	utility.ErrThenLogFatal(os.MkdirAll(filepath.Dir(params.DBPath), 0755))
+	chain, err := blockchain.OpenBlockChain(params)
+	utility.ErrThenLogFatal(err)
+	defer chain.Database.Close()
+
+	// ctrl-c stops mining but still closes the database
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	blocks := []*blockchain.Block{}
+	for i := 0; i < *blockCount && ctx.Err() == nil; i++ {
+		newBlock := blockchain.CreateBlock()
+		if err := newBlock.MineBlockWithThreads(ctx, chain, wlt, *threads); err != nil {
+			utility.Errorf("Mining stopped: %v", err)
+			break
+		}
+		if err := chain.AddBlock(newBlock); err != nil {
+			utility.Errorf("Mined block was refused: %v", err)
+			break
+		}
+		blocks = append(blocks, newBlock)
+	}
+	cmd.output(blocks, func() {
+		for _, block := range blocks {
+			fmt.Printf("Mined block %x at height %d\n", block.BlockHash, block.Height)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+)
+
+// where the read commands get the chain from, the database of a stopped node or the api of a running one
+type chainSource interface {
+	Height() (uint64, error)
+	LastBlocks(n uint64) ([]*blockchain.Block, error) // n == 0 returns every block
+	Block(id string) (*blockchain.Block, error)       // hex hash or height
+	Tx(txID []byte) (*txInfo, error)
+	ItemHistory(itemHash []byte) ([]*blockchain.Tx, error)
+	ItemState(itemHash []byte) (*blockchain.ItemState, error)
+	Credits(address string) (int64, error)
+	OwnedItems(address string) ([]string, error)
+	Close()
+}
+
+// a transaction along with where it is, a block or one of the pools of the node
+type txInfo struct {
+	Tx        blockchain.Tx   `json:"tx"`
+	Status    string          `json:"status"` // confirmed, or pending, offer and locked while pooled
+	BlockHash utility.HexByte `json:"block_hash,omitempty"`
+	Height    uint64          `json:"height,omitempty"`
+}
+
+type localSource struct {
+	chain *blockchain.BlockChain
+}
+
+// opens the database of the network, a node running on it holds the database lock so --node has to be used then
+func openLocalSource(params *blockchain.ChainParams) (*localSource, error) {
+	if _, err := os.Stat(params.DBPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no %s chain database at %s, start a node first or use --node", params.Name, params.DBPath)
+	}
+	chain, err := blockchain.OpenBlockChain(params)
+	if err != nil {
+		return nil, fmt.Errorf("%v, use --node if a node is running on it", err)
+	}
+	return &localSource{chain: chain}, nil
+}
+
+func (source *localSource) Height() (uint64, error) {
+	return source.chain.GetChainHeight()
+}
+
+func (source *localSource) LastBlocks(n uint64) ([]*blockchain.Block, error) {
+	if n == 0 {
+		height, err := source.chain.GetChainHeight()
+		if err != nil {
+			return nil, err
+		}
+		n = height + 1
+	}
+	return source.chain.GetLastNBlocks(n), nil
+}
+
+func (source *localSource) Block(id string) (*blockchain.Block, error) {
+	return source.chain.BlockByID(id)
+}
+
+// pools only exist in a running node, so a local lookup only finds mined transactions
+func (source *localSource) Tx(txID []byte) (*txInfo, error) {
+	tx, block, err := source.chain.FindTx(txID)
+	if err != nil {
+		return nil, err
+	}
+	return &txInfo{Tx: *tx, Status: "confirmed", BlockHash: block.BlockHash, Height: block.Height}, nil
+}
+
+func (source *localSource) ItemHistory(itemHash []byte) ([]*blockchain.Tx, error) {
+	return source.chain.TxsIncludingItem(itemHash), nil
+}
+
+func (source *localSource) ItemState(itemHash []byte) (*blockchain.ItemState, error) {
+	return source.chain.ItemState(itemHash)
+}
+
+func (source *localSource) Credits(address string) (int64, error) {
+	return source.chain.WalletCredits(address)
+}
+
+func (source *localSource) OwnedItems(address string) ([]string, error) {
+	return source.chain.WalletOwnedItems(address)
+}
+
+func (source *localSource) Close() {
+	source.chain.Database.Close()
+}
+
+type apiSource struct {
+	nodeURL string
+}
+
+func (source *apiSource) get(path string, value interface{}) error {
+	body, err := getFromNode(source.nodeURL, path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, value)
+}
+
+func (source *apiSource) Height() (uint64, error) {
+	var lastBlock blockchain.Block
+	if err := source.get("/block/last", &lastBlock); err != nil {
+		return 0, err
+	}
+	return lastBlock.Height, nil
+}
+
+func (source *apiSource) LastBlocks(n uint64) ([]*blockchain.Block, error) {
+	if n == 0 {
+		height, err := source.Height()
+		if err != nil {
+			return nil, err
+		}
+		n = height + 1
+	}
+	var blocks []*blockchain.Block
+	err := source.get(fmt.Sprintf("/block/last/%d", n), &blocks)
+	return blocks, err
+}
+
+func (source *apiSource) Block(id string) (*blockchain.Block, error) {
+	var block blockchain.Block
+	if err := source.get("/block/"+url.PathEscape(id), &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (source *apiSource) Tx(txID []byte) (*txInfo, error) {
+	var info txInfo
+	if err := source.get("/transaction/"+hex.EncodeToString(txID), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (source *apiSource) ItemHistory(itemHash []byte) ([]*blockchain.Tx, error) {
+	var txs []*blockchain.Tx
+	err := source.get("/item/history/"+hex.EncodeToString(itemHash), &txs)
+	return txs, err
+}
+
+func (source *apiSource) ItemState(itemHash []byte) (*blockchain.ItemState, error) {
+	var itemState blockchain.ItemState
+	if err := source.get("/item/state/"+hex.EncodeToString(itemHash), &itemState); err != nil {
+		return nil, err
+	}
+	return &itemState, nil
+}
+
+func (source *apiSource) Credits(address string) (int64, error) {
+	var creditInfo struct {
+		Credits int64 `json:"credits"`
+	}
+	err := source.get("/wallet/"+url.PathEscape(address)+"/credits", &creditInfo)
+	return creditInfo.Credits, err
+}
+
+func (source *apiSource) OwnedItems(address string) ([]string, error) {
+	var walletInfo struct {
+		OwnedItems []string `json:"owned_items"`
+	}
+	err := source.get("/wallet/items/"+url.PathEscape(address), &walletInfo)
+	return walletInfo.OwnedItems, err
+}
+
+func (source *apiSource) Close() {}
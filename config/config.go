@@ -0,0 +1,349 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/object"
+	"github.com/pranjalpokharel7/yudhishthira/p2p"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ENV_PREFIX         = "YUDHISHTHIRA_" // environment variables override the config file, eg. YUDHISHTHIRA_DATA_DIR
+	DEFAULT_CONFIG     = "yudhishthira.yaml"
+	DB_DIR_NAME        = "db"
+	KNOWN_NODES_FILE   = "./p2p/known_nodes.json" // seed peers used when none are configured
+	DEFAULT_MINE_DELAY = 5                        // seconds between checks of the memory pool by the miner
+)
+
+type APIConfig struct {
+	Listen    string `yaml:"listen"`     // host:port, the port defaults to the one of the network
+	AuthToken string `yaml:"auth_token"` // if set, every POST request and token signing need "Authorization: Bearer <token>"
+	Schemas   string `yaml:"schemas"`    // directory of the category schemas objects are validated against, the node does not start if it can not be loaded
+}
+
+type P2PConfig struct {
+	Listen    string   `yaml:"listen"`    // host:port, an empty host, 0.0.0.0 or [::] listen on every interface
+	Advertise string   `yaml:"advertise"` // host:port peers reach this node at, eg. the public address of a NAT router
+	SeedPeers []string `yaml:"seed_peers"`
+	Outbound  int      `yaml:"outbound_peers"` // peers messages are relayed to, picked from the address book
+	Encrypt   bool     `yaml:"encrypt"`        // TLS between peers, authenticated with the node keys, every node of the network has to set it
+	NodeKey   string   `yaml:"node_key"`       // ed25519 key identifying the node, generated on first start, defaults to <data_dir>/db.nodekey
+	AllowList []string `yaml:"allowlist"`      // node ids allowed to connect, any node if empty, needs encrypt
+}
+
+type MiningConfig struct {
+	Enabled bool `yaml:"enabled"` // mine the memory pool in the background
+	Threads int  `yaml:"threads"` // goroutines searching for the proof of work nonce
+	Delay   int  `yaml:"delay"`   // seconds between checks of the memory pool
+}
+
+// everything a node needs to start, see yudhishthira.example.yaml
+// values are taken from the defaults, the config file, the environment and flags, later ones winning
+type Config struct {
+	Network  string       `yaml:"network"`  // main, testnet, regtest or consortium
+	DataDir  string       `yaml:"data_dir"` // the chain database lives in <data_dir>/db, defaults to the path of the network
+	Wallet   string       `yaml:"wallet"`   // keystore of the node wallet, generated on first start if missing
+	LogLevel string       `yaml:"log_level"`
+	API      APIConfig    `yaml:"api"`
+	P2P      P2PConfig    `yaml:"p2p"`
+	Mining   MiningConfig `yaml:"mining"`
+	// proof of authority networks only, wallet addresses of the first validators, the same on every node of the network
+	Validators []string `yaml:"validators"`
+}
+
+func Default() *Config {
+	return &Config{
+		Network:  blockchain.MainNetParams.Name,
+		Wallet:   wallet.WALLET_FILE,
+		LogLevel: utility.LOG_INFO,
+		API:      APIConfig{Schemas: object.SCHEMA_DIR},
+		P2P:      P2PConfig{Outbound: p2p.DEFAULT_OUTBOUND_PEERS},
+		Mining:   MiningConfig{Threads: 1, Delay: DEFAULT_MINE_DELAY},
+	}
+}
+
+// merges the yaml file into the config, fields missing from the file keep their value
+func (cfg *Config) LoadFile(configPath string) error {
+	fileContent, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(fileContent, cfg); err != nil {
+		return fmt.Errorf("%s: %v", configPath, err)
+	}
+	return nil
+}
+
+// environment variable name of every setting, in the order they are documented
+var envSettings = []string{"NETWORK", "DATA_DIR", "WALLET", "LOG_LEVEL", "API_LISTEN", "API_TOKEN", "API_SCHEMAS", "P2P_LISTEN", "P2P_ADVERTISE", "SEED_PEERS", "OUTBOUND_PEERS", "P2P_ENCRYPT", "NODE_KEY", "P2P_ALLOWLIST", "MINE", "MINE_THREADS", "VALIDATORS"}
+
+func (cfg *Config) set(setting string, value string) error {
+	var err error
+	switch setting {
+	case "NETWORK":
+		cfg.Network = value
+	case "DATA_DIR":
+		cfg.DataDir = value
+	case "WALLET":
+		cfg.Wallet = value
+	case "LOG_LEVEL":
+		cfg.LogLevel = value
+	case "API_LISTEN":
+		cfg.API.Listen = value
+	case "API_TOKEN":
+		cfg.API.AuthToken = value
+	case "API_SCHEMAS":
+		cfg.API.Schemas = value
+	case "P2P_LISTEN":
+		cfg.P2P.Listen = value
+	case "P2P_ADVERTISE":
+		cfg.P2P.Advertise = value
+	case "SEED_PEERS":
+		cfg.P2P.SeedPeers = splitList(value)
+	case "OUTBOUND_PEERS":
+		cfg.P2P.Outbound, err = strconv.Atoi(value)
+	case "P2P_ENCRYPT":
+		cfg.P2P.Encrypt, err = strconv.ParseBool(value)
+	case "NODE_KEY":
+		cfg.P2P.NodeKey = value
+	case "P2P_ALLOWLIST":
+		cfg.P2P.AllowList = splitList(value)
+	case "MINE":
+		cfg.Mining.Enabled, err = strconv.ParseBool(value)
+	case "MINE_THREADS":
+		cfg.Mining.Threads, err = strconv.Atoi(value)
+	case "VALIDATORS":
+		cfg.Validators = splitList(value)
+	default:
+		return fmt.Errorf("unknown setting %s", setting)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", setting, err)
+	}
+	return nil
+}
+
+// comma separated values, blanks dropped
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (cfg *Config) ApplyEnv() error {
+	for _, setting := range envSettings {
+		if value, ok := os.LookupEnv(ENV_PREFIX + setting); ok {
+			if err := cfg.set(setting, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flag names of the settings, flags are only applied when given on the command line
+var flagSettings = map[string]string{
+	"network":        "NETWORK",
+	"data-dir":       "DATA_DIR",
+	"wallet":         "WALLET",
+	"log-level":      "LOG_LEVEL",
+	"api-listen":     "API_LISTEN",
+	"api-token":      "API_TOKEN",
+	"api-schemas":    "API_SCHEMAS",
+	"p2p-listen":     "P2P_LISTEN",
+	"p2p-advertise":  "P2P_ADVERTISE",
+	"seed-peers":     "SEED_PEERS",
+	"outbound-peers": "OUTBOUND_PEERS",
+	"p2p-encrypt":    "P2P_ENCRYPT",
+	"node-key":       "NODE_KEY",
+	"p2p-allowlist":  "P2P_ALLOWLIST",
+	"mine":           "MINE",
+	"mine-threads":   "MINE_THREADS",
+	"validators":     "VALIDATORS",
+}
+
+// registers --config and one flag per setting on the flag set
+func RegisterFlags(flagSet *flag.FlagSet) *string {
+	configPath := flagSet.String("config", "", fmt.Sprintf("YAML config file, %s is used if it exists", DEFAULT_CONFIG))
+	flagSet.String("network", "", fmt.Sprintf("Network to join, one of %v", blockchain.NetworkNames()))
+	flagSet.String("data-dir", "", "Directory the chain database is stored in")
+	flagSet.String("wallet", "", "Keystore of the node wallet")
+	flagSet.String("log-level", "", "One of debug, info, warn or error")
+	flagSet.String("api-listen", "", "host:port the API listens on")
+	flagSet.String("api-token", "", "Bearer token required by POST requests to the API")
+	flagSet.String("api-schemas", "", "Directory of the category schemas objects are validated against")
+	flagSet.String("p2p-listen", "", "host:port the p2p server listens on, use 0.0.0.0 or [::] for every interface")
+	flagSet.String("p2p-advertise", "", "host:port peers connect to, if it differs from the listen address (NAT, port forwarding)")
+	flagSet.String("seed-peers", "", "Comma separated host:port of peers to connect to on start")
+	flagSet.String("outbound-peers", "", "Number of peers messages are relayed to")
+	flagSet.String("p2p-encrypt", "", "Encrypt peer connections with TLS authenticated by the node keys (true or false)")
+	flagSet.String("node-key", "", "File of the ed25519 key identifying the node, generated if missing")
+	flagSet.String("p2p-allowlist", "", "Comma separated node ids allowed to connect, needs --p2p-encrypt")
+	flagSet.String("mine", "", "Mine the memory pool in the background (true or false)")
+	flagSet.String("mine-threads", "", "Number of goroutines searching for the proof of work")
+	flagSet.String("validators", "", "Comma separated wallet addresses of the first validators, proof of authority networks only")
+	return configPath
+}
+
+// defaults overridden by the config file and the environment, an empty path falls back to YUDHISHTHIRA_CONFIG and then DEFAULT_CONFIG
+func Load(configPath string) (*Config, error) {
+	cfg := Default()
+
+	if configPath == "" {
+		configPath = os.Getenv(ENV_PREFIX + "CONFIG")
+	}
+	if configPath == "" {
+		if _, err := os.Stat(DEFAULT_CONFIG); err == nil {
+			configPath = DEFAULT_CONFIG
+		}
+	}
+	if configPath != "" {
+		if err := cfg.LoadFile(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// builds the config of a node from the parsed flag set, see RegisterFlags
+func FromFlags(flagSet *flag.FlagSet, configPath string) (*Config, error) {
+	cfg, err := Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var flagErr error
+	flagSet.Visit(func(f *flag.Flag) {
+		if setting, ok := flagSettings[f.Name]; ok && flagErr == nil {
+			flagErr = cfg.set(setting, f.Value.String())
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+	return cfg, cfg.Validate()
+}
+
+func (cfg *Config) Validate() error {
+	params, err := cfg.ChainParams()
+	if err != nil {
+		return err
+	}
+	if err := params.CheckConsensus(); err != nil {
+		return err
+	}
+	if !utility.IsLogLevel(cfg.LogLevel) {
+		return fmt.Errorf("unknown log level %q", cfg.LogLevel)
+	}
+	if cfg.Wallet == "" {
+		return errors.New("a wallet file is required")
+	}
+	if cfg.API.Schemas == "" {
+		return errors.New("a category schema directory is required")
+	}
+	if cfg.P2P.Advertise != "" {
+		host, _, err := net.SplitHostPort(withDefaultPort(cfg.P2P.Advertise, "0"))
+		if err != nil {
+			return fmt.Errorf("bad p2p advertise address: %v", err)
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			return fmt.Errorf("p2p advertise address %q needs a host peers can reach", cfg.P2P.Advertise)
+		}
+	}
+	if cfg.P2P.Outbound < 1 {
+		return errors.New("at least one outbound peer is needed")
+	}
+	if len(cfg.P2P.AllowList) != 0 && !cfg.P2P.Encrypt {
+		return errors.New("the p2p allowlist needs encrypted peer connections, peers can not prove their node id otherwise")
+	}
+	for _, nodeID := range cfg.P2P.AllowList {
+		if _, err := p2p.ParseNodeID(nodeID); err != nil {
+			return fmt.Errorf("p2p allowlist: %v", err)
+		}
+	}
+	if cfg.Mining.Threads < 1 {
+		return errors.New("mining needs at least one thread")
+	}
+	if cfg.Mining.Delay < 1 {
+		return errors.New("the mining delay is at least one second")
+	}
+	return nil
+}
+
+// parameters of the configured network, with the database moved into the data dir if one is set
+// configured validators replace the ones of the profile, they are part of the chain params id
+func (cfg *Config) ChainParams() (*blockchain.ChainParams, error) {
+	params, err := blockchain.ParamsForNetwork(cfg.Network)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Validators) != 0 {
+		params.Validators = cfg.Validators
+	}
+	if cfg.DataDir != "" {
+		params.DBPath = filepath.Join(cfg.DataDir, DB_DIR_NAME)
+	}
+	return params, nil
+}
+
+// address the API listens on, the port of the network is used if none is given
+func (cfg *Config) APIListenAddress(params *blockchain.ChainParams) string {
+	return withDefaultPort(cfg.API.Listen, params.APIPort)
+}
+
+func (cfg *Config) P2PListenAddress(params *blockchain.ChainParams) string {
+	return withDefaultPort(cfg.P2P.Listen, params.P2PPort)
+}
+
+// address peers are told to connect to, in order of preference the configured one (with the listen port if it has none),
+// the listen address if it names a host, and the detected address of this machine with the listen port
+func (cfg *Config) P2PAdvertiseAddress(params *blockchain.ChainParams) (string, error) {
+	listenAddress := cfg.P2PListenAddress(params)
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return "", fmt.Errorf("bad p2p listen address: %v", err)
+	}
+	if cfg.P2P.Advertise != "" {
+		return withDefaultPort(cfg.P2P.Advertise, port), nil
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return listenAddress, nil
+	}
+
+	detectedHost, err := utility.GetNodeAddress()
+	if err != nil {
+		return "", fmt.Errorf("could not detect the address to advertise, set p2p.advertise: %v", err)
+	}
+	return net.JoinHostPort(detectedHost, port), nil
+}
+
+// adds the port to an address without one, IPv6 hosts can be given with or without brackets
+func withDefaultPort(address string, port string) string {
+	if address == "" {
+		return ":" + port
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), port)
+}
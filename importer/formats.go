@@ -0,0 +1,197 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pranjalpokharel7/yudhishthira/object"
+	"gopkg.in/yaml.v2"
+)
+
+// supported input formats
+const (
+	FORMAT_CSV        = "csv"
+	FORMAT_JSON_LINES = "jsonl"
+	FORMAT_MANIFEST   = "manifest"
+)
+
+// single item of an import, rows that could not be parsed keep the error and are reported later
+type Row struct {
+	Line   int // line in csv/jsonl files, position in the item list for manifests
+	Object object.Object
+	Amount uint64 // amount recorded on the coinbase, defaults to the base price
+	Err    error
+}
+
+// a manifest describes a whole shipment, defaults apply to every item that leaves the field empty
+type Manifest struct {
+	Defaults object.Object  `json:"defaults" yaml:"defaults"`
+	Items    []ManifestItem `json:"items" yaml:"items"`
+}
+
+type ManifestItem struct {
+	object.Object `yaml:",inline"`
+	Amount        uint64 `json:"amount" yaml:"amount"`
+}
+
+// guesses the format from the file extension, manifests are json or yaml documents
+func FormatFromFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jsonl", ".ndjson":
+		return FORMAT_JSON_LINES
+	case ".json", ".yaml", ".yml":
+		return FORMAT_MANIFEST
+	default:
+		return FORMAT_CSV
+	}
+}
+
+func Parse(format string, reader io.Reader) ([]Row, error) {
+	switch format {
+	case FORMAT_CSV:
+		return ParseCSV(reader)
+	case FORMAT_JSON_LINES:
+		return ParseJSONLines(reader)
+	case FORMAT_MANIFEST:
+		return ParseManifest(reader)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+func rowAmount(objectInstance *object.Object, amount uint64) uint64 {
+	if amount == 0 {
+		return objectInstance.BasePrice
+	}
+	return amount
+}
+
+// every row after the header is an item, an optional "amount" column sets the coinbase amount
+func ParseCSV(reader io.Reader) ([]Row, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1 // column count is checked per row so one bad row does not fail the import
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+	amountColumn := -1
+	var objectHeader []string
+	for i, column := range header {
+		if strings.ToLower(strings.TrimSpace(column)) == "amount" {
+			amountColumn = i
+			continue
+		}
+		objectHeader = append(objectHeader, column)
+	}
+
+	var rows []Row
+	for line := 2; ; line++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		row := Row{Line: line}
+		if err != nil {
+			row.Err = err
+			rows = append(rows, row)
+			continue
+		}
+		if len(record) != len(header) {
+			row.Err = fmt.Errorf("expected %d columns, found %d", len(header), len(record))
+			rows = append(rows, row)
+			continue
+		}
+
+		var objectRecord []string
+		for i, value := range record {
+			if i == amountColumn {
+				if strings.TrimSpace(value) == "" {
+					continue
+				}
+				row.Amount, err = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+				if err != nil {
+					row.Err = fmt.Errorf("invalid amount %q", value)
+				}
+				continue
+			}
+			objectRecord = append(objectRecord, value)
+		}
+		if row.Err == nil {
+			row.Err = row.Object.LoadCSVRecord(objectHeader, objectRecord)
+		}
+		row.Amount = rowAmount(&row.Object, row.Amount)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// one json object per line, same fields as the object json plus an optional amount
+func ParseJSONLines(reader io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []Row
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var item struct {
+			object.Object
+			Amount uint64 `json:"amount"`
+		}
+		row := Row{Line: line}
+		row.Err = json.Unmarshal([]byte(text), &item)
+		row.Object = item.Object
+		row.Amount = rowAmount(&row.Object, item.Amount)
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// json or yaml document, see Manifest
+func ParseManifest(reader io.Reader) ([]Row, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := yaml.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+	for i, item := range manifest.Items {
+		objectInstance := item.Object
+		if objectInstance.Brand == "" {
+			objectInstance.Brand = manifest.Defaults.Brand
+		}
+		if objectInstance.Category == "" {
+			objectInstance.Category = manifest.Defaults.Category
+		}
+		if objectInstance.BasePrice == 0 {
+			objectInstance.BasePrice = manifest.Defaults.BasePrice
+		}
+		for name, value := range manifest.Defaults.Attributes {
+			if _, present := objectInstance.Attributes[name]; !present {
+				if objectInstance.Attributes == nil {
+					objectInstance.Attributes = make(map[string]string)
+				}
+				objectInstance.Attributes[name] = value
+			}
+		}
+		rows = append(rows, Row{
+			Line:   i + 1,
+			Object: objectInstance,
+			Amount: rowAmount(&objectInstance, item.Amount),
+		})
+	}
+	return rows, nil
+}
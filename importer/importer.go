@@ -0,0 +1,219 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/object"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+const DEFAULT_BATCH_SIZE = 100
+
+// outcome of a single row
+const (
+	STATUS_INTRODUCED     = "introduced"
+	STATUS_INVALID        = "invalid"
+	STATUS_DUPLICATE_FILE = "duplicate-in-file"
+	STATUS_DUPLICATE      = "duplicate-in-chain"
+	STATUS_NO_FUNDS       = "insufficient-funds"
+	STATUS_FAILED         = "failed"
+)
+
+type RowResult struct {
+	Line             int    `json:"line"`
+	UniquePhysicalID string `json:"unique_physical_id"`
+	Category         string `json:"category"`
+	ItemHash         string `json:"item_hash,omitempty"`
+	TxID             string `json:"tx_id,omitempty"`
+	Batch            int    `json:"batch,omitempty"`
+	Status           string `json:"status"`
+	Error            string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Total      int         `json:"total"`
+	Introduced int         `json:"introduced"`
+	Rejected   int         `json:"rejected"`
+	Allowance  int         `json:"allowance"` // coinbase transactions the wallet could still make when the import started
+	Rows       []RowResult `json:"rows"`
+}
+
+type Options struct {
+	BatchSize int
+	// coinbase transactions of the wallet already waiting in the memory pool, they use up funds as well
+	PendingCoinbases int
+	// hands a batch of signed coinbase transactions to the node, returns one error (or nil) per transaction
+	// chain items is the set the rows were checked against, so the node does not have to scan the chain per transaction
+	SubmitBatch func(txs []blockchain.Tx, chainItems map[string]bool) []error
+}
+
+// validates every row, skips duplicates, and introduces the rest as coinbase transactions in batches
+// rows beyond what the wallet's mining funds allow are reported instead of being introduced
+func Import(rows []Row, schemas object.SchemaRegistry, wlt *wallet.Wallet, chain *blockchain.BlockChain, opts Options) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DEFAULT_BATCH_SIZE
+	}
+
+	allowance, err := blockchain.CoinbaseAllowance(string(wlt.Address), chain)
+	if err != nil {
+		return nil, err
+	}
+	allowance -= opts.PendingCoinbases
+
+	report := &Report{Total: len(rows), Allowance: allowance}
+	chainItems := chain.AllItemHashes()
+	fileItems := make(map[string]int) // item hash -> line it first appeared on
+	filePhysicalIDs := make(map[string]int)
+
+	type candidate struct {
+		result   *RowResult
+		row      *Row
+		itemHash []byte
+	}
+	var candidates []candidate
+
+	report.Rows = make([]RowResult, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		result := &report.Rows[i]
+		result.Line = row.Line
+		result.UniquePhysicalID = row.Object.UniquePhysicalID
+		result.Category = row.Object.Category
+
+		if row.Err != nil {
+			result.Status, result.Error = STATUS_INVALID, row.Err.Error()
+			continue
+		}
+		if err := schemas.Validate(&row.Object); err != nil {
+			result.Status, result.Error = STATUS_INVALID, err.Error()
+			continue
+		}
+		if row.Amount == 0 {
+			result.Status, result.Error = STATUS_INVALID, "amount or base price is required"
+			continue
+		}
+		result.Category = row.Object.Category
+
+		itemHash := row.Object.HashObject()
+		itemHashHex := hex.EncodeToString(itemHash)
+		result.ItemHash = itemHashHex
+
+		physicalIDKey := strings.ToLower(row.Object.Category) + "/" + row.Object.UniquePhysicalID
+		if firstLine, seen := fileItems[itemHashHex]; seen {
+			result.Status, result.Error = STATUS_DUPLICATE_FILE, fmt.Sprintf("same item as line %d", firstLine)
+			continue
+		}
+		if firstLine, seen := filePhysicalIDs[physicalIDKey]; seen {
+			result.Status, result.Error = STATUS_DUPLICATE_FILE, fmt.Sprintf("same unique physical id as line %d", firstLine)
+			continue
+		}
+		fileItems[itemHashHex] = row.Line
+		filePhysicalIDs[physicalIDKey] = row.Line
+
+		// items introduced before the hash scheme changed are only found under their legacy hash
+		legacyItemHash, _ := row.Object.HashObjectVersion(object.HASH_VERSION_LEGACY)
+		if chainItems[itemHashHex] || chainItems[hex.EncodeToString(legacyItemHash)] {
+			result.Status, result.Error = STATUS_DUPLICATE, "item already exists in the chain"
+			continue
+		}
+		candidates = append(candidates, candidate{result: result, row: row, itemHash: itemHash})
+	}
+
+	for start, batch := 0, 1; start < len(candidates); start, batch = start+opts.BatchSize, batch+1 {
+		end := start + opts.BatchSize
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+
+		var batchTxs []blockchain.Tx
+		var batchResults []*RowResult
+		for _, c := range candidates[start:end] {
+			c.result.Batch = batch
+			if allowance <= 0 {
+				c.result.Status, c.result.Error = STATUS_NO_FUNDS, "wallet has no funds left for introducing items, mine more blocks"
+				continue
+			}
+
+			metadataBlob, err := c.row.Object.MetadataBlob()
+			if err != nil {
+				c.result.Status, c.result.Error = STATUS_FAILED, err.Error()
+				continue
+			}
+			metadataHash, err := chain.StoreMetadata(metadataBlob)
+			if err != nil {
+				c.result.Status, c.result.Error = STATUS_FAILED, err.Error()
+				continue
+			}
+			// the item was checked against the chain items and the allowance covers the credits, no need to look again per row
+			coinbaseTx, err := blockchain.NewCoinbaseTx(wlt, c.itemHash, metadataHash, object.CURRENT_HASH_VERSION, c.row.Amount)
+			if err != nil {
+				c.result.Status, c.result.Error = STATUS_FAILED, err.Error()
+				continue
+			}
+			allowance--
+			c.result.TxID = hex.EncodeToString(coinbaseTx.TxID)
+			batchTxs = append(batchTxs, *coinbaseTx)
+			batchResults = append(batchResults, c.result)
+		}
+		if len(batchTxs) == 0 {
+			continue
+		}
+
+		submitErrors := make([]error, len(batchTxs))
+		if opts.SubmitBatch != nil {
+			submitErrors = opts.SubmitBatch(batchTxs, chainItems)
+		}
+		for i, result := range batchResults {
+			if i < len(submitErrors) && submitErrors[i] != nil {
+				result.Status, result.Error = STATUS_FAILED, submitErrors[i].Error()
+				continue
+			}
+			result.Status = STATUS_INTRODUCED
+		}
+	}
+
+	for _, result := range report.Rows {
+		if result.Status == STATUS_INTRODUCED {
+			report.Introduced++
+		} else {
+			report.Rejected++
+		}
+	}
+	return report, nil
+}
+
+// per row report as csv, one line per input row
+func (report *Report) WriteCSV(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{"line", "unique_physical_id", "category", "item_hash", "tx_id", "batch", "status", "error"})
+	if err != nil {
+		return err
+	}
+	for _, result := range report.Rows {
+		batch := ""
+		if result.Batch != 0 {
+			batch = strconv.Itoa(result.Batch)
+		}
+		err := csvWriter.Write([]string{
+			strconv.Itoa(result.Line),
+			result.UniquePhysicalID,
+			result.Category,
+			result.ItemHash,
+			result.TxID,
+			batch,
+			result.Status,
+			result.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -1,40 +1,18 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"os"
 
 	"github.com/joho/godotenv"
-	"github.com/pranjalpokharel7/yudhishthira/api"
-	"github.com/pranjalpokharel7/yudhishthira/blockchain"
-	"github.com/pranjalpokharel7/yudhishthira/wallet"
+	"github.com/pranjalpokharel7/yudhishthira/cli"
 )
 
-// D1Ji4eMTc9nDRfVrZFHg7FRcbueJyKqFG
-
 func main() {
-	fmt.Println("This is where it begins...")
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// .env is optional, its variables count as environment variables of the node config
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	var wlt1 wallet.Wallet
-	var wlt0 wallet.Wallet
-	wlt1.LoadWalletFromFile("./mykeys.keystore")
-	wlt0.LoadWalletFromFile("./k0.keystore")
-	fmt.Println(string(wlt1.Address))
-	fmt.Println(string(wlt0.Address))
-
-	// pubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt1.Address))
-	// utility.ErrThenPanic(err)
-	// fmt.Printf("%x", pubKeyHash)
-
-	// cli.RunCLI()
-
-	chain := blockchain.InitBlockChain()
-	chain.PrintChain()
-
-	api.StartServer(&wlt1, chain)
+	cli.RunCLI()
 }
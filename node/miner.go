@@ -0,0 +1,130 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/config"
+	"github.com/pranjalpokharel7/yudhishthira/p2p"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+// mines the memory pool into a block, nil if the pool is empty
+// cancelling the context abandons the block, its transactions stay in the pool
+func MinePool(ctx context.Context, chain *blockchain.BlockChain, wlt *wallet.Wallet, threads int) (*blockchain.Block, error) {
+	// transactions whose time lock expired are part of the pool by now
+	p2p.PromoteMaturedTxs(chain)
+	// blocks from peers can make pooled transactions stale, those are dropped instead of failing the block
+	var txPool, staleTxs []blockchain.Tx
+	for _, tx := range p2p.MemoryPoolTxs() {
+		if rejection := blockchain.ValidateTransaction(&tx, chain); rejection != nil {
+			utility.Warnf("Dropping transaction %x from the memory pool: %v", tx.TxID, rejection)
+			staleTxs = append(staleTxs, tx)
+			continue
+		}
+		txPool = append(txPool, tx)
+	}
+	p2p.RemoveFromMemoryPool(staleTxs)
+	txPool, err := withinCredits(txPool, chain, wlt)
+	if err != nil {
+		return nil, err
+	}
+	if len(txPool) == 0 {
+		return nil, nil
+	}
+
+	newBlock := blockchain.CreateBlock()
+	if err := newBlock.AddTransactionsToBlock(txPool); err != nil {
+		return nil, err
+	}
+	if err := newBlock.MineBlockWithThreads(ctx, chain, wlt, threads); err != nil {
+		return nil, err
+	}
+	if err := chain.AddBlock(newBlock); err != nil {
+		return nil, err
+	}
+	p2p.RemoveFromMemoryPool(txPool)
+	return newBlock, nil
+}
+
+// transactions were validated against the balance of their payer one by one, together they can spend more than it holds
+// the ledger refuses such a block as a whole, so the ones over the balance stay in the pool for a later block
+func withinCredits(txPool []blockchain.Tx, chain *blockchain.BlockChain, wlt *wallet.Wallet) ([]blockchain.Tx, error) {
+	minerPubKeyHash, err := wallet.PubKeyHashFromAddress(string(wlt.Address))
+	if err != nil {
+		return nil, err
+	}
+
+	var blockTxs []blockchain.Tx
+	creditsLeft := make(map[string]int64) // per payer, as the block is filled
+	for _, tx := range txPool {
+		payer, debit := tx.CreditDebit(chain.Params)
+		if debit == 0 {
+			blockTxs = append(blockTxs, tx)
+			continue
+		}
+		payerKey := hex.EncodeToString(payer)
+		credits, seen := creditsLeft[payerKey]
+		if !seen {
+			credits, err = chain.CreditBalance(payer)
+			if err != nil {
+				return nil, err
+			}
+			// the miner is paid before the transactions of its block are applied
+			if bytes.Equal(payer, minerPubKeyHash) {
+				credits += int64(chain.Params.BlockCredits)
+			}
+		}
+		if int64(debit) > credits {
+			utility.Debugf("Leaving transaction %x for a later block, %s has %d credits left in this one and it needs %d", tx.TxID, wallet.AddressFromPubKeyHash(payer), credits, debit)
+			creditsLeft[payerKey] = credits
+			continue
+		}
+		creditsLeft[payerKey] = credits - int64(debit)
+		blockTxs = append(blockTxs, tx)
+	}
+	return blockTxs, nil
+}
+
+// checks the memory pool every few seconds and mines whatever is in it, until the context is cancelled
+func Mine(ctx context.Context, chain *blockchain.BlockChain, wlt *wallet.Wallet, miningConfig config.MiningConfig) {
+	if chain.Params.Consensus == blockchain.CONSENSUS_POA {
+		utility.Infof("Signing blocks with wallet %s while it is a validator", wlt.Address)
+	} else {
+		utility.Infof("Mining with %d threads", miningConfig.Threads)
+	}
+	ticker := time.NewTicker(time.Duration(miningConfig.Delay) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			utility.Infof("Mining stopped")
+			return
+		case <-ticker.C:
+		}
+
+		newBlock, err := MinePool(ctx, chain, wlt, miningConfig.Threads)
+		if ctx.Err() != nil {
+			continue
+		}
+		// a validator out of turn gives way to the block of the one in turn, and a wallet voted out waits to be voted back in
+		if errors.Is(err, blockchain.ErrTipChanged) || errors.Is(err, blockchain.ErrNotValidator) {
+			utility.Debugf("Not signing a block: %v", err)
+			continue
+		}
+		if err != nil {
+			utility.Errorf("Mining failed: %v", err)
+			continue
+		}
+		if newBlock != nil {
+			utility.Infof("Mined block %x at height %d with %d transactions", newBlock.BlockHash, newBlock.Height, len(newBlock.TxMerkleTree.LeafNodes))
+			p2p.AnnounceBlock(newBlock)
+		}
+	}
+}
@@ -1,3 +1,210 @@
 package node
 
 // all potential operations concerning node communication can go here
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/pranjalpokharel7/yudhishthira/api"
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/config"
+	"github.com/pranjalpokharel7/yudhishthira/object"
+	"github.com/pranjalpokharel7/yudhishthira/p2p"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+	"github.com/pranjalpokharel7/yudhishthira/wallet"
+)
+
+const (
+	MEMPOOL_FILE_SUFFIX  = ".mempool" // the memory pool is saved next to the chain database when the node stops, eg. ./db.mempool
+	PEERS_FILE_SUFFIX    = ".peers"   // so is the address book, eg. ./db.peers
+	NODE_KEY_FILE_SUFFIX = ".nodekey" // the node key is kept there unless configured, eg. ./db.nodekey
+)
+
+// long running part of the node, run returns once the context is cancelled
+type service struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// loads the node wallet, generating a new one on first start
+func loadOrCreateWallet(walletFile string) (*wallet.Wallet, error) {
+	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+		utility.Infof("No wallet at %s, generating a new one", walletFile)
+		if err := wallet.GenerateWallet(walletFile); err != nil {
+			return nil, err
+		}
+	}
+	var wlt wallet.Wallet
+	if err := wlt.LoadWalletFromFile(walletFile); err != nil {
+		return nil, fmt.Errorf("could not load wallet %s: %v", walletFile, err)
+	}
+	return &wlt, nil
+}
+
+// starts the p2p server, the sync, peer discovery, the miner if enabled and the API from the config
+// runs until SIGINT or SIGTERM arrives or a service fails, then stops every service, saves the memory pool and the address book and closes the database
+func Run(cfg *config.Config) error {
+	utility.SetLogLevel(cfg.LogLevel)
+
+	params, err := cfg.ChainParams()
+	if err != nil {
+		return err
+	}
+	if cfg.DataDir != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+			return err
+		}
+	}
+	wlt, err := loadOrCreateWallet(cfg.Wallet)
+	if err != nil {
+		return err
+	}
+	utility.Infof("Starting node on the %s network with wallet %s", params.Name, wlt.Address)
+
+	// objects would be committed unchecked without them, so a directory that can not be read fails the start
+	schemas, err := object.LoadSchemas(cfg.API.Schemas)
+	if err != nil {
+		return fmt.Errorf("could not load the category schemas from %s: %v", cfg.API.Schemas, err)
+	}
+	utility.Infof("Loaded %d category schemas from %s", len(schemas), cfg.API.Schemas)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// a second signal kills the node right away
+		stop()
+	}()
+
+	chain, err := blockchain.OpenBlockChain(params)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// badger flushes its memtables and value log on close, skipping this is what corrupts the database
+		if err := chain.Database.Close(); err != nil {
+			utility.Errorf("Could not close the chain database: %v", err)
+			return
+		}
+		utility.Infof("Chain database closed")
+	}()
+
+	poolFile := params.DBPath + MEMPOOL_FILE_SUFFIX
+	if restored, err := p2p.LoadMemoryPool(poolFile, chain); err != nil {
+		utility.Warnf("Could not restore the memory pool: %v", err)
+	} else if restored != 0 {
+		utility.Infof("Restored %d transactions from %s", restored, poolFile)
+	}
+
+	nodeKeyFile := cfg.P2P.NodeKey
+	if nodeKeyFile == "" {
+		nodeKeyFile = params.DBPath + NODE_KEY_FILE_SUFFIX
+	}
+	nodeKey, created, err := p2p.LoadOrCreateNodeKey(nodeKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the node key: %v", err)
+	}
+	if created {
+		utility.Infof("No node key at %s, generated a new one", nodeKeyFile)
+	}
+	if err := p2p.SetupTransport(nodeKey, cfg.P2P.Encrypt, cfg.P2P.AllowList); err != nil {
+		return err
+	}
+
+	// listen before starting anything, so a port in use fails the start instead of a running node
+	advertiseAddress, err := cfg.P2PAdvertiseAddress(params)
+	if err != nil {
+		return err
+	}
+	ln, err := p2p.Listen(cfg.P2PListenAddress(params), advertiseAddress, chain)
+	if err != nil {
+		return err
+	}
+	peersFile := params.DBPath + PEERS_FILE_SUFFIX
+	if loaded, err := p2p.LoadAddressBook(peersFile); err != nil {
+		utility.Warnf("Could not restore the address book: %v", err)
+	} else if loaded != 0 {
+		utility.Infof("Restored %d peers from %s", loaded, peersFile)
+	}
+	if err := p2p.Bootstrap(cfg.P2P.SeedPeers, cfg.P2P.Outbound); err != nil {
+		return err
+	}
+	services := []service{
+		{"p2p", func(ctx context.Context) error {
+			return p2p.Serve(ctx, ln, chain, wlt)
+		}},
+		{"sync", func(ctx context.Context) error {
+			p2p.Sync(ctx, chain)
+			return nil
+		}},
+		{"discovery", func(ctx context.Context) error {
+			p2p.Discover(ctx, chain, peersFile)
+			return nil
+		}},
+		{"api", func(ctx context.Context) error {
+			return api.StartServer(ctx, wlt, chain, schemas, cfg.APIListenAddress(params), cfg.API.AuthToken)
+		}},
+	}
+	if cfg.Mining.Enabled {
+		services = append(services, service{"miner", func(ctx context.Context) error {
+			Mine(ctx, chain, wlt, cfg.Mining)
+			return nil
+		}})
+	}
+
+	err = supervise(ctx, services)
+
+	// nothing adds to the pools anymore once every service stopped
+	if saveErr := p2p.SaveMemoryPool(poolFile); saveErr != nil {
+		utility.Errorf("Could not save the memory pool: %v", saveErr)
+	} else {
+		utility.Infof("Memory pool saved to %s", poolFile)
+	}
+	if saveErr := p2p.SaveAddressBook(peersFile); saveErr != nil {
+		utility.Errorf("Could not save the address book: %v", saveErr)
+	}
+	return err
+}
+
+// runs the services until the context is cancelled or one of them fails, which stops the others as well
+// returns after every service has stopped, with the error of the first one that failed
+func supervise(ctx context.Context, services []service) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	failures := make(chan error, len(services))
+	for _, svc := range services {
+		wg.Add(1)
+		go func(svc service) {
+			defer wg.Done()
+			err := svc.run(ctx)
+			if ctx.Err() == nil {
+				if err == nil {
+					err = errors.New("stopped unexpectedly")
+				}
+				failures <- fmt.Errorf("%s: %v", svc.name, err)
+				cancel()
+			} else if err != nil {
+				utility.Errorf("%s did not stop cleanly: %v", svc.name, err)
+			}
+		}(svc)
+	}
+
+	<-ctx.Done()
+	utility.Infof("Stopping the node")
+	wg.Wait()
+
+	select {
+	case err := <-failures:
+		return err
+	default:
+		return nil
+	}
+}
@@ -1,22 +1,26 @@
 package object
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v2"
 )
 
+// the json encoding of an object is the metadata blob a coinbase transaction can commit to
 type Object struct {
-	Name             []byte
-	UniquePhysicalID []byte
-	BasePrice        uint64
-	Brand            []byte
-	Category         string
+	Name             string            `json:"name" yaml:"name"`
+	UniquePhysicalID string            `json:"unique_physical_id" yaml:"unique_physical_id"`
+	BasePrice        uint64            `json:"base_price" yaml:"base_price"`
+	Brand            string            `json:"brand" yaml:"brand"`
+	Category         string            `json:"category" yaml:"category"`
+	Attributes       map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"` // typed by the category schema, see schema.go
 }
 
 type ObjectData struct {
@@ -24,6 +28,53 @@ type ObjectData struct {
 	UniquePhysicalID []byte
 }
 
+// csv columns with these headers fill the fixed object fields, every other column is an attribute
+var csvFieldHeaders = map[string]string{
+	"name":               "name",
+	"id":                 "unique_physical_id",
+	"unique physical id": "unique_physical_id",
+	"unique_physical_id": "unique_physical_id",
+	"base price":         "base_price",
+	"base_price":         "base_price",
+	"brand":              "brand",
+	"category":           "category",
+}
+
+// fills the object from a csv row, columns are matched by the header row rather than by position
+func (objectInstance *Object) LoadCSVRecord(header []string, record []string) error {
+	if len(record) != len(header) {
+		return fmt.Errorf("expected %d columns, found %d", len(header), len(record))
+	}
+	for i, column := range header {
+		value := strings.TrimSpace(record[i])
+		switch csvFieldHeaders[strings.ToLower(strings.TrimSpace(column))] {
+		case "name":
+			objectInstance.Name = value
+		case "unique_physical_id":
+			objectInstance.UniquePhysicalID = value
+		case "base_price":
+			basePrice, err := strconv.ParseUint(value, 10, 64) // base 10, uint64
+			if err != nil {
+				return fmt.Errorf("invalid base price %q", value)
+			}
+			objectInstance.BasePrice = basePrice
+		case "brand":
+			objectInstance.Brand = value
+		case "category":
+			objectInstance.Category = value
+		default:
+			if value == "" {
+				continue
+			}
+			if objectInstance.Attributes == nil {
+				objectInstance.Attributes = make(map[string]string)
+			}
+			objectInstance.Attributes[strings.TrimSpace(column)] = value
+		}
+	}
+	return nil
+}
+
 func (objectInstance *Object) LoadCSVData(objectFilePath string) error {
 	file, err := os.Open(objectFilePath)
 	if err != nil {
@@ -32,8 +83,9 @@ func (objectInstance *Object) LoadCSVData(objectFilePath string) error {
 	defer file.Close()
 	csvReader := csv.NewReader(file)
 
-	// read and skip first line
-	if _, err = csvReader.Read(); err != nil {
+	// first line names the columns
+	header, err := csvReader.Read()
+	if err != nil {
 		return err
 	}
 
@@ -41,34 +93,38 @@ func (objectInstance *Object) LoadCSVData(objectFilePath string) error {
 	if err != nil {
 		return err
 	}
-	objectInstance.Name = []byte(objectData[0])
-	objectInstance.UniquePhysicalID = []byte(objectData[1])
-	objectInstance.BasePrice, err = strconv.ParseUint(objectData[2], 10, 64) // base 10, uint64
-	objectInstance.Brand = []byte(objectData[3])
-	objectInstance.Category = objectData[4]
-
-	return err // the remaining error is from base price parsing
+	return objectInstance.LoadCSVRecord(header, objectData)
 }
 
-func (objectInstance *Object) HashObject() []byte {
-	var buf bytes.Buffer
-
-	basePriceBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(basePriceBytes, objectInstance.BasePrice)
-	buf.Write(basePriceBytes)
-
-	buf.Write(objectInstance.Name)
-	buf.Write(objectInstance.UniquePhysicalID)
-	buf.Write(objectInstance.Brand)
-	buf.Write([]byte(objectInstance.Category))
+// loads an object from a csv, json or yaml file depending on the file extension
+func (objectInstance *Object) LoadObjectFile(objectFilePath string) error {
+	switch strings.ToLower(filepath.Ext(objectFilePath)) {
+	case ".json":
+		fileContent, err := ioutil.ReadFile(objectFilePath)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(fileContent, objectInstance)
+	case ".yaml", ".yml":
+		fileContent, err := ioutil.ReadFile(objectFilePath)
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(fileContent, objectInstance)
+	default:
+		return objectInstance.LoadCSVData(objectFilePath)
+	}
+}
 
-	objectHash := sha256.Sum256(buf.Bytes())
-	return objectHash[:]
+// canonical metadata blob, field order is fixed by the struct so equal objects give equal blobs
+func (objectInstance *Object) MetadataBlob() ([]byte, error) {
+	return json.Marshal(objectInstance)
 }
 
-func (objectInstance *Object) VerifyObjectHash(providedHash []byte) bool {
-	objectHash := objectInstance.HashObject()
-	return bytes.Equal(objectHash[:], providedHash)
+func ObjectFromMetadataBlob(blob []byte) (*Object, error) {
+	var objectInstance Object
+	err := json.Unmarshal(blob, &objectInstance)
+	return &objectInstance, err
 }
 
 func (objectInstance *Object) String() string {
@@ -78,6 +134,10 @@ func (objectInstance *Object) String() string {
 	objectDetails = append(objectDetails, fmt.Sprintf("Unique Physical ID: %s", objectInstance.UniquePhysicalID))
 	objectDetails = append(objectDetails, fmt.Sprintf("Base Price: %d", objectInstance.BasePrice))
 	objectDetails = append(objectDetails, fmt.Sprintf("Brand: %s", objectInstance.Brand))
-	objectDetails = append(objectDetails, fmt.Sprintf("Category: %s\n", objectInstance.Category))
+	objectDetails = append(objectDetails, fmt.Sprintf("Category: %s", objectInstance.Category))
+	for _, name := range objectInstance.sortedAttributeNames() {
+		objectDetails = append(objectDetails, fmt.Sprintf("  %s: %s", name, objectInstance.Attributes[name]))
+	}
+	objectDetails = append(objectDetails, "")
 	return strings.Join(objectDetails, "\n")
 }
@@ -0,0 +1,135 @@
+package object
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// object hash schemes, the version an item was hashed with is recorded on its coinbase transaction
+const (
+	// plain concatenation of the fields, ("ab","c") and ("a","bc") collide, only kept to verify old items
+	HASH_VERSION_LEGACY = 0
+	// every field written as tag, length, value so that no two distinct objects share an encoding
+	HASH_VERSION_TLV = 1
+
+	CURRENT_HASH_VERSION = HASH_VERSION_TLV
+)
+
+// field tags of the TLV encoding, never reuse or renumber these
+const (
+	tagName             = 0x01
+	tagUniquePhysicalID = 0x02
+	tagBasePrice        = 0x03
+	tagBrand            = 0x04
+	tagCategory         = 0x05
+	tagAttribute        = 0x06 // value is the TLV encoded attribute name followed by the TLV encoded attribute value
+	tagAttributeName    = 0x07
+	tagAttributeValue   = 0x08
+)
+
+func (objectInstance *Object) sortedAttributeNames() []string {
+	attributeNames := make([]string, 0, len(objectInstance.Attributes))
+	for name := range objectInstance.Attributes {
+		attributeNames = append(attributeNames, name)
+	}
+	sort.Strings(attributeNames)
+	return attributeNames
+}
+
+func writeTLV(buf *bytes.Buffer, tag byte, value []byte) {
+	lengthPrefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lengthPrefix, uint64(len(value)))
+	buf.WriteByte(tag)
+	buf.Write(lengthPrefix[:n])
+	buf.Write(value)
+}
+
+func (objectInstance *Object) legacyEncoding() []byte {
+	var buf bytes.Buffer
+
+	basePriceBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(basePriceBytes, objectInstance.BasePrice)
+	buf.Write(basePriceBytes)
+
+	buf.WriteString(objectInstance.Name)
+	buf.WriteString(objectInstance.UniquePhysicalID)
+	buf.WriteString(objectInstance.Brand)
+	buf.WriteString(objectInstance.Category)
+
+	// attributes are written in key order, each key and value prefixed with its length
+	// objects without attributes keep the hash they had before schemas existed
+	lengthPrefix := make([]byte, binary.MaxVarintLen64)
+	for _, name := range objectInstance.sortedAttributeNames() {
+		for _, field := range []string{name, objectInstance.Attributes[name]} {
+			n := binary.PutUvarint(lengthPrefix, uint64(len(field)))
+			buf.Write(lengthPrefix[:n])
+			buf.WriteString(field)
+		}
+	}
+	return buf.Bytes()
+}
+
+// the version byte leads so that encodings of different versions can never be equal
+func (objectInstance *Object) tlvEncoding() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(HASH_VERSION_TLV)
+
+	basePriceBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(basePriceBytes, objectInstance.BasePrice)
+
+	writeTLV(&buf, tagName, []byte(objectInstance.Name))
+	writeTLV(&buf, tagUniquePhysicalID, []byte(objectInstance.UniquePhysicalID))
+	writeTLV(&buf, tagBasePrice, basePriceBytes)
+	writeTLV(&buf, tagBrand, []byte(objectInstance.Brand))
+	writeTLV(&buf, tagCategory, []byte(objectInstance.Category))
+	for _, name := range objectInstance.sortedAttributeNames() {
+		var attribute bytes.Buffer
+		writeTLV(&attribute, tagAttributeName, []byte(name))
+		writeTLV(&attribute, tagAttributeValue, []byte(objectInstance.Attributes[name]))
+		writeTLV(&buf, tagAttribute, attribute.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func (objectInstance *Object) HashObjectVersion(version uint8) ([]byte, error) {
+	var encoding []byte
+	switch version {
+	case HASH_VERSION_LEGACY:
+		encoding = objectInstance.legacyEncoding()
+	case HASH_VERSION_TLV:
+		encoding = objectInstance.tlvEncoding()
+	default:
+		return nil, fmt.Errorf("unknown object hash version %d", version)
+	}
+	objectHash := sha256.Sum256(encoding)
+	return objectHash[:], nil
+}
+
+// hash under the current scheme, what new items are introduced with
+func (objectInstance *Object) HashObject() []byte {
+	objectHash, _ := objectInstance.HashObjectVersion(CURRENT_HASH_VERSION)
+	return objectHash
+}
+
+func (objectInstance *Object) VerifyObjectHashVersion(providedHash []byte, version uint8) bool {
+	objectHash, err := objectInstance.HashObjectVersion(version)
+	return err == nil && bytes.Equal(objectHash, providedHash)
+}
+
+// finds the scheme under which the object hashes to the provided hash, newest scheme first
+func (objectInstance *Object) MatchHashVersion(providedHash []byte) (uint8, bool) {
+	for _, version := range []uint8{HASH_VERSION_TLV, HASH_VERSION_LEGACY} {
+		if objectInstance.VerifyObjectHashVersion(providedHash, version) {
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+func (objectInstance *Object) VerifyObjectHash(providedHash []byte) bool {
+	_, matched := objectInstance.MatchHashVersion(providedHash)
+	return matched
+}
@@ -0,0 +1,222 @@
+package object
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const SCHEMA_DIR = "./object/schemas" // default location of the category schema files
+
+// attribute types a schema can declare
+const (
+	ATTR_STRING     = "string"
+	ATTR_INTEGER    = "integer"
+	ATTR_NUMBER     = "number"
+	ATTR_BOOLEAN    = "boolean"
+	ATTR_DIMENSIONS = "dimensions" // length x width x height with an optional unit, eg. 35.9x25.5x2.4cm
+)
+
+var dimensionsPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)x(\d+(?:\.\d+)?)x(\d+(?:\.\d+)?)(mm|cm|m|in)?$`)
+
+type AttributeSpec struct {
+	Name        string `json:"name" yaml:"name"`
+	Type        string `json:"type" yaml:"type"`
+	Required    bool   `json:"required" yaml:"required"`
+	Pattern     string `json:"pattern,omitempty" yaml:"pattern,omitempty"` // optional regular expression the value must match, eg. for VIN or IMEI
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	compiledPattern *regexp.Regexp
+}
+
+// describes the attributes objects of a category carry, loaded from json or yaml files
+type CategorySchema struct {
+	Category   string          `json:"category" yaml:"category"`
+	Attributes []AttributeSpec `json:"attributes" yaml:"attributes"`
+}
+
+// schemas keyed by lower case category name
+type SchemaRegistry map[string]*CategorySchema
+
+func categoryKey(category string) string {
+	return strings.ToLower(strings.TrimSpace(category))
+}
+
+func parseSchema(schemaFilePath string) (*CategorySchema, error) {
+	fileContent, err := ioutil.ReadFile(schemaFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema CategorySchema
+	switch strings.ToLower(filepath.Ext(schemaFilePath)) {
+	case ".json":
+		err = json.Unmarshal(fileContent, &schema)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(fileContent, &schema)
+	default:
+		return nil, fmt.Errorf("unsupported schema file %s", schemaFilePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", schemaFilePath, err)
+	}
+
+	if schema.Category == "" {
+		return nil, fmt.Errorf("%s: schema does not name a category", schemaFilePath)
+	}
+	seen := make(map[string]bool)
+	for i := range schema.Attributes {
+		spec := &schema.Attributes[i]
+		if spec.Name == "" || seen[spec.Name] {
+			return nil, fmt.Errorf("%s: attribute names must be present and unique", schemaFilePath)
+		}
+		seen[spec.Name] = true
+		switch spec.Type {
+		case ATTR_STRING, ATTR_INTEGER, ATTR_NUMBER, ATTR_BOOLEAN, ATTR_DIMENSIONS:
+		default:
+			return nil, fmt.Errorf("%s: attribute %s has unknown type %q", schemaFilePath, spec.Name, spec.Type)
+		}
+		if spec.Pattern != "" {
+			spec.compiledPattern, err = regexp.Compile(spec.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: attribute %s: %v", schemaFilePath, spec.Name, err)
+			}
+		}
+	}
+	return &schema, nil
+}
+
+// loads every .json, .yaml and .yml file in the directory as a category schema
+func LoadSchemas(schemaDir string) (SchemaRegistry, error) {
+	registry := make(SchemaRegistry)
+	files, err := ioutil.ReadDir(schemaDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		schema, err := parseSchema(filepath.Join(schemaDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := registry[categoryKey(schema.Category)]; exists {
+			return nil, fmt.Errorf("category %s is defined by more than one schema", schema.Category)
+		}
+		registry[categoryKey(schema.Category)] = schema
+	}
+	return registry, nil
+}
+
+func (registry SchemaRegistry) SchemaFor(category string) (*CategorySchema, error) {
+	schema, ok := registry[categoryKey(category)]
+	if !ok {
+		return nil, fmt.Errorf("no schema registered for category %q", category)
+	}
+	return schema, nil
+}
+
+// validates the object against the schema of its category and normalizes its attribute values
+// categories without a schema are not validated, their objects are hashed and committed as given
+func (registry SchemaRegistry) Validate(objectInstance *Object) error {
+	schema, ok := registry[categoryKey(objectInstance.Category)]
+	if !ok {
+		return nil
+	}
+	return schema.Validate(objectInstance)
+}
+
+// normalizes the value so that equal values always hash the same, eg. "007" and "7" for integers
+func normalizeAttribute(spec *AttributeSpec, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch spec.Type {
+	case ATTR_INTEGER:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("attribute %s must be an integer", spec.Name)
+		}
+		value = strconv.FormatInt(parsed, 10)
+	case ATTR_NUMBER:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("attribute %s must be a number", spec.Name)
+		}
+		value = strconv.FormatFloat(parsed, 'f', -1, 64)
+	case ATTR_BOOLEAN:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("attribute %s must be a boolean", spec.Name)
+		}
+		value = strconv.FormatBool(parsed)
+	case ATTR_DIMENSIONS:
+		value = strings.ToLower(strings.ReplaceAll(value, " ", ""))
+		if !dimensionsPattern.MatchString(value) {
+			return "", fmt.Errorf("attribute %s must be dimensions in the form LxWxH[unit]", spec.Name)
+		}
+	}
+	if spec.compiledPattern != nil && !spec.compiledPattern.MatchString(value) {
+		return "", fmt.Errorf("attribute %s does not match pattern %s", spec.Name, spec.Pattern)
+	}
+	return value, nil
+}
+
+func (schema *CategorySchema) Validate(objectInstance *Object) error {
+	var problems []string
+	if strings.TrimSpace(objectInstance.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if strings.TrimSpace(objectInstance.UniquePhysicalID) == "" {
+		problems = append(problems, "unique physical id is required")
+	}
+
+	normalized := make(map[string]string)
+	specs := make(map[string]bool)
+	for i := range schema.Attributes {
+		spec := &schema.Attributes[i]
+		specs[spec.Name] = true
+		value, present := objectInstance.Attributes[spec.Name]
+		if !present || strings.TrimSpace(value) == "" {
+			if spec.Required {
+				problems = append(problems, fmt.Sprintf("attribute %s is required", spec.Name))
+			}
+			continue
+		}
+		value, err := normalizeAttribute(spec, value)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		normalized[spec.Name] = value
+	}
+	for name := range objectInstance.Attributes {
+		if !specs[name] {
+			problems = append(problems, fmt.Sprintf("attribute %s is not defined for category %s", name, schema.Category))
+		}
+	}
+
+	if len(problems) != 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	// use the schema's spelling of the category so that the hash does not depend on letter case
+	objectInstance.Category = schema.Category
+	if len(normalized) == 0 {
+		objectInstance.Attributes = nil
+	} else {
+		objectInstance.Attributes = normalized
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+)
+
+const (
+	DEFAULT_OUTBOUND_PEERS = 8                // known nodes messages are relayed to, filled from the address book
+	MAX_ADDRESS_BOOK       = 1000             // addresses learned from peers are dropped once the book is full
+	MAX_ADDR_SEND          = 100              // addresses sent in reply to a getaddr
+	MAX_PEER_FAILURES      = 10               // failed dials in a row after which a learned peer is forgotten
+	MAX_PEER_SCORE         = 100              // scores stay within -MAX_PEER_SCORE and MAX_PEER_SCORE
+	PEER_SCORE_SUCCESS     = 1                // for every message the peer sent or accepted
+	PEER_SCORE_FAILURE     = -5               // for every failed dial
+	DISCOVERY_INTERVAL     = 5 * time.Minute  // between getaddr rounds, stale peer retries and saves of the book
+	PEER_RETRY_INTERVAL    = 10 * time.Minute // a stale peer is dialed again at most this often
+)
+
+// where a peer address was learned from
+const (
+	SOURCE_SEED    = "seed"    // configured seed or the known nodes file, never forgotten
+	SOURCE_MANUAL  = "manual"  // added through the api, never forgotten
+	SOURCE_INBOUND = "inbound" // sent us its version
+	SOURCE_ADDR    = "addr"    // gossiped by another peer
+)
+
+// what the node knows about a peer address
+type PeerRecord struct {
+	Address  string    `json:"address"`
+	Source   string    `json:"source"`
+	Score    int       `json:"score"`
+	LastSeen time.Time `json:"last_seen"` // last message from the peer or successful dial, zero if never reached
+	LastTry  time.Time `json:"last_try"`  // last failed dial
+	Failures int       `json:"failures"`  // failed dials in a row
+}
+
+// a peer is stale while its last dial failed, it stays in the book but messages are not relayed to it
+func (record *PeerRecord) Stale() bool {
+	return record.Failures != 0
+}
+
+func (record *PeerRecord) addScore(delta int) {
+	record.Score += delta
+	if record.Score > MAX_PEER_SCORE {
+		record.Score = MAX_PEER_SCORE
+	} else if record.Score < -MAX_PEER_SCORE {
+		record.Score = -MAX_PEER_SCORE
+	}
+}
+
+func (record *PeerRecord) forgettable() bool {
+	return record.Source != SOURCE_SEED && record.Source != SOURCE_MANUAL
+}
+
+var (
+	// every peer address the node learned, keyed by host:port
+	addressBook    = make(map[string]*PeerRecord)
+	addressMutex   sync.Mutex
+	outboundTarget = DEFAULT_OUTBOUND_PEERS
+)
+
+// adds the address to the book if it is new, valid, not banned and not this node
+// must be called with addressMutex held
+func addToBook(address string, source string) *PeerRecord {
+	if record, ok := addressBook[address]; ok {
+		// a peer configured as seed or added by hand is never forgotten, however it was learned before
+		if source == SOURCE_SEED || source == SOURCE_MANUAL {
+			record.Source = source
+		}
+		return record
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil || address == nodeAddress || IsBanned(address) {
+		return nil
+	}
+	if source == SOURCE_ADDR && len(addressBook) >= MAX_ADDRESS_BOOK {
+		return nil
+	}
+	record := &PeerRecord{Address: address, Source: source}
+	addressBook[address] = record
+	return record
+}
+
+// picks the known nodes from the book, the ones already selected stay while they are reachable
+// and the free slots go to the reachable peers with the best score, the peers that were added are returned
+// must be called with addressMutex held
+func selectOutbound() []string {
+	var selected []string
+	for _, node := range KnownNodes {
+		if record, ok := addressBook[node]; ok && !record.Stale() {
+			selected = append(selected, node)
+		}
+	}
+
+	var candidates []*PeerRecord
+	for _, record := range addressBook {
+		if !record.Stale() && !contains(selected, record.Address) {
+			candidates = append(candidates, record)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].LastSeen.After(candidates[j].LastSeen)
+	})
+
+	var added []string
+	for _, record := range candidates {
+		if len(selected) >= outboundTarget {
+			break
+		}
+		selected = append(selected, record.Address)
+		added = append(added, record.Address)
+	}
+	// a new slice, so ranging over the old one elsewhere stays safe
+	KnownNodes = selected
+	return added
+}
+
+// a dial to the peer worked or it sent us a message
+func markPeerSeen(address string) {
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	record, ok := addressBook[address]
+	if !ok {
+		return
+	}
+	wasStale := record.Stale()
+	record.LastSeen = time.Now()
+	record.Failures = 0
+	record.addScore(PEER_SCORE_SUCCESS)
+	if wasStale {
+		selectOutbound()
+	}
+}
+
+// a dial to the peer failed, it is marked stale and replaced in the known nodes by the next best peer
+func markPeerFailed(address string) {
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	record, ok := addressBook[address]
+	if !ok {
+		return
+	}
+	record.LastTry = time.Now()
+	record.Failures++
+	record.addScore(PEER_SCORE_FAILURE)
+	selectOutbound()
+}
+
+// drops a peer from the book and the known nodes, eg. one on another chain or this node under another address
+func forgetPeer(address string) {
+	forgetHandshake(address)
+
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	delete(addressBook, address)
+	var remainingNodes []string
+	for _, node := range KnownNodes {
+		if node != address {
+			remainingNodes = append(remainingNodes, node)
+		}
+	}
+	KnownNodes = remainingNodes
+	selectOutbound()
+}
+
+// known nodes learned from a peer, banned ones and the ones already known are skipped
+// the new outbound peers are returned so the caller can greet them
+func addKnownNodes(addresses []string, source string) []string {
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	for _, address := range addresses {
+		if address != "" {
+			addToBook(address, source)
+		}
+	}
+	return selectOutbound()
+}
+
+// adds the seeds, or the known nodes file if there are none, to the book and picks the first known nodes
+func Bootstrap(seedPeers []string, outboundPeers int) error {
+	if len(seedPeers) == 0 {
+		if _, err := os.Stat(KNOWN_NODES_FILE); err == nil {
+			knownNodes, err := readKnownNodesFromJSON()
+			if err != nil {
+				return err
+			}
+			seedPeers = knownNodes
+		}
+	}
+
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	if outboundPeers > 0 {
+		outboundTarget = outboundPeers
+	}
+	for _, seed := range seedPeers {
+		addToBook(seed, SOURCE_SEED)
+	}
+	selectOutbound()
+	return nil
+}
+
+// addresses worth handing to a peer, the reachable ones seen most recently first
+func shareableAddresses(exclude string) []string {
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	var records []*PeerRecord
+	for _, record := range addressBook {
+		if !record.Stale() && !record.LastSeen.IsZero() && record.Address != exclude {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].LastSeen.After(records[j].LastSeen)
+	})
+
+	addresses := []string{}
+	for _, record := range records {
+		if len(addresses) >= MAX_ADDR_SEND {
+			break
+		}
+		addresses = append(addresses, record.Address)
+	}
+	return addresses
+}
+
+// copy of the address book, best score first
+func AddressBook() []PeerRecord {
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	records := []PeerRecord{}
+	for _, record := range addressBook {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Score != records[j].Score {
+			return records[i].Score > records[j].Score
+		}
+		return records[i].Address < records[j].Address
+	})
+	return records
+}
+
+// stale peers due for another try, and learned peers failing for too long are forgotten
+func peersToRetry() []string {
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	var retries []string
+	for address, record := range addressBook {
+		if !record.Stale() {
+			continue
+		}
+		if record.forgettable() && record.Failures >= MAX_PEER_FAILURES {
+			delete(addressBook, address)
+			continue
+		}
+		if time.Since(record.LastTry) >= PEER_RETRY_INTERVAL {
+			retries = append(retries, address)
+		}
+	}
+	return retries
+}
+
+// the address book as stored on disk
+type savedPeers struct {
+	Peers []PeerRecord `json:"peers"`
+}
+
+func SaveAddressBook(peersFile string) error {
+	peers := savedPeers{Peers: AddressBook()}
+	fileContent, err := json.MarshalIndent(peers, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(peersFile, fileContent, 0644)
+}
+
+// reads the peers saved by the last run back, call it before Bootstrap so the seeds keep their source
+func LoadAddressBook(peersFile string) (int, error) {
+	fileContent, err := ioutil.ReadFile(peersFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var peers savedPeers
+	if err := json.Unmarshal(fileContent, &peers); err != nil {
+		return 0, fmt.Errorf("%s: %v", peersFile, err)
+	}
+
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	loaded := 0
+	for _, saved := range peers.Peers {
+		if _, _, err := net.SplitHostPort(saved.Address); err != nil || saved.Address == nodeAddress {
+			continue
+		}
+		record := saved
+		addressBook[record.Address] = &record
+		loaded++
+	}
+	selectOutbound()
+	return loaded, nil
+}
+
+// asks the known nodes for more peers while there are fewer than the target, dials stale peers again
+// and saves the book, right away and every DISCOVERY_INTERVAL until the context is cancelled
+func Discover(ctx context.Context, chain *blockchain.BlockChain, peersFile string) {
+	ticker := time.NewTicker(DISCOVERY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		knownNodes := Peers()
+		if len(knownNodes) < outboundTarget {
+			for _, node := range knownNodes {
+				if !handshakeComplete(node) {
+					continue
+				}
+				func() {
+					defer recoverPeerPanic(node)
+					SendGetAddress(node)
+				}()
+			}
+		}
+		for _, node := range peersToRetry() {
+			func() {
+				defer recoverPeerPanic(node)
+				SendVersion(node, chain)
+			}()
+		}
+		if err := SaveAddressBook(peersFile); err != nil {
+			utility.Errorf("Could not save the address book: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+)
+
+const (
+	SHORT_TX_ID_LENGTH    = 6           // bytes of the transaction id a compact block carries per transaction
+	PARTIAL_BLOCK_TIMEOUT = time.Minute // a compact block still missing transactions after this long is forgotten
+)
+
+// a block without its transactions, the receiver rebuilds it from the transactions in its pools
+// For details follow this link: https://github.com/bitcoin/bips/blob/master/bip-0152.mediawiki
+type CompactBlock struct {
+	AddrFrom string
+	Header   blockchain.Block // the block without its merkle tree
+	ShortIDs [][]byte         // first SHORT_TX_ID_LENGTH bytes of the transaction ids, in block order
+}
+
+// asks for the transactions of a compact block that were not found in the pools
+type GetBlockTxn struct {
+	AddrFrom  string
+	BlockHash []byte
+	Indexes   []int // positions of the transactions in the block
+}
+
+// answer to a getblocktxn, the transactions in the order they were asked for
+type BlockTxn struct {
+	AddrFrom  string
+	BlockHash []byte
+	Txs       []blockchain.Tx
+}
+
+// compact block waiting for the transactions it was missing
+type partialBlock struct {
+	header    blockchain.Block
+	txs       []blockchain.Tx
+	missing   []int
+	requested time.Time
+}
+
+// compact blocks being completed, keyed by hex block hash, guarded by blockMutex
+var partialBlocks = make(map[string]*partialBlock)
+
+func shortTxID(txID []byte) []byte {
+	if len(txID) < SHORT_TX_ID_LENGTH {
+		return txID
+	}
+	return txID[:SHORT_TX_ID_LENGTH]
+}
+
+// pooled transactions by short id, ids shared by two transactions are left out and fetched from the peer instead
+func shortIDIndex() map[string]*blockchain.Tx {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	index := make(map[string]*blockchain.Tx)
+	ambiguous := make(map[string]bool)
+	for _, pool := range []map[string]blockchain.Tx{MemoryPool, LockedTxs} {
+		for _, tx := range pool {
+			tx := tx
+			shortID := hex.EncodeToString(shortTxID(tx.TxID))
+			if _, exists := index[shortID]; exists {
+				ambiguous[shortID] = true
+			}
+			index[shortID] = &tx
+		}
+	}
+	for shortID := range ambiguous {
+		delete(index, shortID)
+	}
+	return index
+}
+
+func SendCompactBlock(addr string, block *blockchain.Block) {
+	header := *block
+	header.TxMerkleTree = nil
+	compactBlock := CompactBlock{
+		AddrFrom: nodeAddress,
+		Header:   header,
+		ShortIDs: [][]byte{},
+	}
+	if !block.IsEmpty() {
+		for _, txNode := range block.TxMerkleTree.LeafNodes {
+			compactBlock.ShortIDs = append(compactBlock.ShortIDs, shortTxID(txNode.Transaction.TxID))
+		}
+	}
+	info := append(CommandToBytes("cmpctblock"), GobEncode(compactBlock)...)
+
+	sendData(addr, info)
+}
+
+func sendGetBlockTxn(addr string, blockHash []byte, indexes []int) {
+	request := GetBlockTxn{
+		AddrFrom:  nodeAddress,
+		BlockHash: blockHash,
+		Indexes:   indexes,
+	}
+	info := append(CommandToBytes("getblocktxn"), GobEncode(request)...)
+
+	sendData(addr, info)
+}
+
+func sendBlockTxn(addr string, blockHash []byte, txs []blockchain.Tx) {
+	response := BlockTxn{
+		AddrFrom:  nodeAddress,
+		BlockHash: blockHash,
+		Txs:       txs,
+	}
+	info := append(CommandToBytes("blocktxn"), GobEncode(response)...)
+
+	sendData(addr, info)
+}
+
+// rebuilds the block from the pooled transactions, the missing ones are asked for
+func HandleCompactBlock(request []byte, addrFrom string, chain *blockchain.BlockChain) {
+	var payload CompactBlock
+	err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(&payload)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	header := payload.Header
+	fmt.Printf("Received a compact block of hash: %x\n", header.BlockHash)
+
+	blockMutex.Lock()
+	defer blockMutex.Unlock()
+
+	if chain.HasBlock(header.BlockHash) || !extendsTip(&header, addrFrom, chain) {
+		return
+	}
+
+	index := shortIDIndex()
+	partial := &partialBlock{
+		header:    header,
+		txs:       make([]blockchain.Tx, len(payload.ShortIDs)),
+		requested: time.Now(),
+	}
+	for i, shortID := range payload.ShortIDs {
+		if tx, ok := index[hex.EncodeToString(shortID)]; ok {
+			partial.txs[i] = *tx
+		} else {
+			partial.missing = append(partial.missing, i)
+		}
+	}
+
+	if len(partial.missing) == 0 {
+		completeBlock(partial, addrFrom, chain)
+		return
+	}
+
+	for blockHash, pending := range partialBlocks {
+		if time.Since(pending.requested) > PARTIAL_BLOCK_TIMEOUT {
+			delete(partialBlocks, blockHash)
+		}
+	}
+	partialBlocks[hex.EncodeToString(header.BlockHash)] = partial
+	utility.Debugf("Compact block %x misses %d of %d transactions", header.BlockHash, len(partial.missing), len(partial.txs))
+	sendGetBlockTxn(addrFrom, header.BlockHash, partial.missing)
+}
+
+// answers with the transactions of the block at the asked positions
+func HandleGetBlockTxn(request []byte, addrFrom string, chain *blockchain.BlockChain) {
+	var payload GetBlockTxn
+	err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(&payload)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	block, err := chain.GetBlock(payload.BlockHash)
+	if err != nil || block.IsEmpty() {
+		return
+	}
+
+	var txs []blockchain.Tx
+	for _, i := range payload.Indexes {
+		if i < 0 || i >= len(block.TxMerkleTree.LeafNodes) {
+			utility.Debugf("Ignoring getblocktxn from %s, block %x has no transaction %d", addrFrom, payload.BlockHash, i)
+			return
+		}
+		txs = append(txs, block.TxMerkleTree.LeafNodes[i].Transaction)
+	}
+
+	sendBlockTxn(addrFrom, payload.BlockHash, txs)
+}
+
+// fills in the transactions a compact block was missing
+func HandleBlockTxn(request []byte, addrFrom string, chain *blockchain.BlockChain) {
+	var payload BlockTxn
+	err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(&payload)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blockMutex.Lock()
+	defer blockMutex.Unlock()
+
+	blockHash := hex.EncodeToString(payload.BlockHash)
+	partial, ok := partialBlocks[blockHash]
+	if !ok {
+		return
+	}
+	delete(partialBlocks, blockHash)
+
+	if len(payload.Txs) != len(partial.missing) {
+		requestFullBlock(addrFrom, partial.header.BlockHash, "peer sent the wrong number of transactions")
+		return
+	}
+	for i, tx := range payload.Txs {
+		partial.txs[partial.missing[i]] = tx
+	}
+	completeBlock(partial, addrFrom, chain)
+}
+
+// adds the rebuilt block, a block that does not come out as the peer mined it is fetched whole
+// must be called with blockMutex held
+func completeBlock(partial *partialBlock, addrFrom string, chain *blockchain.BlockChain) {
+	block := partial.header
+	if len(partial.txs) != 0 {
+		if err := block.AddTransactionsToBlock(partial.txs); err != nil {
+			requestFullBlock(addrFrom, block.BlockHash, err.Error())
+			return
+		}
+	}
+	if !block.VerifyBlockHash() {
+		// a short id matched another transaction than the one in the block
+		requestFullBlock(addrFrom, block.BlockHash, "rebuilt block does not match its hash")
+		return
+	}
+	// eg. a swap pooled with fewer signatures than the copy that was mined
+	if err := connectBlock(&block, addrFrom, chain); err != nil {
+		requestFullBlock(addrFrom, block.BlockHash, err.Error())
+		return
+	}
+	relayBlock(&block, addrFrom)
+}
+
+func requestFullBlock(addrFrom string, blockHash []byte, reason string) {
+	utility.Debugf("Fetching block %x from %s whole: %s", blockHash, addrFrom, reason)
+	sendGetData(addrFrom, BLOCK_TYPE, blockHash)
+}
@@ -0,0 +1,242 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+	"github.com/pranjalpokharel7/yudhishthira/utility"
+)
+
+const (
+	PROTOCOL_VERSION       = 2                    // raised whenever messages change in a way older nodes can not handle
+	MIN_PROTOCOL_VERSION   = 1                    // peers below this are disconnected
+	COMPACT_BLOCKS_VERSION = 2                    // peers from this version on answer getdata for compact blocks
+	USER_AGENT             = "/yudhishthira:0.1/" // sent in the version, only shown to the operator of the peer
+	LOCAL_SERVICES         = NODE_NETWORK         // this node stores the whole chain and serves blocks
+	VERSION_REPLY_INTERVAL = 30 * time.Second     // a version is only answered with ours if we did not send one this recently
+)
+
+// acknowledges a version, the sender accepted the peer and handles its messages from now on
+type Verack struct {
+	AddrFrom string
+}
+
+// progress of the version/verack handshake with a peer
+type handshake struct {
+	version     *Version  // last version the peer sent, nil until one passed checkVersion
+	nodeID      string    // node key the version came with, empty over plaintext connections
+	acked       bool      // the peer accepted our version
+	versionSent time.Time // when we last sent our version
+}
+
+// what a peer told about itself in its version
+type PeerVersion struct {
+	ProtocolVersion int             `json:"protocol_version"`
+	NodeID          string          `json:"node_id"` // empty unless connections are encrypted
+	Services        uint64          `json:"services"`
+	UserAgent       string          `json:"user_agent"`
+	Height          uint64          `json:"height"`
+	BestHash        utility.HexByte `json:"best_hash"`
+	Acked           bool            `json:"acked"` // the peer accepted our version as well
+}
+
+var (
+	// random per run, a version carrying it means this node dialed itself
+	localNonce     = newNonce()
+	handshakes     = make(map[string]*handshake)
+	handshakeMutex sync.Mutex
+)
+
+func newNonce() uint64 {
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(nonce[:])
+}
+
+// address the sender of a message claims, every payload has an AddrFrom field except the version which has AddressFrom
+func claimedSender(request []byte) string {
+	var sender struct {
+		AddrFrom    string
+		AddressFrom string
+	}
+	if err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(&sender); err != nil {
+		return ""
+	}
+	if sender.AddressFrom != "" {
+		return sender.AddressFrom
+	}
+	return sender.AddrFrom
+}
+
+// reasons to disconnect a peer right after its version
+func checkVersion(version *Version, chain *blockchain.BlockChain) error {
+	if version.Nonce == localNonce {
+		return errors.New("connected to itself")
+	}
+	if version.ProtocolVersion < MIN_PROTOCOL_VERSION {
+		return fmt.Errorf("protocol version %d is older than %d", version.ProtocolVersion, MIN_PROTOCOL_VERSION)
+	}
+	if !bytes.Equal(version.ChainID, chain.Params.ID()) {
+		return fmt.Errorf("follows other chain rules than the %s network", chain.Params.Name)
+	}
+	return nil
+}
+
+// true once the peer sent a version we accepted, only then are its other messages handled
+// a message with another node key than the version is from someone else claiming the address
+func handshakeAccepted(address string, nodeID string) bool {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	state, ok := handshakes[address]
+	return ok && state.version != nil && state.nodeID == nodeID
+}
+
+// protocol version the peer sent in its accepted version, 0 if none
+func peerProtocolVersion(address string) int {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	state, ok := handshakes[address]
+	if !ok || state.version == nil {
+		return 0
+	}
+	return state.version.ProtocolVersion
+}
+
+// true once both sides accepted the version of the other
+func handshakeComplete(address string) bool {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	state, ok := handshakes[address]
+	return ok && state.version != nil && state.acked
+}
+
+// must be called with handshakeMutex held
+func handshakeWith(address string) *handshake {
+	state, ok := handshakes[address]
+	if !ok {
+		state = &handshake{}
+		handshakes[address] = state
+	}
+	return state
+}
+
+// stores the accepted version of the peer, returns whether the peer accepted ours already,
+// whether this version completed the handshake and whether ours should be sent so the peer can accept it
+func acceptVersion(address string, nodeID string, version *Version) (acked bool, completed bool, sendOwn bool) {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	state := handshakeWith(address)
+	if state.version != nil && (state.version.Nonce != version.Nonce || state.nodeID != nodeID) {
+		// the peer restarted, maybe with a new node key, and forgot that it accepted us
+		state.acked = false
+	}
+	state.nodeID = nodeID
+	completed = state.acked && state.version == nil
+	state.version = version
+	sendOwn = !state.acked && time.Since(state.versionSent) > VERSION_REPLY_INTERVAL
+	return state.acked, completed, sendOwn
+}
+
+// marks our version as accepted by the peer, returns the version of the peer if this completed the handshake
+// a verack with another node key than the version of the peer is ignored
+func acceptVerack(address string, nodeID string) *Version {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	state := handshakeWith(address)
+	if state.version != nil && state.nodeID != nodeID {
+		return nil
+	}
+	completed := !state.acked && state.version != nil
+	state.acked = true
+	if completed {
+		return state.version
+	}
+	return nil
+}
+
+func markVersionSent(address string) {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	handshakeWith(address).versionSent = time.Now()
+}
+
+// a peer that can not be reached or was disconnected has to send its version again
+func forgetHandshake(address string) {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	delete(handshakes, address)
+}
+
+// versions of the peers that passed the handshake, keyed by address
+func PeerVersions() map[string]PeerVersion {
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	versions := make(map[string]PeerVersion)
+	for address, state := range handshakes {
+		if state.version == nil {
+			continue
+		}
+		versions[address] = PeerVersion{
+			ProtocolVersion: state.version.ProtocolVersion,
+			NodeID:          state.nodeID,
+			Services:        state.version.Services,
+			UserAgent:       state.version.UserAgent,
+			Height:          state.version.Height,
+			BestHash:        state.version.BestHash,
+			Acked:           state.acked,
+		}
+	}
+	return versions
+}
+
+// both sides accepted the version of the other and know its height, so only the node behind has to act
+// a node short of peers asks the new one for addresses as well
+func handshakeCompleted(addr string, version *Version, chain *blockchain.BlockChain) {
+	utility.Infof("Handshake with %s (%s, protocol %d) complete", addr, version.UserAgent, version.ProtocolVersion)
+	requestBlocksIfBehind(addr, version, chain)
+	if len(Peers()) < outboundTarget {
+		SendGetAddress(addr)
+	}
+}
+
+// asks the peer for blocks if its chain is longer and it serves blocks, true if ours is the longer one
+func requestBlocksIfBehind(addr string, version *Version, chain *blockchain.BlockChain) bool {
+	bestHeight := chain.GetHeight()
+	if bestHeight < version.Height && version.Services&NODE_NETWORK != 0 {
+		fmt.Println("Sending Get block request")
+		SendGetBlocks(addr, chain)
+	}
+	return bestHeight > version.Height
+}
+
+func SendVerack(addr string) {
+	data := append(CommandToBytes("verack"), GobEncode(Verack{AddrFrom: nodeAddress})...)
+
+	sendData(addr, data)
+}
+
+// answers the version of a peer after the handshake, the node with the shorter chain asks the other for blocks
+// and a longer chain is announced with our version
+func syncWith(addr string, version *Version, chain *blockchain.BlockChain) {
+	if requestBlocksIfBehind(addr, version, chain) {
+		fmt.Println("Sending version of the current block")
+		SendVersion(addr, chain)
+	}
+}
@@ -0,0 +1,241 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+)
+
+// entry point into the memory pool, every transaction coming from the api or from peers goes through here, imports through AcceptIntroductionToMemoryPool
+// pending transfer offers are validated the same way but kept in the offer pool until the buyer countersigns
+// time locked transactions are held aside until a block may include them, see PromoteMaturedTxs
+func AcceptToMemoryPool(tx blockchain.Tx, chain *blockchain.BlockChain) *blockchain.TxRejection {
+	return addToMemoryPool(tx, chain, blockchain.ValidateTransaction(&tx, chain))
+}
+
+// coinbases of an import, the items are looked up in the set the importer collected once (see blockchain.ValidateIntroduction)
+func AcceptIntroductionToMemoryPool(tx blockchain.Tx, chainItems map[string]bool, chain *blockchain.BlockChain) *blockchain.TxRejection {
+	return addToMemoryPool(tx, chain, blockchain.ValidateIntroduction(&tx, chainItems, chain))
+}
+
+// pool checks of a transaction that went through validation with the given result
+func addToMemoryPool(tx blockchain.Tx, chain *blockchain.BlockChain, rejection *blockchain.TxRejection) *blockchain.TxRejection {
+	if rejection != nil && rejection.Code != blockchain.REJECT_LOCKED {
+		return rejection
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	txID := hex.EncodeToString(tx.TxID)
+	if rejection != nil {
+		if _, exists := LockedTxs[txID]; exists {
+			return blockchain.Reject(blockchain.REJECT_DUPLICATE, "transaction is already held until its lock expires")
+		}
+		LockedTxs[txID] = tx
+		return nil
+	}
+	if _, exists := MemoryPool[txID]; exists {
+		return blockchain.Reject(blockchain.REJECT_DUPLICATE, "transaction already exists in the memory pool")
+	}
+	// sellers of a swap sign their own copies, keep every signature collected so far
+	if pendingTx, exists := PendingOffers[txID]; exists && tx.Type == blockchain.TX_SWAP {
+		if tx.MergeSigners(&pendingTx) == 0 && len(tx.Signers) == len(pendingTx.Signers) {
+			return blockchain.Reject(blockchain.REJECT_DUPLICATE, "swap with the same signatures already exists in the offer pool")
+		}
+	} else if exists && tx.IsPending() {
+		return blockchain.Reject(blockchain.REJECT_DUPLICATE, "transaction already exists in the offer pool")
+	}
+
+	// an item can only move once per block, the first transaction to arrive wins
+	// assemblies and disassemblies move their parts as well
+	for poolTxID, poolTx := range MemoryPool {
+		for _, involvedItem := range tx.InvolvedItems() {
+			if poolTx.InvolvesItem(involvedItem) {
+				return blockchain.Reject(blockchain.REJECT_MEMPOOL_CONFLICT, "item "+hex.EncodeToString(involvedItem)+" is already moved by transaction "+poolTxID+" in the memory pool")
+			}
+		}
+	}
+
+	// a validator votes once on a change, the chain ignores a second vote
+	if tx.IsValidatorVote() {
+		for poolTxID, poolTx := range MemoryPool {
+			if poolTx.Type == tx.Type && bytes.Equal(poolTx.SellerHash, tx.SellerHash) && bytes.Equal(poolTx.BuyerHash, tx.BuyerHash) {
+				return blockchain.Reject(blockchain.REJECT_MEMPOOL_CONFLICT, "validator already voted for this change with transaction "+poolTxID+" in the memory pool")
+			}
+		}
+	}
+
+	// introductions and credit transfers already in the pool spend the same credits
+	if payer, debit := tx.CreditDebit(chain.Params); debit != 0 {
+		credits, err := chain.CreditBalance(payer)
+		if err != nil {
+			return blockchain.Reject(blockchain.REJECT_INTERNAL, err.Error())
+		}
+		for _, poolTx := range MemoryPool {
+			if poolPayer, poolDebit := poolTx.CreditDebit(chain.Params); poolDebit != 0 && bytes.Equal(poolPayer, payer) {
+				debit += poolDebit
+			}
+		}
+		if int64(debit) > credits {
+			return blockchain.Reject(blockchain.REJECT_INSUFFICIENT_FUNDS, fmt.Sprintf("%d credits are held, %d are needed by this and pooled transactions", credits, debit))
+		}
+	}
+
+	if tx.IsPending() {
+		PendingOffers[txID] = tx
+		return nil
+	}
+	MemoryPool[txID] = tx
+	delete(PendingOffers, txID)
+	return nil
+}
+
+// moves time locked transactions whose lock has expired into the memory pool, and drops the ones that became invalid
+func PromoteMaturedTxs(chain *blockchain.BlockChain) {
+	mutex.Lock()
+	lockedTxs := LockedTxs
+	LockedTxs = make(map[string]blockchain.Tx)
+	mutex.Unlock()
+
+	for txID, tx := range lockedTxs {
+		if rejection := AcceptToMemoryPool(tx, chain); rejection != nil {
+			log.Printf("Dropped time locked transaction %s: %v", txID, rejection)
+		}
+	}
+}
+
+// copy of the memory pool, safe to use while peers keep adding transactions
+func MemoryPoolTxs() []blockchain.Tx {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var txs []blockchain.Tx
+	for _, tx := range MemoryPool {
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// drops the transactions of a block from the memory pool, everything else stays for the next block
+func RemoveFromMemoryPool(txs []blockchain.Tx) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, tx := range txs {
+		delete(MemoryPool, hex.EncodeToString(tx.TxID))
+	}
+}
+
+// offer waiting for the buyer's countersignature or the signatures of a swap's sellers
+func PendingOffer(txID string) (blockchain.Tx, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	offer, exists := PendingOffers[txID]
+	return offer, exists
+}
+
+// copy of the offer pool, safe to use while peers keep adding offers
+func PendingOfferTxs() []blockchain.Tx {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return poolTxs(PendingOffers)
+}
+
+// copy of the time locked transactions, safe to use while peers keep adding transactions
+func LockedPoolTxs() []blockchain.Tx {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return poolTxs(LockedTxs)
+}
+
+// drops a declined offer
+func RemovePendingOffer(txID string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	delete(PendingOffers, txID)
+}
+
+// transaction waiting in one of the pools, along with the name of the pool holding it
+func PooledTx(txID string) (*blockchain.Tx, string, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if tx, exists := MemoryPool[txID]; exists {
+		return &tx, "pending", true
+	}
+	if tx, exists := PendingOffers[txID]; exists {
+		return &tx, "offer", true
+	}
+	if tx, exists := LockedTxs[txID]; exists {
+		return &tx, "locked", true
+	}
+	return nil, "", false
+}
+
+// pools written when the node stops, so transactions that were not mined yet survive a restart
+type savedPools struct {
+	MemoryPool    []blockchain.Tx
+	PendingOffers []blockchain.Tx
+	LockedTxs     []blockchain.Tx
+}
+
+func poolTxs(pool map[string]blockchain.Tx) []blockchain.Tx {
+	var txs []blockchain.Tx
+	for _, tx := range pool {
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func SaveMemoryPool(poolFile string) error {
+	mutex.Lock()
+	pools := savedPools{
+		MemoryPool:    poolTxs(MemoryPool),
+		PendingOffers: poolTxs(PendingOffers),
+		LockedTxs:     poolTxs(LockedTxs),
+	}
+	mutex.Unlock()
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(pools); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(poolFile, encoded.Bytes(), 0644)
+}
+
+// reads the pools saved by the last run back, every transaction is validated again since blocks may have been added since
+func LoadMemoryPool(poolFile string, chain *blockchain.BlockChain) (int, error) {
+	fileContent, err := ioutil.ReadFile(poolFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var pools savedPools
+	if err := gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&pools); err != nil {
+		return 0, fmt.Errorf("%s: %v", poolFile, err)
+	}
+
+	restored := 0
+	for _, pool := range [][]blockchain.Tx{pools.MemoryPool, pools.PendingOffers, pools.LockedTxs} {
+		for _, tx := range pool {
+			if rejection := AcceptToMemoryPool(tx, chain); rejection != nil {
+				log.Printf("Dropped saved transaction %x: %v", tx.TxID, rejection)
+				continue
+			}
+			restored++
+		}
+	}
+	return restored, nil
+}
@@ -2,6 +2,8 @@ package p2p
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"encoding/json"
@@ -12,6 +14,7 @@ import (
 	"net"
 	"os"
 	"sync"
+	"time"
 
 	//internal inports
 
@@ -31,26 +34,50 @@ const (
 	NODE_NETWORK_LIMITED = 0x0400 // same as node network but node has at least last (some amount of blocks to be decided)
 	commandLength        = 12     // command will have 12 bytes
 	protocol             = "tcp"
+	KNOWN_NODES_FILE     = "./p2p/known_nodes.json"
+	CONNECTION_TIMEOUT   = 30 * time.Second // longest a peer may take to send its message
+	DIAL_TIMEOUT         = 5 * time.Second  // unreachable peers are dropped after this long
+	SYNC_INTERVAL        = time.Minute      // between version messages to the known nodes
 )
 
 var (
 	// set initial knownNode
-	KnownNodes  = []string{} // list of all the knownNodes
-	nodeAddress string       // address of this node
+	KnownNodes   = []string{} // list of all the knownNodes
+	nodeAddress  string       // advertised address of this node, the one peers dial
+	networkMagic [4]byte      // magic of the network this node is on, prefixes every message
 
 	// here string is the transaction id and it point to the actual transaction
-	MemoryPool      = make(map[string]blockchain.Tx)
-	blocksInTransit [][]byte
+	MemoryPool = make(map[string]blockchain.Tx)
+	// blocks announced by a peer that are still to be fetched from it, oldest first, keyed by the peer address
+	blocksInTransit = make(map[string][][]byte)
+	// blocks of another branch received from a peer, oldest first, kept until the last block it announced arrives
+	sideBranches = make(map[string][]*blockchain.Block)
+	// blocks are added one at a time, the same block from two peers must not be added twice
+	blockMutex sync.Mutex
+
+	// transfer offers waiting for the buyer to accept or decline, keyed by transaction id
+	PendingOffers = make(map[string]blockchain.Tx)
+
+	// time locked transactions waiting for a block that may include them, keyed by transaction id
+	LockedTxs = make(map[string]blockchain.Tx)
+
+	// messages sent outside a connection handler that read the chain, eg. the version AddPeer sends
+	// Serve waits for them, so none is left running once the database closes
+	backgroundMutex   sync.Mutex
+	backgroundSends   sync.WaitGroup
+	backgroundStopped bool
 )
 
 // const for types
 // using integer rather than strings
 // well may need to serialize this too
 const (
-	BLOCK_TYPE   = 1
-	TX_TYPE      = 2
-	VERSION_TYPE = 3
-	INV_TYPE     = 4
+	BLOCK_TYPE         = 1
+	TX_TYPE            = 2
+	VERSION_TYPE       = 3
+	INV_TYPE           = 4
+	METADATA_TYPE      = 5
+	COMPACT_BLOCK_TYPE = 6 // getdata only, asks for a block as compact block
 )
 
 type MESSAGE_TYPE int
@@ -62,17 +89,31 @@ type Block struct {
 }
 
 // a version message that contains the version of the chain in the node
+// it opens the handshake, a peer handles no other message of the sender until it accepted the version, see checkVersion
 type Version struct {
-	Timestamp   uint64
-	AddressFrom string
-	Height      uint64
+	ProtocolVersion int
+	Services        uint64 // NODE_NETWORK and friends, what the sender can be asked for
+	ChainID         []byte // ChainParams.ID of the sender
+	Timestamp       uint64
+	AddressFrom     string
+	AddressTo       string // address the sender dialed, forgotten if it turns out to be the sender itself
+	Height          uint64
+	BestHash        []byte
+	UserAgent       string
+	Nonce           uint64 // random per run, see localNonce
 }
 
 // contains all the address of the connected nodes
 type Address struct {
+	AddrFrom string
 	AddrList []string
 }
 
+// asks a peer for the addresses it knows
+type GetAddress struct {
+	AddrFrom string
+}
+
 // request a particular data object from another node
 // Response to get data can be a tx, block,
 //TODO: add other if required
@@ -87,6 +128,7 @@ type GetBlocks struct {
 	AddrFrom string
 	Data     []byte
 	Height   uint64
+	Locator  [][]byte // hashes of our chain from the tip back to genesis, the peer answers with its blocks after the last one we share
 }
 
 // transaction wrapper
@@ -95,6 +137,20 @@ type Tx struct {
 	Transaction []byte
 }
 
+// metadata blob of an item, content addressed by its hash
+type Metadata struct {
+	AddrFrom string
+	Blob     []byte
+}
+
+// buyer's signed refusal of a pending transfer offer
+type Decline struct {
+	AddrFrom       string
+	TxID           []byte
+	BuyerPublicKey []byte
+	Signature      []byte
+}
+
 // For details follow this link: https://developer.bitcoin.org/reference/p2p_networking.html#inv
 type Inv struct {
 	AddrFrom string
@@ -127,27 +183,21 @@ func BytesToCommand(bytes []byte) string {
 // function to send all types of serialized data
 // will be called from other function for each specialized function
 func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
+	conn, err := dialPeer(addr)
 
 	if err != nil {
-		fmt.Printf("Node %s is not available\n", addr)
-		var updatedNodes []string
-		// if the address is not available, remove that node
-		for _, node := range KnownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-
-		KnownNodes = updatedNodes
-
+		fmt.Printf("Node %s is not available: %v\n", addr, err)
+		// the peer stays in the address book as stale and is tried again later, with a new handshake
+		markPeerFailed(addr)
+		forgetHandshake(addr)
 		return
 	}
 
 	defer conn.Close()
+	markPeerSeen(addr)
 
-	// send the data to the connection
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	// send the data to the connection, prefixed with the network magic so nodes of other networks drop it
+	_, err = io.Copy(conn, io.MultiReader(bytes.NewReader(networkMagic[:]), bytes.NewReader(data)))
 
 	if err != nil {
 		log.Panic(err)
@@ -162,6 +212,7 @@ func SendGetBlocks(addr string, chain *blockchain.BlockChain) {
 		AddrFrom: nodeAddress,
 		Data:     lastHash,
 		Height:   chain.GetHeight(),
+		Locator:  chain.BlockLocator(),
 	}
 	// first 12 character is command, rest is the payload
 	// check this link for more details
@@ -187,10 +238,20 @@ func SendBlock(addr string, block *blockchain.Block) {
 	sendData(addr, info)
 }
 
-func SendAddress(addr string, block *blockchain.Block) {
-	address := Address{AddrList: KnownNodes}
+// sends the reachable peers of the address book and this node itself, minus the receiver
+func SendAddress(addr string) {
+	address := Address{
+		AddrFrom: nodeAddress,
+		AddrList: append(shareableAddresses(addr), nodeAddress),
+	}
 
-	info := append(CommandToBytes("address"), GobEncode(address)...)
+	info := append(CommandToBytes("addr"), GobEncode(address)...)
+
+	sendData(addr, info)
+}
+
+func SendGetAddress(addr string) {
+	info := append(CommandToBytes("getaddr"), GobEncode(GetAddress{AddrFrom: nodeAddress})...)
 
 	sendData(addr, info)
 }
@@ -225,15 +286,72 @@ func SendTx(addr string, tx blockchain.Tx) {
 	sendData(addr, data)
 }
 
+// send a transfer offer that still needs the buyer's countersignature
+func SendOffer(addr string, tx blockchain.Tx) {
+	serializedData, err := tx.SerializeTxToGOB()
+
+	if err != nil {
+		fmt.Printf("Transaction serialization error: %s\n", err)
+		return
+	}
+	data := GobEncode(Tx{
+		AddrFrom:    nodeAddress,
+		Transaction: serializedData,
+	})
+
+	data = append(CommandToBytes("offer"), data...)
+	sendData(addr, data)
+}
+
+func SendDecline(addr string, txID []byte, buyerPublicKey []byte, signature []byte) {
+	data := GobEncode(Decline{
+		AddrFrom:       nodeAddress,
+		TxID:           txID,
+		BuyerPublicKey: buyerPublicKey,
+		Signature:      signature,
+	})
+
+	data = append(CommandToBytes("decline"), data...)
+	sendData(addr, data)
+}
+
+func SendMetadata(addr string, blob []byte) {
+	data := GobEncode(Metadata{
+		AddrFrom: nodeAddress,
+		Blob:     blob,
+	})
+
+	data = append(CommandToBytes("metadata"), data...)
+	sendData(addr, data)
+}
+
+// asks the peer for metadata blobs committed to by the given coinbase transactions that we do not store yet
+func requestMissingMetadata(addr string, txs []blockchain.Tx, chain *blockchain.BlockChain) {
+	for _, tx := range txs {
+		if tx.IsCoinbase() && len(tx.MetadataHash) != 0 && !chain.HasMetadata(tx.MetadataHash) {
+			sendGetData(addr, METADATA_TYPE, tx.MetadataHash)
+		}
+	}
+}
+
 func SendVersion(addr string, bChain *blockchain.BlockChain) {
 	height := bChain.GetHeight()
 	data := GobEncode(Version{
-		AddressFrom: nodeAddress,
-		Height:      height,
+		ProtocolVersion: PROTOCOL_VERSION,
+		Services:        LOCAL_SERVICES,
+		ChainID:         bChain.Params.ID(),
+		Timestamp:       uint64(time.Now().Unix()),
+		AddressFrom:     nodeAddress,
+		AddressTo:       addr,
+		Height:          height,
+		BestHash:        bChain.LastHash,
+		UserAgent:       USER_AGENT,
+		Nonce:           localNonce,
 	})
 
-	data = append(CommandToBytes("getversion"), data...)
+	data = append(CommandToBytes("version"), data...)
 
+	markVersionSent(addr)
 	sendData(addr, data)
 }
 
@@ -258,8 +376,8 @@ func sendInv(addr string, kind MESSAGE_TYPE, inventories [][]byte) {
 handle functions receives all the info and you guessed it handles all the encoded streams of data
 */
 
-// receives all the address from the network
-func HandleAddress(request []byte, chain *blockchain.BlockChain) {
+// receives addresses from a peer, they go to the address book and the new known nodes get our version
+func HandleAddress(request []byte, addrFrom string, chain *blockchain.BlockChain) {
 	//send address sends all the known nodes address, now we have to decode it
 	var buff bytes.Buffer
 	var payload Address
@@ -272,16 +390,36 @@ func HandleAddress(request []byte, chain *blockchain.BlockChain) {
 		log.Panic(err)
 	}
 
-	KnownNodes = append(KnownNodes, payload.AddrList...)
+	// a peer can not flood the book, anything past the limit of a reply is ignored
+	addrList := payload.AddrList
+	if len(addrList) > MAX_ADDR_SEND+1 {
+		addrList = addrList[:MAX_ADDR_SEND+1]
+	}
+	utility.Debugf("Received %d addresses from %s", len(addrList), addrFrom)
+
+	for _, node := range addKnownNodes(addrList, SOURCE_ADDR) {
+		// greeting the new known nodes compares our chains and tells them about us
+		SendVersion(node, chain)
+	}
+}
+
+// answers with the addresses this node knows
+func HandleGetAddress(request []byte, addrFrom string) {
+	var buff bytes.Buffer
+	var payload GetAddress
+
+	buff.Write(request[commandLength:])
+	err := gob.NewDecoder(&buff).Decode(&payload)
 
-	for _, node := range KnownNodes {
-		// request blocks with all the nodes that we have recieved
-		SendGetBlocks(node, chain)
+	if err != nil {
+		log.Panic(err)
 	}
+
+	SendAddress(addrFrom)
 }
 
 // adds the received block to the chain
-func HandleBlock(request []byte, bChain *blockchain.BlockChain) {
+func HandleBlock(request []byte, addrFrom string, bChain *blockchain.BlockChain) {
 	var buff bytes.Buffer
 	var payload Block
 
@@ -292,33 +430,175 @@ func HandleBlock(request []byte, bChain *blockchain.BlockChain) {
 		log.Panic(err)
 	}
 
-	// TODO: Implement Add block to blockchain method
 	fmt.Printf("Received a block of hash: %x\n", payload.Block.BlockHash)
+	block := &payload.Block
+
+	blockMutex.Lock()
+	defer blockMutex.Unlock()
+
+	// a block we have is neither added nor relayed again, which ends its relay here
+	if bChain.HasBlock(block.BlockHash) {
+		fetchNextInTransit(addrFrom)
+		return
+	}
+
+	if !bytes.Equal(block.PreviousHash, bChain.LastHash) {
+		collectBranch(block, addrFrom, bChain)
+		return
+	}
+	delete(sideBranches, addrFrom)
+	if err := connectBlock(block, addrFrom, bChain); err != nil {
+		utility.Warnf("Rejected block %x from %s: %v", block.BlockHash, addrFrom, err)
+		delete(blocksInTransit, addrFrom)
+		return
+	}
 
-	blockHashes := bChain.GetBlockHashesFromHeight(payload.Block.Height - 1)
+	// while catching up only the last block, our new tip, is announced
+	if len(blocksInTransit[addrFrom]) == 0 {
+		relayBlock(block, addrFrom)
+	}
+	fetchNextInTransit(addrFrom)
+}
 
-	if len(blockHashes) != 0 {
-		lastHash := blockHashes[len(blockHashes)-1]
+// compact blocks are only rebuilt on top of the tip, a block further ahead makes the node ask the peer for the blocks in between
+// the locator sent along gets blocks of another branch as full blocks, see collectBranch
+func extendsTip(block *blockchain.Block, addrFrom string, chain *blockchain.BlockChain) bool {
+	if bytes.Equal(block.PreviousHash, chain.LastHash) {
+		return true
+	}
+	if block.Height > chain.GetHeight() {
+		utility.Infof("Block %x at height %d does not extend our tip, syncing with %s", block.BlockHash, block.Height, addrFrom)
+		SendGetBlocks(addrFrom, chain)
+	} else {
+		utility.Warnf("Dropping block %x at height %d from %s, it is on a branch not longer than ours", block.BlockHash, block.Height, addrFrom)
+	}
+	return false
+}
+
+// adds the block to the chain and takes its transactions out of the pools
+// must be called with blockMutex held
+func connectBlock(block *blockchain.Block, addrFrom string, chain *blockchain.BlockChain) error {
+	if err := chain.AddBlock(block); err != nil {
+		return err
+	}
+	// the transactions are committed now, nobody has to mine them again
+	txs := blockTxs(block)
+	RemoveFromMemoryPool(txs)
+	requestMissingMetadata(addrFrom, txs, chain)
+	PromoteMaturedTxs(chain)
+	return nil
+}
 
-		if !bytes.Equal(payload.Block.PreviousHash, lastHash) {
-			log.Printf("Chain of this node invalid at height: %d", payload.Block.Height-1)
-			os.Exit(69)
-			blocksInTransit = [][]byte{} // empty blocks in transit
+// blocks that do not extend the tip are kept per peer until the last one it announced arrives,
+// the node then reorganizes to the branch if it is longer than the main chain
+// must be called with blockMutex held
+func collectBranch(block *blockchain.Block, addrFrom string, chain *blockchain.BlockChain) {
+	branch := sideBranches[addrFrom]
+	switch {
+	case len(branch) != 0 && bytes.Equal(block.PreviousHash, branch[len(branch)-1].BlockHash):
+		sideBranches[addrFrom] = append(branch, block)
+	case chain.HasBlock(block.PreviousHash):
+		sideBranches[addrFrom] = []*blockchain.Block{block}
+	default:
+		// the blocks in between are missing, the locator tells the peer where our chains split
+		delete(sideBranches, addrFrom)
+		delete(blocksInTransit, addrFrom)
+		if block.Height > chain.GetHeight() {
+			utility.Infof("Block %x at height %d does not extend our tip, syncing with %s", block.BlockHash, block.Height, addrFrom)
+			SendGetBlocks(addrFrom, chain)
+		} else {
+			utility.Warnf("Dropping block %x at height %d from %s, it is on a branch not longer than ours", block.BlockHash, block.Height, addrFrom)
 		}
+		return
 	}
 
-	bChain.AddBlock(&payload.Block)
+	if len(blocksInTransit[addrFrom]) != 0 {
+		fetchNextInTransit(addrFrom)
+		return
+	}
+	branch = sideBranches[addrFrom]
+	delete(sideBranches, addrFrom)
+	// the difficulty only depends on the height, the longer chain is the one with the most work
+	if block.Height <= chain.GetHeight() {
+		utility.Warnf("Dropping %d blocks up to %x at height %d from %s, they are on a branch not longer than ours", len(branch), block.BlockHash, block.Height, addrFrom)
+		return
+	}
+	reorganize(branch, addrFrom, chain)
+}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, BLOCK_TYPE, blockHash)
+// makes the branch our chain, the transactions of the blocks taken off go back to the memory pool
+// must be called with blockMutex held
+func reorganize(branch []*blockchain.Block, addrFrom string, chain *blockchain.BlockChain) {
+	disconnected, err := chain.Reorganize(branch)
+	if err != nil {
+		utility.Warnf("Rejected the branch of %d blocks from %s: %v", len(branch), addrFrom, err)
+		return
+	}
+	tip := branch[len(branch)-1]
+	utility.Infof("Reorganized to the branch of %s, %d blocks taken off and %d added up to %x at height %d", addrFrom, len(disconnected), len(branch), tip.BlockHash, tip.Height)
 
-		blocksInTransit = blocksInTransit[1:]
+	for _, block := range branch {
+		txs := blockTxs(block)
+		RemoveFromMemoryPool(txs)
+		requestMissingMetadata(addrFrom, txs, chain)
+	}
+	// transactions only the old branch committed are still valid unless the new one spent the same items
+	for _, block := range disconnected {
+		for _, tx := range blockTxs(block) {
+			if rejection := AcceptToMemoryPool(tx, chain); rejection != nil {
+				utility.Debugf("Dropping transaction %x of the disconnected block %x: %v", tx.TxID, block.BlockHash, rejection)
+			}
+		}
+	}
+	PromoteMaturedTxs(chain)
+	relayBlock(tip, addrFrom)
+}
+
+func blockTxs(block *blockchain.Block) []blockchain.Tx {
+	var txs []blockchain.Tx
+	if !block.IsEmpty() {
+		for _, txNode := range block.TxMerkleTree.LeafNodes {
+			txs = append(txs, txNode.Transaction)
+		}
 	}
+	return txs
+}
+
+// blocks we have already are skipped, the rest is fetched from the peer one after another, oldest first
+func fetchBlocks(addrFrom string, blockHashes [][]byte, chain *blockchain.BlockChain) {
+	blockMutex.Lock()
+	defer blockMutex.Unlock()
+
+	var inTransit [][]byte
+	for _, blockHash := range blockHashes {
+		if !chain.HasBlock(blockHash) {
+			inTransit = append(inTransit, blockHash)
+		}
+	}
+	// a single new block is most likely a tip whose transactions we hold already
+	if len(inTransit) == 1 && peerProtocolVersion(addrFrom) >= COMPACT_BLOCKS_VERSION {
+		delete(blocksInTransit, addrFrom)
+		sendGetData(addrFrom, COMPACT_BLOCK_TYPE, inTransit[0])
+		return
+	}
+	blocksInTransit[addrFrom] = inTransit
+	fetchNextInTransit(addrFrom)
+}
+
+// asks the peer for the next block of the batch it announced
+// must be called with blockMutex held
+func fetchNextInTransit(addrFrom string) {
+	inTransit := blocksInTransit[addrFrom]
+	if len(inTransit) == 0 {
+		delete(blocksInTransit, addrFrom)
+		return
+	}
+	blocksInTransit[addrFrom] = inTransit[1:]
+	sendGetData(addrFrom, BLOCK_TYPE, inTransit[0])
 }
 
 // response to get block request
-func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) {
+func HandleGetBlocks(request []byte, addrFrom string, chain *blockchain.BlockChain) {
 	var buff bytes.Buffer
 	var payload GetBlocks
 
@@ -329,14 +609,18 @@ func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) {
 		log.Panic(err)
 	}
 
-	// TODO: Implement get blockhashes where it gets all the blocks
-	// either from particular version or entire chain hashes
-	blocks := chain.GetBlockHashes(payload.Data)
+	// the locator finds the block our chains share even when the peer's tip is on another branch
+	var blocks [][]byte
+	if len(payload.Locator) != 0 {
+		blocks = chain.HashesAfterLocator(payload.Locator)
+	} else {
+		blocks = chain.GetBlockHashes(payload.Data)
+	}
 	fmt.Println(string(buff.Bytes()))
-	sendInv(payload.AddrFrom, BLOCK_TYPE, blocks)
+	sendInv(addrFrom, BLOCK_TYPE, blocks)
 }
 
-func HandleGetData(request []byte, chain *blockchain.BlockChain) {
+func HandleGetData(request []byte, addrFrom string, chain *blockchain.BlockChain) {
 	var buff bytes.Buffer
 	var payload GetData
 
@@ -346,7 +630,6 @@ func HandleGetData(request []byte, chain *blockchain.BlockChain) {
 	if err != nil {
 		log.Panic(err)
 	}
-
 	if payload.Type == BLOCK_TYPE {
 		block, err := chain.GetBlock([]byte(payload.Data))
 
@@ -355,20 +638,46 @@ func HandleGetData(request []byte, chain *blockchain.BlockChain) {
 			return
 		}
 
-		SendBlock(payload.AddrFrom, block)
+		SendBlock(addrFrom, block)
+	}
+
+	if payload.Type == COMPACT_BLOCK_TYPE {
+		block, err := chain.GetBlock(payload.Data)
+		if err != nil {
+			return
+		}
+
+		SendCompactBlock(addrFrom, block)
 	}
 
 	if payload.Type == TX_TYPE {
-		txId := hex.EncodeToString(payload.Data)
-		tx := MemoryPool[txId]
+		tx, _, ok := PooledTx(hex.EncodeToString(payload.Data))
+		if !ok {
+			return
+		}
+
+		// offers travel through their own command, peers never take them into the memory pool
+		if tx.IsPending() {
+			SendOffer(addrFrom, *tx)
+		} else {
+			SendTx(addrFrom, *tx)
+		}
+	}
 
-		SendTx(payload.AddrFrom, tx)
+	if payload.Type == METADATA_TYPE {
+		blob, err := chain.GetMetadata(payload.Data)
+		if err != nil {
+			return
+		}
+
+		SendMetadata(addrFrom, blob)
 	}
 }
 
-func HandleVersion(request []byte, chain *blockchain.BlockChain) {
+// stores a metadata blob, but only if some known transaction commits to it
+func HandleMetadata(request []byte, addrFrom string, chain *blockchain.BlockChain) {
 	var buff bytes.Buffer
-	var payload Version
+	var payload Metadata
 
 	buff.Write(request[commandLength:])
 	err := gob.NewDecoder(&buff).Decode(&payload)
@@ -377,30 +686,92 @@ func HandleVersion(request []byte, chain *blockchain.BlockChain) {
 		log.Panic(err)
 	}
 
-	// height on the current chain
-	bestHeight := chain.GetHeight()
+	blobHash := sha256.Sum256(payload.Blob)
+	referenced := false
+	for _, tx := range MemoryPoolTxs() {
+		if bytes.Equal(tx.MetadataHash, blobHash[:]) {
+			referenced = true
+		}
+	}
+	var minedCoinbases []*blockchain.Tx
+	for _, tx := range chain.AllCoinBaseTxs() {
+		if bytes.Equal(tx.MetadataHash, blobHash[:]) {
+			referenced = true
+			minedCoinbases = append(minedCoinbases, tx)
+		}
+	}
+	if !referenced {
+		log.Printf("Ignoring unreferenced metadata %x from %s", blobHash, addrFrom)
+		return
+	}
 
-	// height of received chain
-	otherheight := payload.Height
+	_, err = chain.StoreMetadata(payload.Blob)
+	utility.ErrThenLogPanic(err)
 
-	// if the best height is less than the height on the network then request get blocks
-	if bestHeight < otherheight {
-		fmt.Println("Sending Get block request")
-		SendGetBlocks(payload.AddressFrom, chain)
-	} else if bestHeight > otherheight {
-		fmt.Println("Sending version of the current block")
-		SendVersion(payload.AddressFrom, chain)
-	} else {
-		fmt.Printf("Same block height: %d", chain.GetHeight())
+	// the blocks of these coinbases were indexed without the blob
+	for _, tx := range minedCoinbases {
+		if err := chain.IndexCoinbaseMetadata(tx); err != nil {
+			utility.Errorf("Could not index the metadata of item %x: %v", tx.ItemHash, err)
+		}
+	}
+}
+
+func HandleVersion(request []byte, addrFrom string, nodeID string, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload Version
+
+	buff.Write(request[commandLength:])
+	err := gob.NewDecoder(&buff).Decode(&payload)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if rejection := checkVersion(&payload, chain); rejection != nil {
+		log.Printf("Disconnecting %s (%s): %v", addrFrom, payload.UserAgent, rejection)
+		forgetPeer(addrFrom)
+		if payload.Nonce == localNonce {
+			// the address we dialed is one of our own
+			forgetPeer(payload.AddressTo)
+		}
+		return
 	}
+	acked, completed, sendOwn := acceptVersion(addrFrom, nodeID, &payload)
+	SendVerack(addrFrom)
 
 	// if nodes are not known add them to known nodes
-	if !contains(KnownNodes, payload.AddressFrom) {
-		KnownNodes = append(KnownNodes, payload.AddressFrom)
+	addKnownNodes([]string{addrFrom}, SOURCE_INBOUND)
+	markPeerSeen(addrFrom)
+
+	if completed {
+		handshakeCompleted(addrFrom, &payload, chain)
+	} else if acked {
+		syncWith(addrFrom, &payload, chain)
+	} else if sendOwn {
+		SendVersion(addrFrom, chain)
 	}
 }
 
-func HandleTx(request []byte, chain *blockchain.BlockChain, wlt *wallet.Wallet) {
+// the peer accepted our version, the chains are compared once the handshake completed in both directions
+func HandleVerack(request []byte, addrFrom string, nodeID string, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload Verack
+
+	buff.Write(request[commandLength:])
+	err := gob.NewDecoder(&buff).Decode(&payload)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	version := acceptVerack(addrFrom, nodeID)
+	if version == nil {
+		return
+	}
+	handshakeCompleted(addrFrom, version, chain)
+}
+
+func HandleTx(request []byte, addrFrom string, chain *blockchain.BlockChain, wlt *wallet.Wallet) {
 	var buff bytes.Buffer
 	var payload Tx
 
@@ -417,40 +788,73 @@ func HandleTx(request []byte, chain *blockchain.BlockChain, wlt *wallet.Wallet)
 		return
 	}
 
-	txHash, err := tx.CalculateTxHash()
-	MemoryPool[hex.EncodeToString(txHash)] = *tx
+	// offers travel through the offer command, never through the memory pool
+	if tx.IsPending() {
+		return
+	}
 
-	if nodeAddress == KnownNodes[0] {
-		for _, node := range KnownNodes {
-			if node != nodeAddress && node != payload.AddrFrom {
-				sendInv(node, TX_TYPE, [][]byte{txHash})
-			}
-		}
-	} else {
-		if len(MemoryPool) >= 2 {
-			// txPool := []blockchain.Tx{}
+	if rejection := AcceptToMemoryPool(*tx, chain); rejection != nil {
+		log.Printf("Rejected transaction %x from %s: %v", tx.TxID, addrFrom, rejection)
+		return
+	}
+	requestMissingMetadata(addrFrom, []blockchain.Tx{*tx}, chain)
+	relayTx(*tx, addrFrom)
+}
 
-			// for _, tx := range MemoryPool {
-			// 	txPool = append(txPool, tx)
-			// }
+// stores a transfer offer so the buyer can later accept or decline it
+func HandleOffer(request []byte, addrFrom string, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload Tx
 
-			// block := blockchain.CreateBlock()
-			// block.AddTransactionsToBlock(txPool)
-			// err := block.MineBlock(chain, wlt)
-			// utility.ErrThenLogPanic(err)
-			// chain.AddBlock(block)
+	buff.Write(request[commandLength:])
+	err := gob.NewDecoder(&buff).Decode(&payload)
 
-			// for _, nodes := range KnownNodes {
-			// 	SendBlock(nodes, block)
-			// }
+	if err != nil {
+		log.Panic(err)
+	}
 
-			// // empty memory pool
-			// MemoryPool = map[string]blockchain.Tx{}
-		}
+	tx, err := blockchain.DeserializeTxFromGOB(payload.Transaction)
+	if err != nil {
+		return
+	}
+
+	if !tx.IsPending() {
+		return
+	}
+
+	if rejection := AcceptToMemoryPool(*tx, chain); rejection != nil {
+		log.Printf("Rejected offer %x from %s: %v", tx.TxID, addrFrom, rejection)
+		return
+	}
+	relayTx(*tx, addrFrom)
+}
+
+// removes a pending offer once the buyer's refusal has been verified
+func HandleDecline(request []byte) {
+	var buff bytes.Buffer
+	var payload Decline
+
+	buff.Write(request[commandLength:])
+	err := gob.NewDecoder(&buff).Decode(&payload)
+
+	if err != nil {
+		log.Panic(err)
 	}
+
+	txID := hex.EncodeToString(payload.TxID)
+	offer, ok := PendingOffer(txID)
+	if !ok {
+		return
+	}
+
+	if err := blockchain.VerifyDecline(&offer, payload.BuyerPublicKey, payload.Signature); err != nil {
+		log.Printf("Ignoring unverified decline for offer %s: %v", txID, err)
+		return
+	}
+	RemovePendingOffer(txID)
 }
 
-func HandleInv(request []byte) {
+func HandleInv(request []byte, addrFrom string, chain *blockchain.BlockChain) {
 	buff := bytes.NewBuffer(request[commandLength:])
 	var payload Inv
 
@@ -469,10 +873,12 @@ func HandleInv(request []byte) {
 	// can remove this one later
 	// if log needs to be created then may need to use this one
 	typeStringMap := map[MESSAGE_TYPE]string{
-		BLOCK_TYPE:   "BLOCK",
-		TX_TYPE:      "TX",
-		VERSION_TYPE: "VERSION",
-		INV_TYPE:     "INV",
+		BLOCK_TYPE:         "BLOCK",
+		TX_TYPE:            "TX",
+		VERSION_TYPE:       "VERSION",
+		INV_TYPE:           "INV",
+		METADATA_TYPE:      "METADATA",
+		COMPACT_BLOCK_TYPE: "COMPACT_BLOCK",
 	}
 	fmt.Printf("%x\n", buff.Bytes())
 	log.Printf("Received %d inventories of type %s", len(payload.Data), typeStringMap[payload.Type])
@@ -482,49 +888,63 @@ func HandleInv(request []byte) {
 	}
 
 	if payload.Type == BLOCK_TYPE {
-		blocksInTransit = payload.Data
-
-		if len(payload.Data) != 0 {
-			blockHash := payload.Data[0]
-			sendGetData(payload.AddrFrom, BLOCK_TYPE, blockHash)
-
-			// this section is equivalent to blocksInTransit.remove(blockHash)
-			// insert all the items in the chain in blocksInTransit and the operate it later so that it gets appended to the chain
-			newInTransit := [][]byte{}
-			for _, b := range blocksInTransit {
-				// add all the nodex except blockHash to blocksInTransit
-				if bytes.Compare(b, blockHash) != 0 {
-					newInTransit = append(newInTransit, b)
-				}
-			}
-			blocksInTransit = newInTransit
-		}
+		fetchBlocks(addrFrom, payload.Data, chain)
 	}
 
 	if payload.Type == TX_TYPE {
-		txID := payload.Data[0]
-		tx := MemoryPool[hex.EncodeToString(txID)]
-		txByte, _ := tx.SerializeTxToGOB()
-		if txByte == nil {
-			sendGetData(payload.AddrFrom, TX_TYPE, txID)
+		for _, txID := range payload.Data {
+			// anything relayed or already pooled here is not fetched again, which ends the relay
+			if _, _, pooled := PooledTx(hex.EncodeToString(txID)); pooled || inventorySeen(TX_TYPE, txID) {
+				continue
+			}
+			sendGetData(addrFrom, TX_TYPE, txID)
 		}
 	}
 
 }
 
 func HandleConnection(conn net.Conn, chain *blockchain.BlockChain, wlt *wallet.Wallet) {
+	defer conn.Close()
+
+	// over encrypted connections the peer proved its node key, plaintext peers have none
+	nodeID, err := acceptPeer(conn)
+	if err != nil {
+		utility.Debugf("Dropping connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
 	// Reader is interface with read method
 	req, err := ioutil.ReadAll(conn)
 
-	defer conn.Close()
 	if err != nil {
 		log.Panic(err)
 	}
 
+	if IsBanned(conn.RemoteAddr().String()) {
+		utility.Debugf("Dropping message from banned peer %s", conn.RemoteAddr())
+		return
+	}
+
+	// every request starts with the network magic, followed by 12 characters of command and the load
+	if len(req) < len(networkMagic)+commandLength || !bytes.Equal(req[:len(networkMagic)], networkMagic[:]) {
+		fmt.Printf("Dropping message from %s, it is not for the %s network\n", conn.RemoteAddr(), chain.Params.Name)
+		return
+	}
+	req = req[len(networkMagic):]
+	addrFrom := senderAddress(peerHost(conn.RemoteAddr().String()), claimedSender(req))
+
 	// get the required command
 	// each request's first 12 characters is a command and rest is the load
 	command := BytesToCommand(req[:12])
-	fmt.Println(command)
+	utility.Debugf("Received %s from %s", command, conn.RemoteAddr())
+
+	// nothing but the handshake is handled before the version of the peer was accepted
+	// and over encrypted connections only from the node key that sent the version
+	if command != "version" && command != "verack" && !handshakeAccepted(addrFrom, nodeID) {
+		utility.Debugf("Dropping %s from %s, it did not send an accepted version", command, addrFrom)
+		return
+	}
+
 	switch command {
 	default:
 		fmt.Println("Unknown command")
@@ -532,39 +952,79 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain, wlt *wallet.W
 
 	case "inv":
 		fmt.Println("Receiving inventory")
-		HandleInv(req)
+		HandleInv(req, addrFrom, chain)
+
+	case "version":
+		fmt.Println("Receiving version")
+		HandleVersion(req, addrFrom, nodeID, chain)
 
-	case "getversion":
-		fmt.Println("Sending version")
-		HandleVersion(req, chain)
+	case "verack":
+		HandleVerack(req, addrFrom, nodeID, chain)
 
 	case "getdata":
 		fmt.Println("Sending data of a type")
-		HandleGetData(req, chain)
+		HandleGetData(req, addrFrom, chain)
 		break
 
 	case "tx":
 		fmt.Println("Receiving a Transaction")
-		HandleTx(req, chain, wlt)
+		HandleTx(req, addrFrom, chain, wlt)
 		break
 
-	case "address":
+	case "offer":
+		fmt.Println("Receiving a transfer offer")
+		HandleOffer(req, addrFrom, chain)
+
+	case "decline":
+		fmt.Println("Receiving a declined transfer offer")
+		HandleDecline(req)
+
+	case "metadata":
+		fmt.Println("Receiving item metadata")
+		HandleMetadata(req, addrFrom, chain)
+
+	case "addr":
+		fmt.Println("Receiving known addresses")
+		HandleAddress(req, addrFrom, chain)
+
+	case "getaddr":
 		fmt.Println("Sending known addresses")
-		HandleAddress(req, chain)
-		break
+		HandleGetAddress(req, addrFrom)
 
 	case "block":
 		fmt.Println("Receiving a block")
-		HandleBlock(req, chain)
+		HandleBlock(req, addrFrom, chain)
 		break
 
 	case "getblocks":
-		HandleGetBlocks(req, chain)
+		HandleGetBlocks(req, addrFrom, chain)
 		break
 
+	case "cmpctblock":
+		fmt.Println("Receiving a compact block")
+		HandleCompactBlock(req, addrFrom, chain)
+
+	case "getblocktxn":
+		HandleGetBlockTxn(req, addrFrom, chain)
+
+	case "blocktxn":
+		fmt.Println("Receiving transactions of a compact block")
+		HandleBlockTxn(req, addrFrom, chain)
+
 	}
 }
 
+// address to answer a peer on, the host it connected from joined with the port it claims to listen on
+// the claimed host is ignored, a peer can not make this node send data to some other machine this way
+// and peers behind NAT are still reachable at the port they forwarded
+func senderAddress(remoteHost string, claimed string) string {
+	_, port, err := net.SplitHostPort(claimed)
+	if err != nil || port == "" || remoteHost == "" {
+		return ""
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
+
 func contains(array []string, val string) bool {
 	for _, elem := range array {
 		if elem == val {
@@ -590,55 +1050,124 @@ func GobEncode(data interface{}) []byte {
 	return buff.Bytes()
 }
 
-func readKnownNodesFromJSON() {
-	// create known nodes json
-	knownNodesByte, err := os.ReadFile("./p2p/known_nodes.json")
-	utility.ErrThenLogPanic(err)
-
-	var payload map[string]interface{}
-
-	KnownNodes = []string{}
-
-	err = json.Unmarshal(knownNodesByte, &payload)
-	utility.ErrThenLogPanic(err)
-
-	knownNodesList := payload["nodes"].([]interface{})
+// seed nodes of the known nodes file, {"nodes": ["host:port", ...]}
+func readKnownNodesFromJSON() ([]string, error) {
+	knownNodesByte, err := os.ReadFile(KNOWN_NODES_FILE)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, node := range knownNodesList {
-		KnownNodes = append(KnownNodes, node.(string))
+	var payload struct {
+		Nodes []string `json:"nodes"`
+	}
+	if err := json.Unmarshal(knownNodesByte, &payload); err != nil {
+		return nil, fmt.Errorf("%s: %v", KNOWN_NODES_FILE, err)
 	}
+	return payload.Nodes, nil
 }
 
-func StartServer(nodeId string, chain *blockchain.BlockChain, wlt *wallet.Wallet) {
-	fmt.Println("p2p server started at port: ", nodeId)
-	nodeAddress = fmt.Sprintf("%s:%s", utility.GetNodeAddress(), nodeId)
-	// minerAddress = minerAddress
-	ln, err := net.Listen(protocol, nodeAddress)
-
-	readKnownNodesFromJSON()
-
+// listens on the host:port, an empty host, 0.0.0.0 or [::] listen on every interface
+// peers are told to reach this node at the advertise address, which is what they store and dial
+func Listen(listenAddress string, advertiseAddress string, chain *blockchain.BlockChain) (net.Listener, error) {
+	networkMagic = chain.Params.Magic
+	if _, _, err := net.SplitHostPort(advertiseAddress); err != nil {
+		return nil, fmt.Errorf("bad advertise address %q: %v", advertiseAddress, err)
+	}
+	ln, err := listenPeers(listenAddress)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	defer ln.Close()
+	nodeAddress = advertiseAddress
+	utility.Infof("p2p server listening on %s, advertised as %s", ln.Addr(), nodeAddress)
+	if Encrypted() {
+		utility.Infof("Peer connections are encrypted, node id %s", LocalNodeID())
+	}
+	return ln, nil
+}
 
-	// TODO: this is just for testing phase fix later
-	// TODO: just loop thoughout the known nodes and ask for version
-	if nodeAddress != KnownNodes[0] {
-		for _, node := range KnownNodes {
-			if node != nodeAddress {
-				SendVersion(node, chain)
+// handles the connections of peers until the context is cancelled, then waits for the messages already received
+func Serve(ctx context.Context, ln net.Listener, chain *blockchain.BlockChain, wlt *wallet.Wallet) error {
+	// closing the listener makes Accept return
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var connections sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
 			}
+			utility.Errorf("Could not accept a connection: %v", err)
+			time.Sleep(time.Second)
+			continue
 		}
+		// a peer that never finishes its message can not hold up the shutdown
+		conn.SetDeadline(time.Now().Add(CONNECTION_TIMEOUT))
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			defer recoverPeerPanic(conn.RemoteAddr().String())
+			HandleConnection(conn, chain, wlt)
+		}()
 	}
 
-	// chain.PrintChain()
+	connections.Wait()
+	waitBackgroundSends()
+	utility.Infof("p2p server stopped")
+	return nil
+}
+
+// asks the known nodes for their version now and every SYNC_INTERVAL, nodes on different heights then exchange blocks
+func Sync(ctx context.Context, chain *blockchain.BlockChain) {
+	ticker := time.NewTicker(SYNC_INTERVAL)
+	defer ticker.Stop()
+
 	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Panic(err)
+		for _, node := range Peers() {
+			func() {
+				defer recoverPeerPanic(node)
+				SendVersion(node, chain)
+			}()
 		}
-		go HandleConnection(conn, chain, wlt)
 
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runs the send in the background like a connection handler, nothing is sent once the p2p server stopped
+func goSend(peer string, send func()) {
+	backgroundMutex.Lock()
+	defer backgroundMutex.Unlock()
+
+	if backgroundStopped {
+		return
+	}
+	backgroundSends.Add(1)
+	go func() {
+		defer backgroundSends.Done()
+		defer recoverPeerPanic(peer)
+		send()
+	}()
+}
+
+func waitBackgroundSends() {
+	backgroundMutex.Lock()
+	backgroundStopped = true
+	backgroundMutex.Unlock()
+
+	backgroundSends.Wait()
+}
+
+// handlers still panic on malformed messages, a bad peer must not take the node and its database down
+func recoverPeerPanic(peer string) {
+	if r := recover(); r != nil {
+		utility.Errorf("Recovered from a panic while talking to %s: %v", peer, r)
 	}
 }
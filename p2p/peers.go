@@ -0,0 +1,109 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+)
+
+// hosts the operator banned, every port of a banned host is refused
+var BannedPeers = make(map[string]bool)
+
+// host part of a host:port peer address, the address itself if it has no port
+func peerHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+func IsBanned(address string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return BannedPeers[peerHost(address)]
+}
+
+// copy of the known nodes, safe to hand out to the api
+func Peers() []string {
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	return append([]string{}, KnownNodes...)
+}
+
+func Banned() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	banned := []string{}
+	for host := range BannedPeers {
+		banned = append(banned, host)
+	}
+	sort.Strings(banned)
+	return banned
+}
+
+// adds the host:port to the address book and the known nodes, even past the outbound target
+// and sends it our version, so the chains of both nodes get compared
+func AddPeer(address string, chain *blockchain.BlockChain) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("bad peer address %q: %v", address, err)
+	}
+	if IsBanned(address) {
+		return fmt.Errorf("peer %s is banned", address)
+	}
+	if address == nodeAddress {
+		return errors.New("a node can not be its own peer")
+	}
+
+	addressMutex.Lock()
+	addToBook(address, SOURCE_MANUAL)
+	if !contains(KnownNodes, address) {
+		KnownNodes = append(append([]string{}, KnownNodes...), address)
+	}
+	addressMutex.Unlock()
+
+	goSend(address, func() {
+		SendVersion(address, chain)
+	})
+	return nil
+}
+
+// bans the host of the address (host or host:port) and forgets every peer on it, the removed known nodes are returned
+func BanPeer(address string) []string {
+	host := peerHost(address)
+
+	mutex.Lock()
+	BannedPeers[host] = true
+	mutex.Unlock()
+
+	addressMutex.Lock()
+	defer addressMutex.Unlock()
+
+	for peer := range addressBook {
+		if peerHost(peer) == host {
+			delete(addressBook, peer)
+		}
+	}
+	var remainingNodes, removedNodes []string
+	for _, node := range KnownNodes {
+		if peerHost(node) == host {
+			removedNodes = append(removedNodes, node)
+		} else {
+			remainingNodes = append(remainingNodes, node)
+		}
+	}
+	KnownNodes = remainingNodes
+	selectOutbound()
+	return removedNodes
+}
+
+// address this node advertises to its peers, empty until the p2p server listens
+func NodeAddress() string {
+	return nodeAddress
+}
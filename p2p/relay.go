@@ -0,0 +1,135 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pranjalpokharel7/yudhishthira/blockchain"
+)
+
+const (
+	SEEN_INVENTORY_EXPIRY = 30 * time.Minute // an inventory is announced and fetched at most once in this time
+	MAX_SEEN_INVENTORY    = 50000            // expired entries are pruned once this many are tracked
+)
+
+var (
+	// inventories this node already has, requested or announced, keyed by type and hex id
+	seenInventory = make(map[string]time.Time)
+	seenMutex     sync.Mutex
+)
+
+func inventoryKey(kind MESSAGE_TYPE, id []byte) string {
+	return fmt.Sprintf("%d:%s", kind, hex.EncodeToString(id))
+}
+
+// true if the inventory was relayed or announced within SEEN_INVENTORY_EXPIRY
+func inventorySeen(kind MESSAGE_TYPE, id []byte) bool {
+	seenMutex.Lock()
+	defer seenMutex.Unlock()
+
+	seenAt, ok := seenInventory[inventoryKey(kind, id)]
+	return ok && time.Since(seenAt) < SEEN_INVENTORY_EXPIRY
+}
+
+// records the inventory, true if it was not seen before
+// relaying only what is new is what stops an inventory from circling the network forever
+func markInventorySeen(kind MESSAGE_TYPE, id []byte) bool {
+	seenMutex.Lock()
+	defer seenMutex.Unlock()
+
+	key := inventoryKey(kind, id)
+	if seenAt, ok := seenInventory[key]; ok && time.Since(seenAt) < SEEN_INVENTORY_EXPIRY {
+		return false
+	}
+	if len(seenInventory) >= MAX_SEEN_INVENTORY {
+		for seenKey, seenAt := range seenInventory {
+			if time.Since(seenAt) >= SEEN_INVENTORY_EXPIRY {
+				delete(seenInventory, seenKey)
+			}
+		}
+	}
+	seenInventory[key] = time.Now()
+	return true
+}
+
+// known nodes and every other peer that completed the handshake with us, eg. one that connected on its own
+func relayPeers() []string {
+	peers := Peers()
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	for address, state := range handshakes {
+		if state.version != nil && state.acked && !contains(peers, address) {
+			peers = append(peers, address)
+		}
+	}
+	return peers
+}
+
+// announces the inventories to every peer except the one they came from, in the background
+func relayInventory(kind MESSAGE_TYPE, ids [][]byte, except string) {
+	peers := relayPeers()
+	go func() {
+		for _, node := range peers {
+			if node == except {
+				continue
+			}
+			func() {
+				defer recoverPeerPanic(node)
+				sendInv(node, kind, ids)
+			}()
+		}
+	}()
+}
+
+// sends the whole transaction to every peer except the one it came from, in the background
+// swaps gather signatures under the same id, so every copy with new signatures is pushed instead of announced
+// a copy without new ones is rejected as duplicate by the pool of the peer, which ends the push there
+func pushTx(tx blockchain.Tx, except string) {
+	peers := relayPeers()
+	go func() {
+		for _, node := range peers {
+			if node == except {
+				continue
+			}
+			func() {
+				defer recoverPeerPanic(node)
+				if tx.IsPending() {
+					SendOffer(node, tx)
+				} else {
+					SendTx(node, tx)
+				}
+			}()
+		}
+	}()
+}
+
+// announces a transaction the node accepted into one of its pools, peers fetch it with getdata
+func AnnounceTx(tx blockchain.Tx) {
+	relayTx(tx, "")
+}
+
+// a transaction accepted from a peer goes on to the other peers
+func relayTx(tx blockchain.Tx, addrFrom string) {
+	if tx.Type == blockchain.TX_SWAP {
+		pushTx(tx, addrFrom)
+		return
+	}
+	if markInventorySeen(TX_TYPE, tx.TxID) {
+		relayInventory(TX_TYPE, [][]byte{tx.TxID}, addrFrom)
+	}
+}
+
+// announces a block this node mined, peers that miss it fetch it with getdata
+func AnnounceBlock(block *blockchain.Block) {
+	relayBlock(block, "")
+}
+
+// a block that became our tip goes on to the other peers
+func relayBlock(block *blockchain.Block, addrFrom string) {
+	if markInventorySeen(BLOCK_TYPE, block.BlockHash) {
+		relayInventory(BLOCK_TYPE, [][]byte{block.BlockHash}, addrFrom)
+	}
+}
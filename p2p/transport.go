@@ -0,0 +1,218 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	NODE_KEY_PEM_TYPE     = "PRIVATE KEY"             // the node key is stored as PKCS #8 PEM
+	NODE_CERT_VALIDITY    = 10 * 365 * 24 * time.Hour // the certificate is made anew on every start, peers only look at its key
+	NODE_ID_LENGTH        = ed25519.PublicKeySize
+	TLS_HANDSHAKE_TIMEOUT = DIAL_TIMEOUT // a peer slower than this to complete the handshake is dropped
+)
+
+var (
+	// identifies the node on the network, independent of the wallet that owns items
+	nodeKey ed25519.PrivateKey
+	// both nil unless peer connections are encrypted
+	serverTLSConfig *tls.Config
+	clientTLSConfig *tls.Config
+	// node ids allowed to connect, every node is allowed if empty
+	allowedNodes = make(map[string]bool)
+)
+
+// hex of the public node key, what peers and allowlists know the node by
+func NodeID(publicKey ed25519.PublicKey) string {
+	return hex.EncodeToString(publicKey)
+}
+
+// node id of this node, empty before SetupTransport
+func LocalNodeID() string {
+	if nodeKey == nil {
+		return ""
+	}
+	return NodeID(nodeKey.Public().(ed25519.PublicKey))
+}
+
+func ParseNodeID(nodeID string) (ed25519.PublicKey, error) {
+	publicKey, err := hex.DecodeString(nodeID)
+	if err != nil || len(publicKey) != NODE_ID_LENGTH {
+		return nil, fmt.Errorf("node id %q is not %d hex encoded bytes", nodeID, NODE_ID_LENGTH)
+	}
+	return ed25519.PublicKey(publicKey), nil
+}
+
+// reads the node key, a new one is generated and saved if the file does not exist
+func LoadOrCreateNodeKey(keyFile string) (ed25519.PrivateKey, bool, error) {
+	fileContent, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, false, err
+		}
+		encoded, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, false, err
+		}
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: NODE_KEY_PEM_TYPE, Bytes: encoded})
+		if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return nil, false, err
+		}
+		return privateKey, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	block, _ := pem.Decode(fileContent)
+	if block == nil || block.Type != NODE_KEY_PEM_TYPE {
+		return nil, false, fmt.Errorf("%s: no %s block", keyFile, NODE_KEY_PEM_TYPE)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %v", keyFile, err)
+	}
+	privateKey, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, false, fmt.Errorf("%s: node keys are ed25519 keys", keyFile)
+	}
+	return privateKey, false, nil
+}
+
+// self signed certificate for the node key, the tls handshake proves the peer holds the key it shows
+func nodeCertificate(privateKey ed25519.PrivateKey) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: NodeID(publicKey)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(NODE_CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey, privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{certificate}, PrivateKey: privateKey}, nil
+}
+
+// peers are not known by a certificate authority but by their node key, which has to be on the allowlist if there is one
+func verifyPeerKey(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("peer sent no certificate")
+	}
+	certificate, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	publicKey, ok := certificate.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("peer certificate does not hold an ed25519 node key")
+	}
+	if len(allowedNodes) != 0 && !allowedNodes[NodeID(publicKey)] {
+		return fmt.Errorf("node %s is not on the allowlist", NodeID(publicKey))
+	}
+	return nil
+}
+
+// sets the node key, and if encrypt is set the tls configs every peer connection is made with
+// allowed node ids need encryption, without it a peer can not prove its key
+func SetupTransport(privateKey ed25519.PrivateKey, encrypt bool, allowedNodeIDs []string) error {
+	if len(allowedNodeIDs) != 0 && !encrypt {
+		return errors.New("an allowlist of node keys needs encrypted connections")
+	}
+	for _, nodeID := range allowedNodeIDs {
+		publicKey, err := ParseNodeID(nodeID)
+		if err != nil {
+			return err
+		}
+		allowedNodes[NodeID(publicKey)] = true
+	}
+	nodeKey = privateKey
+	if !encrypt {
+		return nil
+	}
+
+	certificate, err := nodeCertificate(privateKey)
+	if err != nil {
+		return err
+	}
+	serverTLSConfig = &tls.Config{
+		Certificates:          []tls.Certificate{certificate},
+		MinVersion:            tls.VersionTLS13,
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: verifyPeerKey,
+	}
+	clientTLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS13,
+		// the chain is not verified against certificate authorities, verifyPeerKey checks the node key instead
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyPeerKey,
+	}
+	return nil
+}
+
+// true if peer connections are encrypted
+func Encrypted() bool {
+	return serverTLSConfig != nil
+}
+
+// connects to the peer, over tls if connections are encrypted
+func dialPeer(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(protocol, addr, DIAL_TIMEOUT)
+	if err != nil || clientTLSConfig == nil {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, clientTLSConfig)
+	tlsConn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// listens for peers, over tls if connections are encrypted
+func listenPeers(listenAddress string) (net.Listener, error) {
+	ln, err := net.Listen(protocol, listenAddress)
+	if err != nil || serverTLSConfig == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, serverTLSConfig), nil
+}
+
+// completes the tls handshake of an accepted connection and returns the node id of the peer
+// plaintext connections have no node id, an empty one is returned for them
+func acceptPeer(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return "", errors.New("peer sent no certificate")
+	}
+	return NodeID(certificates[0].PublicKey.(ed25519.PublicKey)), nil
+}
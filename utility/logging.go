@@ -0,0 +1,80 @@
+package utility
+
+import (
+	"log"
+)
+
+// log levels, messages below the configured level are dropped
+const (
+	LOG_DEBUG = "debug"
+	LOG_INFO  = "info"
+	LOG_WARN  = "warn"
+	LOG_ERROR = "error"
+)
+
+var logLevels = map[string]int{
+	LOG_DEBUG: 0,
+	LOG_INFO:  1,
+	LOG_WARN:  2,
+	LOG_ERROR: 3,
+}
+
+var logLevel = logLevels[LOG_INFO]
+
+func IsLogLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
+
+func SetLogLevel(level string) {
+	if value, ok := logLevels[level]; ok {
+		logLevel = value
+	}
+}
+
+func DebugEnabled() bool {
+	return logLevel <= logLevels[LOG_DEBUG]
+}
+
+func logAt(level string, format string, v ...interface{}) {
+	if logLevels[level] >= logLevel {
+		log.Printf("["+level+"] "+format, v...)
+	}
+}
+
+func Debugf(format string, v ...interface{}) {
+	logAt(LOG_DEBUG, format, v...)
+}
+
+func Infof(format string, v ...interface{}) {
+	logAt(LOG_INFO, format, v...)
+}
+
+func Warnf(format string, v ...interface{}) {
+	logAt(LOG_WARN, format, v...)
+}
+
+func Errorf(format string, v ...interface{}) {
+	logAt(LOG_ERROR, format, v...)
+}
+
+// logger for libraries such as badger, their messages follow the log level of the node
+type LevelLogger struct {
+	Prefix string
+}
+
+func (logger LevelLogger) Errorf(format string, v ...interface{}) {
+	Errorf(logger.Prefix+format, v...)
+}
+
+func (logger LevelLogger) Warningf(format string, v ...interface{}) {
+	Warnf(logger.Prefix+format, v...)
+}
+
+func (logger LevelLogger) Infof(format string, v ...interface{}) {
+	Infof(logger.Prefix+format, v...)
+}
+
+func (logger LevelLogger) Debugf(format string, v ...interface{}) {
+	Debugf(logger.Prefix+format, v...)
+}
@@ -11,3 +11,21 @@ type HexByte []byte
 func (hb HexByte) MarshalJSON() ([]byte, error) {
 	return json.Marshal(hex.EncodeToString(hb))
 }
+
+// reverse of MarshalJSON so that structs with hex fields can be read back from json
+func (hb *HexByte) UnmarshalJSON(data []byte) error {
+	var hexString *string
+	if err := json.Unmarshal(data, &hexString); err != nil {
+		return err
+	}
+	if hexString == nil || *hexString == "" {
+		*hb = nil
+		return nil
+	}
+	decoded, err := hex.DecodeString(*hexString)
+	if err != nil {
+		return err
+	}
+	*hb = decoded
+	return nil
+}
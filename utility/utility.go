@@ -24,33 +24,41 @@ func ErrThenLogFatal(err error) {
 	}
 }
 
-func getForwardSlashPosition(value string) int {
-	for i, c := range value {
-		if c == '/' {
-			return i
-		}
-	}
-
-	return -1
-}
-
-func GetNodeAddress() string {
-
+// address of this machine peers can most likely reach, IPv4 addresses (private ones included) come before IPv6 ones
+// loopback is only returned when there is nothing else, eg. in a container without a network
+func GetNodeAddress() (string, error) {
 	addresses, err := net.InterfaceAddrs()
 	if err != nil {
-		ErrThenLogPanic(err)
+		return "", err
 	}
 
+	var ipv6Address, loopbackAddress string
 	for _, addr := range addresses {
-		addr_string := addr.String()
-		position := getForwardSlashPosition(addr_string)
-
-		if addr_string[:3] == "192" {
-			return addr_string[:position]
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		switch {
+		case ip.IsLoopback():
+			if loopbackAddress == "" {
+				loopbackAddress = ip.String()
+			}
+		case !ip.IsGlobalUnicast():
+			// link local addresses need a zone and are useless to peers
+			continue
+		case ip.To4() != nil:
+			return ip.String(), nil
+		case ipv6Address == "":
+			ipv6Address = ip.String()
 		}
 	}
 
-	err = errors.New("Address not found")
-	log.Panic(err)
-	return ""
+	if ipv6Address != "" {
+		return ipv6Address, nil
+	}
+	if loopbackAddress != "" {
+		return loopbackAddress, nil
+	}
+	return "", errors.New("no network interface has an address")
 }
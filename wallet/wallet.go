@@ -68,18 +68,27 @@ func pubKeyHashRipeMD160(pubKey *rsa.PublicKey) ([]byte, error) {
 	return pubKeyRipMD, nil
 }
 
+// derives the public key hash that addresses and transactions refer to
+func PubKeyHashFromPublicKey(pubKey *rsa.PublicKey) ([]byte, error) {
+	return pubKeyHashRipeMD160(pubKey)
+}
+
 func (wallet *Wallet) GenerateAddress() error {
 	pubKeyHash, err := pubKeyHashRipeMD160(&wallet.PublicKey)
 	if err != nil {
 		return err
 	}
 
+	wallet.Address = []byte(AddressFromPubKeyHash(pubKeyHash))
+	return nil
+}
+
+// reverse of PubKeyHashFromAddress, lets nodes address wallets they only know by pubkey hash
+func AddressFromPubKeyHash(pubKeyHash []byte) string {
 	// not including version in hash, let's see for now
 	checksum := deriveChecksum(pubKeyHash)
-	fullHash := append(pubKeyHash, checksum...)
-	address := base58.Encode(fullHash)
-	wallet.Address = []byte(address)
-	return nil
+	fullHash := append(append([]byte{}, pubKeyHash...), checksum...)
+	return base58.Encode(fullHash)
 }
 
 func deriveChecksum(pubKeyHash []byte) []byte {
@@ -112,13 +121,22 @@ func (wallet *Wallet) SaveWalletToFile(walletFile string) error {
 	return err // if nil then nil is returned, else error is returned
 }
 
-func GenerateWallet(walletFile string) error {
+// fresh key pair and address, nothing is written to disk
+func NewWallet() (*Wallet, error) {
 	var wlt Wallet
 	err := wlt.GenerateKeyPair()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = wlt.GenerateAddress()
+	if err != nil {
+		return nil, err
+	}
+	return &wlt, nil
+}
+
+func GenerateWallet(walletFile string) error {
+	wlt, err := NewWallet()
 	if err != nil {
 		return err
 	}